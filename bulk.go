@@ -0,0 +1,234 @@
+package dbflex
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/eaciit/toolkit"
+)
+
+// DefaultBulkBatchSize is number of records written by one insert command of BulkInsert if BatchSize is not set
+const DefaultBulkBatchSize = 500
+
+// IRecordIterator is source of records for BulkInsert
+type IRecordIterator interface {
+	// Next return the next record, it return EOF or io.EOF if there is no more record
+	Next() (interface{}, error)
+}
+
+// RecordIteratorFunc is function that implements IRecordIterator
+type RecordIteratorFunc func() (interface{}, error)
+
+// Next call the function
+func (fn RecordIteratorFunc) Next() (interface{}, error) {
+	return fn()
+}
+
+// BulkOptions is optional configuration of BulkInsert
+type BulkOptions struct {
+	// BatchSize is number of records written by one insert command, default is DefaultBulkBatchSize
+	BatchSize int
+
+	// Model is struct or pointer to struct of the table. If it is set, each record is converted into the model before it is written
+	// and record that can't be converted is rejected. If the model has Validate() error method, it is called for each record
+	Model interface{}
+
+	// Validate is called for each record after it is converted into the model, record is rejected if it return error
+	Validate func(record interface{}) error
+
+	// MaxRejected stop the import when number of rejected records is more than it, 0 means no limit
+	MaxRejected int
+}
+
+// RejectedRow is a record that is not inserted by BulkInsert
+type RejectedRow struct {
+	// Index is zero based position of the record in the source
+	Index  int
+	Record interface{}
+	Err    error
+}
+
+// BulkReport is result of BulkInsert, Rejected is sorted by position of the records in the source
+type BulkReport struct {
+	Read     int
+	Inserted int
+	Rejected []*RejectedRow
+}
+
+// ErrTooManyRejected is returned by BulkInsert when number of rejected records is more than BulkOptions.MaxRejected
+var ErrTooManyRejected = errors.New("too many rejected records")
+
+type bulkRow struct {
+	index  int
+	record interface{}
+}
+
+// BulkInsert insert records of source into the table in batches, each batch is written by one insert command with slice of records.
+// Source can be ICursor (e.g. of another connection), IRecordIterator or slice. Record that fail the validation or can't be inserted
+// is reported in BulkReport instead of stopping the import. If insert of a batch fail, its records are inserted one by one
+// to find the rejected ones
+func BulkInsert(conn IConnection, table string, source interface{}, opts ...*BulkOptions) (*BulkReport, error) {
+	o := new(BulkOptions)
+	if len(opts) > 0 && opts[0] != nil {
+		o = opts[0]
+	}
+	batchSize := o.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBulkBatchSize
+	}
+
+	next, err := bulkSource(source, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	report := new(BulkReport)
+	reject := func(row bulkRow, err error) error {
+		report.Rejected = append(report.Rejected, &RejectedRow{Index: row.index, Record: row.record, Err: err})
+		if o.MaxRejected > 0 && len(report.Rejected) > o.MaxRejected {
+			return ErrTooManyRejected
+		}
+		return nil
+	}
+
+	insert := func(data interface{}) error {
+		_, err := conn.Execute(From(table).Insert(), toolkit.M{}.Set("data", data))
+		return err
+	}
+
+	batch := []bulkRow{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		defer func() {
+			batch = batch[:0]
+		}()
+
+		records := make([]interface{}, len(batch))
+		for i, row := range batch {
+			records[i] = row.record
+		}
+		if err := insert(records); err == nil {
+			report.Inserted += len(records)
+			return nil
+		}
+
+		for _, row := range batch {
+			if err := insert(row.record); err != nil {
+				if err = reject(row, err); err != nil {
+					return err
+				}
+				continue
+			}
+			report.Inserted++
+		}
+		return nil
+	}
+
+	for {
+		record, err := next()
+		if errors.Is(err, EOF) || err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, toolkit.Errorf("unable to read source. %w", err)
+		}
+
+		row := bulkRow{index: report.Read, record: record}
+		report.Read++
+
+		if row.record, err = o.validate(record); err != nil {
+			if err = reject(row, err); err != nil {
+				return report, err
+			}
+			continue
+		}
+
+		batch = append(batch, row)
+		if len(batch) == batchSize {
+			if err = flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	err = flush()
+	// Records that fail on insert are found after the later records are validated
+	sort.Slice(report.Rejected, func(i, j int) bool {
+		return report.Rejected[i].Index < report.Rejected[j].Index
+	})
+	return report, err
+}
+
+// validate convert record into the model and validate it
+func (o *BulkOptions) validate(record interface{}) (interface{}, error) {
+	if o.Model != nil {
+		t := reflect.TypeOf(o.Model)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		model := reflect.New(t).Interface()
+		if err := toolkit.Serde(record, model, ""); err != nil {
+			return record, NewError(ErrConstraint, "unable to convert record into "+t.Name(), err)
+		}
+		record = model
+
+		if v, ok := model.(interface{ Validate() error }); ok {
+			if err := v.Validate(); err != nil {
+				return record, err
+			}
+		}
+	}
+
+	if o.Validate != nil {
+		if err := o.Validate(record); err != nil {
+			return record, err
+		}
+	}
+	return record, nil
+}
+
+// bulkSource return function that read records of the source one by one
+func bulkSource(source interface{}, batchSize int) (func() (interface{}, error), error) {
+	switch src := source.(type) {
+	case IRecordIterator:
+		return src.Next, nil
+
+	case ICursor:
+		buffer := []toolkit.M{}
+		index, done := 0, false
+		return func() (interface{}, error) {
+			if index == len(buffer) {
+				if done {
+					return nil, EOF
+				}
+				buffer = []toolkit.M{}
+				if err := src.Fetchs(&buffer, batchSize).Error(); err != nil && !errors.Is(err, EOF) {
+					return nil, err
+				}
+				index, done = 0, len(buffer) < batchSize
+				if len(buffer) == 0 {
+					return nil, EOF
+				}
+			}
+			index++
+			return buffer[index-1], nil
+		}, nil
+	}
+
+	rv := reflect.ValueOf(source)
+	if rv.Kind() != reflect.Slice {
+		return nil, toolkit.Errorf("source should be ICursor, IRecordIterator or slice, got %T", source)
+	}
+	index := 0
+	return func() (interface{}, error) {
+		if index == rv.Len() {
+			return nil, EOF
+		}
+		index++
+		return rv.Index(index - 1).Interface(), nil
+	}, nil
+}
@@ -0,0 +1,489 @@
+package dbflex
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/eaciit/toolkit"
+)
+
+var (
+	// DefaultCacheTTL is default time to live of cached result
+	DefaultCacheTTL = time.Minute
+	// DefaultCacheSize is default number of result hold by in-memory LRU cache
+	DefaultCacheSize = 1000
+	// DefaultCacheMaxRows is default maximum number of rows of a cached result
+	DefaultCacheMaxRows = 1000
+)
+
+// ICache is abstraction of storage used by CacheConnection
+type ICache interface {
+	Get(key string) ([]toolkit.M, bool)
+	Set(key string, value []toolkit.M, ttl time.Duration)
+	Delete(keys ...string)
+}
+
+// IEvictNotifier is implemented by ICache that report keys it remove by itself, e.g. on eviction or expiry.
+// CacheConnection use it to forget the removed keys
+type IEvictNotifier interface {
+	OnEvict(fn func(key string))
+}
+
+// CacheOptions is configuration of CacheConnection
+type CacheOptions struct {
+	// Cache is storage of cached result, default is in-memory LRU with DefaultCacheSize
+	Cache ICache
+
+	// DefaultTTL is time to live for table that has no TTL defined in TableTTL
+	DefaultTTL time.Duration
+
+	// TableTTL is time to live per table, 0 or less means result of the table is not cached
+	TableTTL map[string]time.Duration
+
+	// MaxRows is maximum number of rows of a cached result, default is DefaultCacheMaxRows.
+	// Result that has more rows is not cached and read directly from the wrapped connection
+	MaxRows int
+}
+
+func (o *CacheOptions) ttl(table string) time.Duration {
+	if d, ok := o.TableTTL[table]; ok {
+		return d
+	}
+	return o.DefaultTTL
+}
+
+// CacheConnection is an IConnection wrapper that cache result of read commands.
+// Cached entries of a table are invalidated when Insert, Update, Delete or Save of the table is executed through it,
+// using Execute or query returned by Prepare. SQL and Command can write any table, so all cached entries are invalidated
+// when they are executed. Writes that are not run through it, e.g. by other connection or process, are only seen
+// once the cached entries expire
+type CacheConnection struct {
+	IConnection
+
+	opts *CacheOptions
+
+	mu sync.Mutex
+	// tableKeys is expiry time of cached keys of each table, keyTables is the reverse
+	tableKeys map[string]map[string]time.Time
+	keyTables map[string]string
+	// generations is increased each time a table is invalidated, and generation each time all tables are invalidated.
+	// Result read before they are changed is not cached
+	generations map[string]uint64
+	generation  uint64
+
+	evictMu sync.Mutex
+	evicted []string
+}
+
+// NewCacheConnection wrap given connection with result cache
+func NewCacheConnection(conn IConnection, opts *CacheOptions) *CacheConnection {
+	if opts == nil {
+		opts = new(CacheOptions)
+	}
+	if opts.Cache == nil {
+		opts.Cache = NewLRUCache(DefaultCacheSize)
+	}
+	if opts.DefaultTTL == 0 {
+		opts.DefaultTTL = DefaultCacheTTL
+	}
+	if opts.MaxRows == 0 {
+		opts.MaxRows = DefaultCacheMaxRows
+	}
+
+	c := new(CacheConnection)
+	c.IConnection = conn
+	c.opts = opts
+	c.tableKeys = map[string]map[string]time.Time{}
+	c.keyTables = map[string]string{}
+	c.generations = map[string]uint64{}
+	if notifier, ok := opts.Cache.(IEvictNotifier); ok {
+		notifier.OnEvict(c.onEvict)
+	}
+	return c
+}
+
+// onEvict record key that is removed by the cache, it is forgotten when the table keys are changed.
+// It doesn't lock mu since the cache could call it while mu is held
+func (c *CacheConnection) onEvict(key string) {
+	c.evictMu.Lock()
+	c.evicted = append(c.evicted, key)
+	c.evictMu.Unlock()
+}
+
+// addKey record cached key of the table, and forget evicted and expired keys. mu should be held
+func (c *CacheConnection) addKey(table, key string, ttl time.Duration) {
+	c.evictMu.Lock()
+	evicted := c.evicted
+	c.evicted = nil
+	c.evictMu.Unlock()
+	for _, k := range evicted {
+		if t, ok := c.keyTables[k]; ok {
+			delete(c.tableKeys[t], k)
+			delete(c.keyTables, k)
+		}
+	}
+
+	keys, ok := c.tableKeys[table]
+	if !ok {
+		keys = map[string]time.Time{}
+		c.tableKeys[table] = keys
+	}
+	now := time.Now()
+	for k, expired := range keys {
+		if now.After(expired) {
+			delete(keys, k)
+			delete(c.keyTables, k)
+		}
+	}
+	keys[key] = now.Add(ttl)
+	c.keyTables[key] = table
+}
+
+// Connection return the wrapped connection
+func (c *CacheConnection) Connection() IConnection {
+	return c.IConnection
+}
+
+// Cursor return cursor of cached result if available, otherwise fetch the data from wrapped connection and cache it.
+// Only MaxRows + 1 rows are fetched, if the result has more rows than MaxRows the command is run again on wrapped connection
+// and its cursor is returned uncached
+func (c *CacheConnection) Cursor(cmd ICommand, m toolkit.M) ICursor {
+	table, cacheable := cacheableCommand(cmd)
+	ttl := c.opts.ttl(table)
+	if !cacheable || ttl <= 0 {
+		return c.IConnection.Cursor(cmd, m)
+	}
+
+	fields := []string{}
+	if item, ok := cmd.Items()[QuerySelect]; ok {
+		fields, _ = item.Value.([]string)
+	}
+
+	key := CommandHash(cmd, m)
+	if rows, ok := c.opts.Cache.Get(key); ok {
+		return newCacheCursor(c, rows, fields)
+	}
+
+	c.mu.Lock()
+	generation, allGeneration := c.generations[table], c.generation
+	c.mu.Unlock()
+
+	cursor := c.IConnection.Cursor(cmd, m)
+	if cursor.Error() != nil {
+		return cursor
+	}
+
+	rows := []toolkit.M{}
+	err := cursor.Fetchs(&rows, c.opts.MaxRows+1).Error()
+	cursor.Close()
+	if err != nil && err != EOF {
+		res := new(CursorBase)
+		res.SetError(err)
+		return res
+	}
+	if len(rows) > c.opts.MaxRows {
+		return c.IConnection.Cursor(cmd, m)
+	}
+
+	// the table could be written after the rows are read, the rows are stale then
+	c.mu.Lock()
+	if c.generations[table] == generation && c.generation == allGeneration {
+		c.opts.Cache.Set(key, rows, ttl)
+		c.addKey(table, key, ttl)
+	}
+	c.mu.Unlock()
+
+	return newCacheCursor(c, rows, fields)
+}
+
+// Execute run the command on wrapped connection and invalidate cache of the table if it is a write command
+func (c *CacheConnection) Execute(cmd ICommand, m toolkit.M) (interface{}, error) {
+	res, err := c.IConnection.Execute(cmd, m)
+	c.invalidateCommand(cmd)
+	return res, err
+}
+
+// Prepare the command on wrapped connection, Execute of the returned query invalidate cache like Execute of the connection
+func (c *CacheConnection) Prepare(cmd ICommand) (IQuery, error) {
+	q, err := c.IConnection.Prepare(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheQuery{IQuery: q, conn: c, cmd: cmd}, nil
+}
+
+// invalidateCommand invalidate the table of write command, or all tables if the command is SQL or Command
+func (c *CacheConnection) invalidateCommand(cmd ICommand) {
+	items := cmd.Items()
+	_, isSQL := items[QuerySQL]
+	_, isCommand := items[QueryCommand]
+	if isSQL || isCommand {
+		c.InvalidateAll()
+		return
+	}
+	if table, isWrite := writeCommand(cmd); isWrite {
+		c.Invalidate(table)
+	}
+}
+
+// Invalidate remove all cached result of given tables
+func (c *CacheConnection) Invalidate(tables ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, table := range tables {
+		c.generations[table]++
+		keys := []string{}
+		for k := range c.tableKeys[table] {
+			keys = append(keys, k)
+			delete(c.keyTables, k)
+		}
+		if len(keys) > 0 {
+			c.opts.Cache.Delete(keys...)
+		}
+		delete(c.tableKeys, table)
+	}
+}
+
+// InvalidateAll remove all cached result
+func (c *CacheConnection) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	keys := []string{}
+	for k := range c.keyTables {
+		keys = append(keys, k)
+	}
+	if len(keys) > 0 {
+		c.opts.Cache.Delete(keys...)
+	}
+	c.tableKeys = map[string]map[string]time.Time{}
+	c.keyTables = map[string]string{}
+}
+
+// cacheQuery is query prepared by CacheConnection, it invalidate cache of the connection when it is executed
+type cacheQuery struct {
+	IQuery
+
+	conn *CacheConnection
+	cmd  ICommand
+}
+
+// Execute the query and invalidate cache of the connection if it is a write command
+func (q *cacheQuery) Execute(in toolkit.M) (interface{}, error) {
+	res, err := q.IQuery.Execute(in)
+	q.conn.invalidateCommand(q.cmd)
+	return res, err
+}
+
+// CommandHash return canonical hash of command items and parameter
+func CommandHash(cmd ICommand, m toolkit.M) string {
+	// encoding/json sort map keys, so the result is canonical regardless items order
+	h := sha256.Sum256([]byte(toolkit.JsonString(cmd.Items()) + "|" + toolkit.JsonString(m)))
+	return hex.EncodeToString(h[:])
+}
+
+func cacheableCommand(cmd ICommand) (string, bool) {
+	items := cmd.Items()
+	from, hasFrom := items[QueryFrom]
+	if !hasFrom {
+		return "", false
+	}
+	for _, op := range []string{QueryInsert, QueryUpdate, QueryDelete, QuerySave, QueryCommand, QuerySQL} {
+		if _, ok := items[op]; ok {
+			return "", false
+		}
+	}
+	table, _ := from.Value.(string)
+	return table, table != ""
+}
+
+func writeCommand(cmd ICommand) (string, bool) {
+	items := cmd.Items()
+	from, hasFrom := items[QueryFrom]
+	if !hasFrom {
+		return "", false
+	}
+	for _, op := range []string{QueryInsert, QueryUpdate, QueryDelete, QuerySave} {
+		if _, ok := items[op]; ok {
+			table, _ := from.Value.(string)
+			return table, true
+		}
+	}
+	return "", false
+}
+
+// cacheCursor is cursor that read rows from memory
+type cacheCursor struct {
+	CursorBase
+
+	rows []toolkit.M
+	pos  int
+}
+
+func newCacheCursor(conn IConnection, rows []toolkit.M, fields []string) ICursor {
+	c := new(cacheCursor)
+	c.SetThis(c)
+	c.SetConnection(conn)
+	if len(fields) > 0 {
+		c.Set(ConfigKeyFields, fields)
+	}
+	c.rows = rows
+	return c
+}
+
+// Reset move cursor back to the first row
+func (c *cacheCursor) Reset() error {
+	c.pos = 0
+	return nil
+}
+
+// Fetch next row
+func (c *cacheCursor) Fetch(out interface{}) ICursor {
+	if c.pos >= len(c.rows) {
+		c.SetError(EOF)
+		return c
+	}
+
+	if err := toolkit.Serde(c.rows[c.pos], out, ""); err != nil {
+		c.SetError(err)
+		return c
+	}
+	c.pos++
+	return c
+}
+
+// Fetchs next n rows, 0 means all remaining rows
+func (c *cacheCursor) Fetchs(out interface{}, n int) ICursor {
+	end := len(c.rows)
+	if n > 0 && c.pos+n < end {
+		end = c.pos + n
+	}
+
+	if err := toolkit.Serde(c.rows[c.pos:end], out, ""); err != nil {
+		c.SetError(err)
+		return c
+	}
+	c.pos = end
+	return c
+}
+
+// Count return number of cached rows
+func (c *cacheCursor) Count() int {
+	return len(c.rows)
+}
+
+// Close the cursor
+func (c *cacheCursor) Close() error {
+	return c.Error()
+}
+
+// LRUCache is in-memory ICache that evict least recently used entry when its size is exceeded
+type LRUCache struct {
+	sync.Mutex
+
+	size    int
+	ll      *list.List
+	items   map[string]*list.Element
+	onEvict []func(key string)
+}
+
+type lruEntry struct {
+	key     string
+	value   []toolkit.M
+	expired time.Time
+}
+
+// NewLRUCache create new LRUCache with given size
+func NewLRUCache(size int) *LRUCache {
+	c := new(LRUCache)
+	c.size = size
+	c.ll = list.New()
+	c.items = map[string]*list.Element{}
+	return c
+}
+
+// Get cached value of given key
+func (c *LRUCache) Get(key string) ([]toolkit.M, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expired) {
+		c.remove(el)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+// Set value of given key
+func (c *LRUCache) Set(key string, value []toolkit.M, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	expired := time.Now().Add(ttl)
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value = value
+		entry.expired = expired
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry{key, value, expired})
+	for c.size > 0 && c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+}
+
+// OnEvict register function that is called with key that is evicted or expired, it is called while the cache is locked
+func (c *LRUCache) OnEvict(fn func(key string)) {
+	c.Lock()
+	defer c.Unlock()
+	c.onEvict = append(c.onEvict, fn)
+}
+
+// remove evicted or expired entry
+func (c *LRUCache) remove(el *list.Element) {
+	key := el.Value.(*lruEntry).key
+	c.ll.Remove(el)
+	delete(c.items, key)
+	for _, fn := range c.onEvict {
+		fn(key)
+	}
+}
+
+// Delete given keys
+func (c *LRUCache) Delete(keys ...string) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, key := range keys {
+		if el, ok := c.items[key]; ok {
+			c.ll.Remove(el)
+			delete(c.items, key)
+		}
+	}
+}
+
+// Len return number of entries in the cache
+func (c *LRUCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.ll.Len()
+}
+
+var _ ICache = (*LRUCache)(nil)
+var _ IEvictNotifier = (*LRUCache)(nil)
+var _ IConnection = (*CacheConnection)(nil)
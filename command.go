@@ -22,7 +22,7 @@ type ICommand interface {
 	Insert(...string) ICommand
 	Update(...string) ICommand
 	Delete() ICommand
-	Save() ICommand
+	Save(...string) ICommand
 
 	Take(int) ICommand
 	Skip(int) ICommand
@@ -121,9 +121,10 @@ func (b *CommandBase) Delete() ICommand {
 	return b
 }
 
-// Save base implementation of Save method
-func (b *CommandBase) Save() ICommand {
-	b.items[QuerySave] = QueryItem{QuerySave, true}
+// Save base implementation of Save method, keys are fields used to find the existing data. If keys are not given,
+// driver use keys of the table
+func (b *CommandBase) Save(keys ...string) ICommand {
+	b.items[QuerySave] = QueryItem{QuerySave, keys}
 	return b
 }
 
@@ -2,6 +2,7 @@ package dbflex
 
 import (
 	"net/url"
+	"time"
 
 	"github.com/eaciit/toolkit"
 )
@@ -58,6 +59,9 @@ type IConnection interface {
 
 	SetKeyNameTag(string)
 	KeyNameTag() string
+
+	AddInterceptor(...Interceptor) IConnection
+	Interceptors() []Interceptor
 }
 
 // ConnectionBase is base class to implement IConnection interface
@@ -69,6 +73,8 @@ type ConnectionBase struct {
 
 	fieldNameTag string
 	keyNameTag   string
+
+	interceptors []Interceptor
 }
 
 // SetThis is setter for this
@@ -180,36 +186,86 @@ func (b *ConnectionBase) DropTable(name string) error {
 	return toolkit.Errorf("DropTable is not yet implemented")
 }
 
+// AddInterceptor add interceptors to this connection, they will run after interceptors registered for the driver
+func (b *ConnectionBase) AddInterceptor(fns ...Interceptor) IConnection {
+	b.interceptors = append(b.interceptors, fns...)
+	return b.This()
+}
+
+// Interceptors return interceptors of the driver and the connection, preceded by tracing interceptor if a tracer is set
+func (b *ConnectionBase) Interceptors() []Interceptor {
+	res := []Interceptor{}
+	if t := GetTracer(); t != nil {
+		res = append(res, tracingInterceptor(t))
+	}
+	if b.Driver != "" {
+		res = append(res, DriverInterceptors(b.Driver)...)
+	}
+	return append(res, b.interceptors...)
+}
+
+func (b *ConnectionBase) newInterceptInfo(action InterceptAction, cmd ICommand) *InterceptInfo {
+	return &InterceptInfo{
+		Action:     action,
+		Driver:     b.Driver,
+		Connection: b.This(),
+		Command:    cmd,
+	}
+}
+
 // Prepare preparing the given command to a query
 func (b *ConnectionBase) Prepare(cmd ICommand) (IQuery, error) {
-	var dbCmd interface{}
-
 	if b.This().State() != StateConnected {
 		return nil, toolkit.Errorf("no valid connection")
 	}
 
-	q := b.This().NewQuery()
-	q.SetCommand(cmd)
-	err := buildGroupedQueryItems(cmd, q)
-	if err == nil {
-		dbCmd, err = q.This().BuildCommand()
-	}
+	info := b.newInterceptInfo(InterceptPrepare, cmd)
+	err := runInterceptors(b.This().Interceptors(), info, func(info *InterceptInfo) error {
+		q := b.This().NewQuery()
+		q.SetCommand(info.Command)
+		err := buildGroupedQueryItems(info.Command, q)
+		if err == nil {
+			info.NativeCommand, err = q.This().BuildCommand()
+		}
 
+		if err != nil {
+			return toolkit.Errorf("unable to parse command. %w", err)
+		}
+		info.Query = q
+		return nil
+	})
 	if err != nil {
-		return nil, toolkit.Errorf("unable to parse command. %s", err)
+		return nil, err
+	}
+
+	if info.Query == nil {
+		return nil, toolkit.Errorf("unable to prepare command. no query is returned by interceptor")
 	}
-	q.SetConfig(ConfigKeyCommand, dbCmd)
-	return q, nil
+	info.Query.SetConfig(ConfigKeyCommand, info.NativeCommand)
+	return info.Query, nil
 }
 
 // Execute given command and M data
 func (b *ConnectionBase) Execute(c ICommand, m toolkit.M) (interface{}, error) {
 	q, err := b.Prepare(c)
 	if err != nil {
-		return nil, toolkit.Errorf("unable to prepare query. %s", err.Error())
+		return nil, toolkit.Errorf("unable to prepare query. %w", err)
 	}
 	q.SetConnection(b.This())
-	return q.Execute(m)
+
+	info := b.newInterceptInfo(InterceptExecute, q.Command())
+	info.Query = q
+	info.NativeCommand = q.Config(ConfigKeyCommand, nil)
+	info.Parm = m
+	err = runInterceptors(b.This().Interceptors(), info, func(info *InterceptInfo) error {
+		var err error
+		info.Query.SetConfig(ConfigKeyCommand, info.NativeCommand)
+		info.Result, err = info.Query.Execute(info.Parm)
+		return err
+	})
+	execRes, _ := ToExecResult(info.Result)
+	logQuery(info, int(execRes.RowsAffected), time.Since(info.StartTime), err)
+	return info.Result, err
 }
 
 // Cursor return the cursor of given command and M data
@@ -218,16 +274,43 @@ func (b *ConnectionBase) Cursor(c ICommand, m toolkit.M) ICursor {
 	if err != nil {
 		//return nil, toolkit.Errorf("usnable to prepare query. %s", err.Error())
 		cursor := new(CursorBase)
-		cursor.SetError(toolkit.Errorf("unable to prepare query. %s", err.Error()))
+		cursor.SetError(toolkit.Errorf("unable to prepare query. %w", err))
 		return cursor
 	}
-	cursor := q.Cursor(m)
+
+	info := b.newInterceptInfo(InterceptCursor, q.Command())
+	info.Query = q
+	info.NativeCommand = q.Config(ConfigKeyCommand, nil)
+	info.Parm = m
+	err = runInterceptors(b.This().Interceptors(), info, func(info *InterceptInfo) error {
+		info.Query.SetConfig(ConfigKeyCommand, info.NativeCommand)
+		info.Cursor = info.Query.Cursor(info.Parm)
+		return nil
+	})
+	if err == nil && info.Cursor == nil {
+		err = toolkit.Errorf("no cursor is returned by interceptor")
+	}
+	if err != nil {
+		logQuery(info, 0, time.Since(info.StartTime), err)
+		cursor := new(CursorBase)
+		cursor.SetError(err)
+		return cursor
+	}
+
+	cursor := info.Cursor
 	cursor.SetConnection(b.This())
+	if fields, ok := info.Query.Config(ConfigKeyFields, nil).([]string); ok {
+		cursor.Set(ConfigKeyFields, fields)
+	}
+	if QueryLog().active() {
+		return &logCursor{ICursor: cursor, info: info}
+	}
 	return cursor
 }
 
 // ServerInfo hold the server information data
 type ServerInfo struct {
+	Driver                         string
 	Host, User, Password, Database string
 	Config                         toolkit.M
 }
@@ -245,6 +328,7 @@ func NewConnection(schema, host, dbname, user, password string, config toolkit.M
 	driver := schema
 	if fn, ok := drivers[driver]; ok {
 		si := new(ServerInfo)
+		si.Driver = driver
 		si.Host = host
 		if dbname != "" {
 			si.Database = dbname
@@ -265,7 +349,7 @@ func NewConnection(schema, host, dbname, user, password string, config toolkit.M
 		}
 		return fn(si), nil
 	}
-	return nil, toolkit.Errorf("driver %s is unknown", driver)
+	return nil, NewError(ErrUnknownDriver, toolkit.Sprintf("driver %s is unknown", driver), nil)
 }
 
 // EnsureTable ensure table is exist with respective structure
@@ -298,6 +382,7 @@ func NewConnectionFromURI(uri string, config toolkit.M) (IConnection, error) {
 	driver := u.Scheme
 	if fn, ok := drivers[driver]; ok {
 		si := new(ServerInfo)
+		si.Driver = driver
 		si.Host = u.Host
 		if len(u.Path) > 1 {
 			si.Database = u.Path[1:]
@@ -321,7 +406,7 @@ func NewConnectionFromURI(uri string, config toolkit.M) (IConnection, error) {
 		}
 		return fn(si), nil
 	}
-	return nil, toolkit.Errorf("driver %s is unknown", driver)
+	return nil, NewError(ErrUnknownDriver, toolkit.Sprintf("driver %s is unknown", driver), nil)
 }
 
 // From set tableName
@@ -0,0 +1,263 @@
+// Package filecodec provides transparent compression of table files for file based drivers like json and text.
+// Compressed table file has the codec extension after the table extension, e.g. orders.json.gz.
+// Only gzip is built in since the standard library has no zstd and dbflex doesn't depend on a compression library,
+// zstd can be used by registering its codec using Register.
+// Compressed file is replaced by a new file when it is written, so drivers lock the table using filemeta.LockPath
+// instead of the table file
+package filecodec
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/eaciit/toolkit"
+)
+
+// Codec is a compression format of table files
+type Codec struct {
+	// Name is used on driver config, e.g. compression=gzip
+	Name string
+	// Extension is file extension without dot, e.g. gz
+	Extension string
+
+	NewReader func(io.Reader) (io.ReadCloser, error)
+	NewWriter func(io.Writer) (io.WriteCloser, error)
+}
+
+var (
+	codecs   = []*Codec{}
+	codecsMu sync.RWMutex
+)
+
+func init() {
+	Register(&Codec{
+		Name:      "gzip",
+		Extension: "gz",
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+	})
+}
+
+// Register add or replace codec with the same name. Codec that need external library like zstd can be registered
+// by the application, e.g. using github.com/klauspost/compress/zstd with name zstd and extension zst
+func Register(codec *Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	for i, c := range codecs {
+		if strings.EqualFold(c.Name, codec.Name) {
+			codecs[i] = codec
+			return
+		}
+	}
+	codecs = append(codecs, codec)
+}
+
+// Codecs return all registered codecs
+func Codecs() []*Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	res := make([]*Codec, len(codecs))
+	copy(res, codecs)
+	return res
+}
+
+// ByName return codec of given name, it return nil if codec is not registered
+func ByName(name string) *Codec {
+	for _, c := range Codecs() {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// ByFileName return codec of given file name based on its extension, it return nil if file is not compressed
+func ByFileName(name string) *Codec {
+	_, codec := Split(name)
+	return codec
+}
+
+// Split return file name without codec extension and the codec, codec is nil if file is not compressed
+func Split(name string) (string, *Codec) {
+	lname := strings.ToLower(name)
+	for _, c := range Codecs() {
+		if strings.HasSuffix(lname, "."+strings.ToLower(c.Extension)) {
+			return name[:len(name)-len(c.Extension)-1], c
+		}
+	}
+	return name, nil
+}
+
+// TablePath return path of table file. Existing plain file is preferred over compressed file,
+// if none of them exist the file is compressed using given compression (codec name), empty compression means plain file
+func TablePath(dirPath, tableName, extension, compression string) (string, error) {
+	plainPath := filepath.Join(dirPath, tableName+"."+extension)
+	if _, err := os.Stat(plainPath); err == nil {
+		return plainPath, nil
+	}
+
+	for _, c := range Codecs() {
+		p := plainPath + "." + c.Extension
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	if compression == "" {
+		return plainPath, nil
+	}
+	codec := ByName(compression)
+	if codec == nil {
+		return "", toolkit.Errorf("unknown compression %s", compression)
+	}
+	return plainPath + "." + codec.Extension, nil
+}
+
+// Open open file for reading, content of compressed file is decompressed on the fly.
+// It return *os.File if the file is not compressed
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	codec := ByFileName(path)
+	if codec == nil {
+		return f, nil
+	}
+
+	r, err := codec.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, toolkit.Errorf("unable to decompress %s. %w", path, err)
+	}
+	return &readCloser{r, f}, nil
+}
+
+type readCloser struct {
+	io.ReadCloser
+	f *os.File
+}
+
+func (r *readCloser) Close() error {
+	r.ReadCloser.Close()
+	return r.f.Close()
+}
+
+// WriteFile write data to compressed file atomically
+func WriteFile(path string, data []byte) error {
+	codec := ByFileName(path)
+	if codec == nil {
+		return ioutil.WriteFile(path, data, 0644)
+	}
+
+	return writeAtomic(path, func(w io.Writer) error {
+		cw, err := codec.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		if _, err = cw.Write(data); err != nil {
+			cw.Close()
+			return err
+		}
+		return cw.Close()
+	})
+}
+
+// Decompress write content of compressed file to a new temporary plain file next to it and return its path.
+// Caller should remove the temporary file
+func Decompress(path string) (string, error) {
+	r, err := Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	plainPath, _ := Split(path)
+	plainPath += "_plain_" + toolkit.RandomString(16)
+	f, err := os.Create(plainPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err = io.Copy(f, r); err != nil {
+		os.Remove(plainPath)
+		return "", toolkit.Errorf("unable to decompress %s. %w", path, err)
+	}
+	return plainPath, nil
+}
+
+// Compress write content of plain file to compressed file atomically, the compressed file is replaced only if compression succeed
+func Compress(plainPath, path string) error {
+	codec := ByFileName(path)
+	if codec == nil {
+		return toolkit.Errorf("%s is not a compressed file", path)
+	}
+
+	src, err := os.Open(plainPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return writeAtomic(path, func(w io.Writer) error {
+		cw, err := codec.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(cw, src); err != nil {
+			cw.Close()
+			return err
+		}
+		return cw.Close()
+	})
+}
+
+// Edit decompress file to a temporary plain file, call fn with path of the plain file and compress it back if fn succeed.
+// fn is called with the path itself if the file is not compressed
+func Edit(path string, fn func(plainPath string) error) error {
+	if ByFileName(path) == nil {
+		return fn(path)
+	}
+
+	plainPath, err := Decompress(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(plainPath)
+
+	if err = fn(plainPath); err != nil {
+		return err
+	}
+	return Compress(plainPath, path)
+}
+
+func writeAtomic(path string, write func(io.Writer) error) error {
+	tmpPath := path + "_temp_" + toolkit.RandomString(16)
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if err = write(f); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return toolkit.Errorf("unable to write %s. %w", path, err)
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,500 @@
+// Package filemeta holds table metadata (keys, indexes and columns) for file based drivers like json and text.
+// Metadata of all tables in a directory is stored in a single file on that directory
+package filemeta
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/eaciit/toolkit"
+	flock "github.com/theckman/go-flock"
+)
+
+const (
+	// FileName is name of metadata file on the database directory
+	FileName = "_dbflex.meta"
+	// Prefix is prefix of all files created by this package, driver should exclude it from object names
+	Prefix = "_dbflex"
+	// PrimaryIndexName is name of the index created from table keys
+	PrimaryIndexName = "primary"
+)
+
+// IndexType is type of non unique index, it decide which filter can use the index
+type IndexType string
+
+const (
+	// IndexHash is index for equality filter ($eq and $in)
+	IndexHash IndexType = "hash"
+	// IndexSorted is index for range filter ($gt, $gte, $lt, $lte and $range) and sorting. It also can be used for equality filter
+	IndexSorted IndexType = "sorted"
+)
+
+// ColumnType is data type of column value, it is used by driver that stores values as text
+type ColumnType string
+
+const (
+	// ColumnString is text column
+	ColumnString ColumnType = "string"
+	// ColumnInt is integer column
+	ColumnInt ColumnType = "int"
+	// ColumnFloat is decimal number column
+	ColumnFloat ColumnType = "float"
+	// ColumnBool is boolean column
+	ColumnBool ColumnType = "bool"
+	// ColumnDate is date and time column
+	ColumnDate ColumnType = "date"
+)
+
+// LockTimeout is max time to wait for metadata lock
+var LockTimeout = 30 * time.Second
+
+// Index is definition of table index
+type Index struct {
+	Name   string    `json:"name"`
+	Fields []string  `json:"fields"`
+	Unique bool      `json:"unique"`
+	Type   IndexType `json:"type,omitempty"`
+}
+
+// Column is definition of table column. Empty value of a column that is not nullable is replaced by its default
+type Column struct {
+	Name       string     `json:"name"`
+	Type       ColumnType `json:"type"`
+	Nullable   bool       `json:"nullable,omitempty"`
+	DateFormat string     `json:"dateformat,omitempty"`
+	Default    string     `json:"default,omitempty"`
+}
+
+// Table is metadata of a table
+type Table struct {
+	Keys    []string  `json:"keys"`
+	Indexes []*Index  `json:"indexes"`
+	Columns []*Column `json:"columns,omitempty"`
+}
+
+// Meta is metadata of all tables within a directory
+type Meta struct {
+	Tables map[string]*Table `json:"tables"`
+}
+
+// IsMetaFile return true if given file name is created by this package
+func IsMetaFile(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), Prefix)
+}
+
+// LockPath return path of the lock file of a table. Table file is not locked itself since it could be replaced
+// by a new file, e.g. on compression or compaction, and process that wait for the old file would not exclude the others
+func LockPath(dir, table string) string {
+	return filepath.Join(dir, Prefix+"."+strings.ToLower(table)+".lock")
+}
+
+// Load read metadata of given directory, it return empty metadata if file is not exist
+func Load(dir string) (*Meta, error) {
+	m := &Meta{Tables: map[string]*Table{}}
+	bs, err := ioutil.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, toolkit.Errorf("unable to read metadata. %w", err)
+	}
+
+	if len(bs) > 0 {
+		if err = json.Unmarshal(bs, m); err != nil {
+			return nil, toolkit.Errorf("unable to parse metadata. %w", err)
+		}
+	}
+	if m.Tables == nil {
+		m.Tables = map[string]*Table{}
+	}
+	return m, nil
+}
+
+// LoadTable return metadata of given table, it return nil if table has no metadata
+func LoadTable(dir, table string) (*Table, error) {
+	m, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	return m.Tables[strings.ToLower(table)], nil
+}
+
+// Update lock the metadata of given directory, call fn and write the changes
+func Update(dir string, fn func(*Meta) error) error {
+	lockCtx, cancel := context.WithTimeout(context.Background(), LockTimeout)
+	defer cancel()
+
+	lockPath := filepath.Join(dir, FileName+".lock")
+	fileLock := flock.NewFlock(lockPath)
+	if _, err := fileLock.TryLockContext(lockCtx, 10*time.Millisecond); err != nil {
+		return dbflex.NewError(dbflex.ErrLockTimeout, toolkit.Sprintf("unable to lock file %s", lockPath), err)
+	}
+	defer fileLock.Unlock()
+
+	m, err := Load(dir)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(m); err != nil {
+		return err
+	}
+
+	bs, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to temp file then rename it, so reader never get partially written file
+	tmpPath := filepath.Join(dir, FileName+"_temp_"+toolkit.RandomString(16))
+	if err = ioutil.WriteFile(tmpPath, bs, 0644); err != nil {
+		return toolkit.Errorf("unable to write metadata. %w", err)
+	}
+	return os.Rename(tmpPath, filepath.Join(dir, FileName))
+}
+
+// SetTable set metadata of given table
+func SetTable(dir, table string, t *Table) error {
+	return Update(dir, func(m *Meta) error {
+		m.Tables[strings.ToLower(table)] = t
+		return nil
+	})
+}
+
+// DeleteTable remove metadata of given table
+func DeleteTable(dir, table string) error {
+	return Update(dir, func(m *Meta) error {
+		delete(m.Tables, strings.ToLower(table))
+		return nil
+	})
+}
+
+// NewTable create table metadata from given keys and object. If keys is empty, it is taken from fields of obj
+// that has keyTag. Fields that has "unique" tag will be added as unique index, fields that has the same unique tag value
+// will be combined as one index. Fields that has "index" tag with value hash or sorted will be added as secondary index
+func NewTable(keys []string, obj interface{}, fieldTag, keyTag string) *Table {
+	t := new(Table)
+	t.Keys = keys
+
+	unique := map[string]*Index{}
+	uniqueNames := []string{}
+	fields, _ := structFields(obj, fieldTag)
+	for _, f := range fields {
+		if len(keys) == 0 && keyTag != "" && f.tag.Get(keyTag) != "" {
+			t.Keys = append(t.Keys, f.name)
+		}
+
+		if name := f.tag.Get("unique"); name != "" {
+			if name == "1" || name == "true" {
+				name = f.name
+			}
+			idx, ok := unique[name]
+			if !ok {
+				idx = &Index{Name: name, Unique: true}
+				unique[name] = idx
+				uniqueNames = append(uniqueNames, name)
+			}
+			idx.Fields = append(idx.Fields, f.name)
+		}
+
+		if idxType := IndexType(strings.ToLower(f.tag.Get("index"))); idxType == IndexHash || idxType == IndexSorted {
+			t.Indexes = append(t.Indexes, &Index{Name: f.name, Fields: []string{f.name}, Type: idxType})
+		}
+	}
+
+	for _, name := range uniqueNames {
+		t.Indexes = append(t.Indexes, unique[name])
+	}
+	return t
+}
+
+// NewColumns create column definitions from fields of obj, type of the column is taken from the field type.
+// Pointer field is nullable, tag "nullable", "dateformat" and "default" can be used to set the other attributes.
+// Field that has no matching column type like struct, map or slice is skipped
+func NewColumns(obj interface{}, fieldTag string) []*Column {
+	cols := []*Column{}
+	fields, _ := structFields(obj, fieldTag)
+	for _, f := range fields {
+		rt := f.typ
+		nullable := false
+		if rt.Kind() == reflect.Ptr {
+			rt = rt.Elem()
+			nullable = true
+		}
+
+		col := &Column{Name: f.name}
+		switch rt.Kind() {
+		case reflect.String:
+			col.Type = ColumnString
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			col.Type = ColumnInt
+		case reflect.Float32, reflect.Float64:
+			col.Type = ColumnFloat
+		case reflect.Bool:
+			col.Type = ColumnBool
+		default:
+			if rt != reflect.TypeOf(time.Time{}) {
+				continue
+			}
+			col.Type = ColumnDate
+		}
+
+		if tag := f.tag.Get("nullable"); tag != "" {
+			nullable = tag == "1" || tag == "true"
+		}
+		col.Nullable = nullable
+		col.DateFormat = f.tag.Get("dateformat")
+		col.Default = f.tag.Get("default")
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+// Column return definition of given column, it return nil if column is not defined
+func (t *Table) Column(name string) *Column {
+	if t == nil {
+		return nil
+	}
+
+	for _, col := range t.Columns {
+		if strings.EqualFold(col.Name, name) {
+			return col
+		}
+	}
+	return nil
+}
+
+// SetColumns add or replace columns with the same name
+func (t *Table) SetColumns(cols ...*Column) {
+	for _, col := range cols {
+		replaced := false
+		for i, existing := range t.Columns {
+			if strings.EqualFold(existing.Name, col.Name) {
+				t.Columns[i] = col
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			t.Columns = append(t.Columns, col)
+		}
+	}
+}
+
+// SetIndex add or replace index with the same name
+func (t *Table) SetIndex(idx *Index) {
+	for i, existing := range t.Indexes {
+		if strings.EqualFold(existing.Name, idx.Name) {
+			t.Indexes[i] = idx
+			return
+		}
+	}
+	t.Indexes = append(t.Indexes, idx)
+}
+
+// DeleteIndex remove index with given name, it return false if index is not exist
+func (t *Table) DeleteIndex(name string) bool {
+	for i, existing := range t.Indexes {
+		if strings.EqualFold(existing.Name, name) {
+			t.Indexes = append(t.Indexes[:i], t.Indexes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SecondaryIndexes return single field index that has hash or sorted type
+func (t *Table) SecondaryIndexes() []*Index {
+	if t == nil {
+		return nil
+	}
+
+	res := []*Index{}
+	for _, idx := range t.Indexes {
+		if (idx.Type == IndexHash || idx.Type == IndexSorted) && len(idx.Fields) == 1 {
+			res = append(res, idx)
+		}
+	}
+	return res
+}
+
+// UniqueIndexes return primary key index and all unique indexes
+func (t *Table) UniqueIndexes() []*Index {
+	if t == nil {
+		return nil
+	}
+
+	res := []*Index{}
+	if len(t.Keys) > 0 {
+		res = append(res, &Index{Name: PrimaryIndexName, Fields: t.Keys, Unique: true})
+	}
+	for _, idx := range t.Indexes {
+		if idx.Unique {
+			res = append(res, idx)
+		}
+	}
+	return res
+}
+
+// KeyFields return keys of the table, or defaultKeys if table has no keys
+func (t *Table) KeyFields(defaultKeys ...string) []string {
+	if t == nil || len(t.Keys) == 0 {
+		return defaultKeys
+	}
+	return t.Keys
+}
+
+// KeyValue return key of a record using KeyFields, it is value of the key field or slice of values for compound key.
+// It return nil if any key field is not exist in the record
+func (t *Table) KeyValue(m map[string]interface{}, defaultKeys ...string) interface{} {
+	keys := t.KeyFields(defaultKeys...)
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		v, ok := Lookup(m, k)
+		if !ok {
+			return nil
+		}
+		values[i] = v
+	}
+
+	switch len(values) {
+	case 0:
+		return nil
+	case 1:
+		return values[0]
+	}
+	return values
+}
+
+// UniqueChecker check duplicate of key and unique indexes values of records
+type UniqueChecker struct {
+	table   string
+	indexes []*Index
+	seen    []map[string]bool
+}
+
+// NewUniqueChecker create UniqueChecker for given table, it return nil if table has no unique index
+func NewUniqueChecker(table string, t *Table) *UniqueChecker {
+	indexes := t.UniqueIndexes()
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	c := new(UniqueChecker)
+	c.table = table
+	c.indexes = indexes
+	c.seen = make([]map[string]bool, len(indexes))
+	for i := range c.seen {
+		c.seen[i] = map[string]bool{}
+	}
+	return c
+}
+
+// Check add a record to the checker, get should return text representation of a field value.
+// It return dbflex.ErrDuplicateKey error if the record has the same values with previous record on any unique index.
+// Record that has missing or empty value on an index is not checked for that index
+func (c *UniqueChecker) Check(get func(field string) (string, bool)) error {
+	if c == nil {
+		return nil
+	}
+
+	for i, idx := range c.indexes {
+		values := make([]string, len(idx.Fields))
+		complete := true
+		for fi, field := range idx.Fields {
+			v, ok := get(field)
+			if !ok || v == "" {
+				complete = false
+				break
+			}
+			values[fi] = v
+		}
+		if !complete {
+			continue
+		}
+
+		key := strings.Join(values, "\x00")
+		if c.seen[i][key] {
+			return dbflex.NewError(dbflex.ErrDuplicateKey,
+				toolkit.Sprintf("duplicate key on %s.%s (%s): %s", c.table, idx.Name, strings.Join(idx.Fields, ","), strings.Join(values, ",")), nil).
+				WithTable(c.table).WithField(strings.Join(idx.Fields, ","))
+		}
+		c.seen[i][key] = true
+	}
+	return nil
+}
+
+// Lookup get value of field from given map, field is case insensitive and can be a dot separated path of nested map
+func Lookup(m map[string]interface{}, field string) (interface{}, bool) {
+	if v, ok := m[field]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, field) {
+			return v, true
+		}
+	}
+
+	parts := strings.SplitN(field, ".", 2)
+	if len(parts) == 2 {
+		for k, v := range m {
+			if !strings.EqualFold(k, parts[0]) {
+				continue
+			}
+			switch sub := v.(type) {
+			case map[string]interface{}:
+				return Lookup(sub, parts[1])
+			case toolkit.M:
+				return Lookup(sub, parts[1])
+			}
+		}
+	}
+	return nil, false
+}
+
+type structField struct {
+	name string
+	tag  reflect.StructTag
+	typ  reflect.Type
+}
+
+func structFields(obj interface{}, fieldTag string) ([]structField, bool) {
+	if obj == nil {
+		return nil, false
+	}
+
+	rt := reflect.TypeOf(obj)
+	for rt.Kind() == reflect.Ptr || rt.Kind() == reflect.Slice {
+		rt = rt.Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	res := []structField{}
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+		if fieldTag != "" {
+			if tagName := strings.Split(f.Tag.Get(fieldTag), ",")[0]; tagName == "-" {
+				continue
+			} else if tagName != "" {
+				name = tagName
+			}
+		}
+		res = append(res, structField{name, f.Tag, f.Type})
+	}
+	return res, true
+}
@@ -8,6 +8,8 @@ import (
 	"sync"
 
 	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filecodec"
+	"git.kanosolution.net/kano/dbflex/drivers/filemeta"
 	"github.com/eaciit/toolkit"
 )
 
@@ -30,9 +32,11 @@ type Connection struct {
 	dbflex.ConnectionBase
 	sync.Mutex
 
-	dirInfo   os.FileInfo
-	dirPath   string
-	extension string
+	dirInfo     os.FileInfo
+	dirPath     string
+	extension   string
+	format      string
+	compression string
 }
 
 // Connect will search for directory database from give path, if path is not found or is not a directory will return error
@@ -55,6 +59,14 @@ func (c *Connection) Connect() error {
 	c.dirPath = dirpath
 
 	c.extension = c.Config.Get("extension", "").(string)
+	c.format = c.Config.Get("format", FormatArray).(string)
+	if c.format != FormatArray && c.format != FormatLines {
+		return toolkit.Errorf("unknown format %s", c.format)
+	}
+	c.compression = c.Config.Get("compression", "").(string)
+	if c.compression != "" && filecodec.ByName(c.compression) == nil {
+		return toolkit.Errorf("unknown compression %s", c.compression)
+	}
 	return nil
 }
 
@@ -91,6 +103,11 @@ func (c *Connection) ObjectNames(dbflex.ObjTypeEnum) []string {
 	names := []string{}
 	for _, fi := range files {
 		name := strings.ToLower(fi.Name())
+		if filemeta.IsMetaFile(name) {
+			continue
+		}
+		// compressed table is named with codec extension after the table extension
+		name, _ = filecodec.Split(name)
 		if len(c.extension) == 0 {
 			names = append(names, name)
 		} else {
@@ -107,8 +124,48 @@ func (c *Connection) ValidateTable(interface{}, bool) error {
 	return nil
 }
 
+// tablePath return path of the table file, it could be a compressed file
+func (c *Connection) tablePath(name string) (string, error) {
+	return filecodec.TablePath(c.dirPath, name, c.extension, c.compression)
+}
+
 // DropTable remove the file of given table name
 func (c *Connection) DropTable(name string) error {
 	filepath := filepath.Join(c.dirPath, name)
-	return os.Remove(filepath)
+	if err := os.Remove(filepath); err != nil {
+		return err
+	}
+	if err := buildIndex(c.dirPath, name, "", nil); err != nil {
+		return err
+	}
+	if err := os.Remove(tombstonePath(c.dirPath, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return filemeta.DeleteTable(c.dirPath, name)
+}
+
+// EnsureTable record keys and unique indexes of the table into directory metadata, so they are enforced on every write.
+// If keys is empty, fields of obj that has key tag will be used. Fields of obj that has unique tag will be added as unique index,
+// and fields that has index tag (hash or sorted) will be added as secondary index
+func (c *Connection) EnsureTable(name string, keys []string, obj interface{}) error {
+	t := filemeta.NewTable(keys, obj, c.FieldNameTag(), c.KeyNameTag())
+	err := filemeta.Update(c.dirPath, func(m *filemeta.Meta) error {
+		if existing, ok := m.Tables[strings.ToLower(name)]; ok {
+			for _, idx := range t.Indexes {
+				existing.SetIndex(idx)
+			}
+			// keys that are not declared by obj keep the registered keys of the table
+			if len(t.Keys) > 0 {
+				existing.Keys = t.Keys
+			}
+			return nil
+		}
+		m.Tables[strings.ToLower(name)] = t
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.rebuildIndex(name)
 }
@@ -1,13 +1,15 @@
 package json
 
 import (
-	"encoding/json"
+	"io"
 	"os"
+	"path/filepath"
 	"reflect"
 
 	"github.com/eaciit/toolkit"
 
 	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filecodec"
 )
 
 // Cursor responsible for converting the text data to given buffer
@@ -15,10 +17,12 @@ import (
 type Cursor struct {
 	dbflex.CursorBase
 
-	f        *os.File
-	filePath string
-	filter   *dbflex.Filter
-	extra    dbflex.QueryItems
+	f         *os.File
+	filePath  string
+	tableName string
+	filter    *dbflex.Filter
+	extra     dbflex.QueryItems
+	fetched   int
 }
 
 var _ dbflex.ICursor = &Cursor{}
@@ -30,7 +34,7 @@ func (c *Cursor) Fetch(out interface{}) dbflex.ICursor {
 	ptr.Elem().Set(reflect.MakeSlice(sliceOfT, 0, 0))
 	buffer := ptr.Interface()
 
-	err := c.Fetchs(buffer, 1)
+	err := c.Fetchs(buffer, 1).Error()
 	if err != nil {
 		return c
 	}
@@ -65,24 +69,33 @@ func (c *Cursor) Fetchs(result interface{}, n int) dbflex.ICursor {
 		skip = items.Value.(int)
 	}
 
+	v := reflect.TypeOf(result).Elem().Elem()
+	// Create empty slice of buffer element type
+	ivs := reflect.MakeSlice(reflect.SliceOf(v), 0, 0)
+
 	if items, ok := c.extra[dbflex.QueryTake]; ok {
-		take = items.Value.(int)
+		// Take is shared by all fetchs of the cursor
+		limit := items.Value.(int) - c.fetched
+		if limit <= 0 {
+			reflect.Indirect(reflect.ValueOf(result)).Set(ivs)
+			return c
+		}
+		if take <= 0 || take > limit {
+			take = limit
+		}
 	}
 
 	read := 0
 	shouldFetched := 0
 
+	// File is read from the beginning on every fetchs, so records of the previous fetchs are skipped too
 	if !hasSort && !hasAggr && !hasGroup {
-		read = -skip
+		read = -skip - c.fetched
 		shouldFetched = take
 	}
 
-	v := reflect.TypeOf(result).Elem().Elem()
-	// Create empty slice of buffer element type
-	ivs := reflect.MakeSlice(reflect.SliceOf(v), 0, 0)
-
-	// Open file
-	file, err := os.Open(c.filePath)
+	// Open file, compressed file is decompressed while it is read
+	file, err := c.openFile()
 	if err != nil {
 		c.SetError(err)
 		return c
@@ -90,20 +103,31 @@ func (c *Cursor) Fetchs(result interface{}, n int) dbflex.ICursor {
 	// Don't forget to close ;)
 	defer file.Close()
 
-	// Initiate new decoder from stream
-	decoder := json.NewDecoder(file)
-	// Read open bracket
-	_, err = decoder.Token()
+	sortFields := []string{}
+	if hasSort && !hasAggr && !hasGroup {
+		sortFields = sortBy.Value.([]string)
+	}
+
+	next, sorted, err := c.records(file, sortFields)
 	if err != nil {
 		c.SetError(err)
 		return c
 	}
 
+	// If the records are already sorted by the index, skip and take can be applied while reading
+	if sorted {
+		hasSort = false
+		read = -skip - c.fetched
+		shouldFetched = take
+	}
+
 	// Check if there is more data
-	for decoder.More() {
-		data := toolkit.M{}
-		// Decode data one by one
-		err := decoder.Decode(&data)
+	for {
+		// Read data one by one
+		data, err := next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			c.SetError(err)
 			return c
@@ -144,13 +168,6 @@ func (c *Cursor) Fetchs(result interface{}, n int) dbflex.ICursor {
 		}
 	}
 
-	// Read closing bracket
-	_, err = decoder.Token()
-	if err != nil {
-		c.SetError(err)
-		return c
-	}
-
 	// Set the buffer with fetchedData
 	reflect.Indirect(reflect.ValueOf(result)).Set(ivs)
 
@@ -232,13 +249,17 @@ func (c *Cursor) Fetchs(result interface{}, n int) dbflex.ICursor {
 		}
 	}
 
-	if (hasSort || hasAggr || hasGroup) && (skip != 0 || take != 0) {
+	if (hasSort || hasAggr || hasGroup) && (skip != 0 || take != 0 || c.fetched != 0) {
 		rv := reflect.Indirect(reflect.ValueOf(result))
 
-		start := skip
-		end := take + skip
+		// Records of the previous fetchs are skipped
+		start := skip + c.fetched
+		end := take + start
 
-		if take+skip > rv.Len() {
+		if start > rv.Len() {
+			start = rv.Len()
+		}
+		if take <= 0 || end > rv.Len() {
 			end = rv.Len()
 		}
 
@@ -246,46 +267,96 @@ func (c *Cursor) Fetchs(result interface{}, n int) dbflex.ICursor {
 		rv.Set(ivs)
 	}
 
+	c.fetched += reflect.Indirect(reflect.ValueOf(result)).Len()
 	return c
 }
 
+// openFile open the data file to be read, compaction of JSON Lines file that was stopped is recovered before
+func (c *Cursor) openFile() (io.ReadCloser, error) {
+	if c.tableName != "" {
+		if err := recoverLines(filepath.Dir(c.filePath), c.tableName, c.filePath); err != nil {
+			return nil, err
+		}
+	}
+	return filecodec.Open(c.filePath)
+}
+
 // Count return count of data with give filter
 // BUG: Only filter that applied in this function, group by is not yet implemented
 func (c *Cursor) Count() int {
 	read := 0
 
 	// Open file
-	file, err := os.Open(c.filePath)
+	file, err := c.openFile()
 	if err != nil {
 		return 0
 	}
 	// Don't forget to close ;)
 	defer file.Close()
 
-	// Initiate new decoder from stream
-	decoder := json.NewDecoder(file)
-	// Read open bracket
-	_, err = decoder.Token()
+	next, _, err := c.records(file, nil)
 	if err != nil {
 		return 0
 	}
 
 	// Check if there is more data
-	for decoder.More() {
-		data := toolkit.M{}
+	for {
+		data, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0
+		}
 
-		decoder.Decode(&data)
 		ok, _ := isIncluded(data, c.filter)
 		if ok {
 			read++
 		}
 	}
 
-	// Read closing bracket
-	_, err = decoder.Token()
-	if err != nil {
-		return 0
+	return read
+}
+
+// records return function to read the records that may match the filter. Secondary indexes of the table are used if
+// they can be applied to the filter or the sort field, otherwise all records are scanned.
+// It also return true if the records are already sorted by given sort fields
+func (c *Cursor) records(file io.Reader, sortFields []string) (func() (toolkit.M, error), bool, error) {
+	var ix *indexFile
+	if conn, ok := c.Connection().(*Connection); ok && c.tableName != "" {
+		ix = loadIndex(conn.dirPath, c.tableName, c.filePath)
 	}
 
-	return read
+	// index can only be used to read plain file
+	if f, isFile := file.(*os.File); isFile && ix != nil {
+		rows, planned := ix.plan(c.filter)
+
+		sorted := false
+		if len(sortFields) == 1 {
+			if orderedRows, ok := ix.order(sortFields[0]); ok {
+				if planned {
+					// keep index order but only the rows that match the filter
+					candidates := map[int]bool{}
+					for _, r := range rows {
+						candidates[r] = true
+					}
+					filtered := []int{}
+					for _, r := range orderedRows {
+						if candidates[r] {
+							filtered = append(filtered, r)
+						}
+					}
+					orderedRows = filtered
+				}
+				rows, planned, sorted = orderedRows, true, true
+			}
+		}
+
+		if planned {
+			return indexedRecords(f, ix, rows), sorted, nil
+		}
+	}
+
+	next, err := scanRecords(file, tombstonePath(filepath.Dir(c.filePath), c.tableName))
+	return next, false, err
 }
@@ -11,9 +11,121 @@ import (
 	"time"
 
 	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filemeta"
 	"github.com/eaciit/toolkit"
 )
 
+// checkUnique return dbflex.ErrDuplicateKey error if records violate key or unique indexes of the table
+func checkUnique(tableName string, meta *filemeta.Table, records ...interface{}) error {
+	checker := filemeta.NewUniqueChecker(tableName, meta)
+	if checker == nil {
+		return nil
+	}
+
+	for _, record := range records {
+		m, err := recordToM(record)
+		if err != nil {
+			return err
+		}
+
+		err = checker.Check(func(field string) (string, bool) {
+			v, ok := filemeta.Lookup(m, field)
+			if !ok || v == nil {
+				return "", false
+			}
+			// compare the json form, so value has the same representation as the one stored in the file
+			return toolkit.JsonString(v), true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordToM return record as map, struct is serialized using json so the field names follow its json tag
+func recordToM(record interface{}) (map[string]interface{}, error) {
+	switch m := record.(type) {
+	case map[string]interface{}:
+		return m, nil
+	case toolkit.M:
+		return m, nil
+	}
+
+	m := toolkit.M{}
+	if err := toolkit.Serde(record, &m, ""); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// insertedIDs return key of the inserted records, _id is used if table has no declared keys
+func insertedIDs(meta *filemeta.Table, records []interface{}) []interface{} {
+	ids := make([]interface{}, len(records))
+	for i, record := range records {
+		if m, err := recordToM(record); err == nil {
+			ids[i] = meta.KeyValue(m, "_id")
+		}
+	}
+	return ids
+}
+
+// applyUpdate set fields of the existing record with the updated values, field that is not exist in the record is not added.
+// It return true if any value is changed
+func applyUpdate(ed, m toolkit.M) bool {
+	changed := false
+	for _, h := range ed.Keys() {
+		for k, v := range m {
+			// Check old data header with updated fields name
+			if strings.ToLower(h) == strings.ToLower(k) {
+				// compare the json form, since value of the file is decoded as string, float64, etc
+				if toolkit.JsonString(ed[h]) != toolkit.JsonString(v) {
+					changed = true
+				}
+				ed[h] = v
+				break
+			}
+		}
+	}
+	return changed
+}
+
+// updater return function that apply data of Update or Save command to a record and return true if the record is changed.
+// Data can be dbflex.UpdateDoc, or record whose fields are set to the matching fields of the record. If fields are given, only they are set
+func updater(data interface{}, fields []string) (func(toolkit.M) (bool, error), error) {
+	if doc, ok := dbflex.ToUpdateDoc(data); ok {
+		return doc.Apply, nil
+	}
+
+	m, err := objToM(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) > 0 {
+		picked := toolkit.M{}
+		for k, v := range m {
+			for _, f := range fields {
+				if strings.EqualFold(k, f) {
+					picked[k] = v
+					break
+				}
+			}
+		}
+		m = picked
+	}
+	return func(ed toolkit.M) (bool, error) {
+		return applyUpdate(ed, m), nil
+	}, nil
+}
+
+// saveRecord return record of Save command data, it is the inserted record of update document if data is dbflex.UpdateDoc
+func saveRecord(data interface{}) (interface{}, error) {
+	if doc, ok := dbflex.ToUpdateDoc(data); ok {
+		return doc.Record()
+	}
+	return data, nil
+}
+
 func writeToJSONFile(data interface{}, file *os.File) error {
 	// Truncate file
 	file.Truncate(0)
@@ -58,6 +170,11 @@ func objToM(data interface{}, parents ...string) (toolkit.M, error) {
 			// Check of there is a json tag for this field
 			tag, ok := f.Tag.Lookup("json")
 
+			// Unexported field and field that is ignored by json are not part of the data
+			if f.PkgPath != "" || tag == "-" {
+				continue
+			}
+
 			// If the type is struct but not time.Time or is a map
 			if (f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Time{})) || f.Type.Kind() == reflect.Map {
 				// Then we need to call this function again to fetch the sub value
@@ -99,6 +216,12 @@ func objToM(data interface{}, parents ...string) (toolkit.M, error) {
 		// If the data element is kind of map
 		// Iterate through all avilable keys
 		for _, key := range rv.MapKeys() {
+			// Nil value is put in the result directly
+			if !rv.MapIndex(key).Elem().IsValid() {
+				res[prefix+key.String()] = nil
+				continue
+			}
+
 			// Get the map value type of the specified key
 			t := rv.MapIndex(key).Elem().Type()
 			// If the type is struct but not time.Time or is a map
@@ -183,7 +306,8 @@ func mapToObject(data map[string]interface{}, result interface{}, parents ...str
 					f := rv.Type().Field(i)
 					tag, ok := f.Tag.Lookup("json")
 
-					if ok && tag == k {
+					// tag could have options, e.g. omitempty
+					if ok && strings.Split(tag, ",")[0] == k {
 						sv = rv.Field(i)
 						break
 					}
@@ -198,6 +322,18 @@ func mapToObject(data map[string]interface{}, result interface{}, parents ...str
 					continue
 				}
 
+				if kind == reflect.Ptr {
+					// null value keep the pointer nil
+					if v == nil {
+						sv.Set(reflect.Zero(sv.Type()))
+						continue
+					}
+					ptr := reflect.New(sv.Type().Elem())
+					ptr.Elem().Set(reflect.ValueOf(textToInterface(fmt.Sprint(v), sv.Type().Elem())))
+					sv.Set(ptr)
+					continue
+				}
+
 				cv := textToInterface(fmt.Sprint(v), sv.Type())
 				sv.Set(reflect.ValueOf(cv))
 			}
@@ -236,9 +372,14 @@ func isIncluded(data toolkit.M, f *dbflex.Filter) (bool, error) {
 		}
 	}
 
+	// Missing field is null, e.g. field that is omitted when it is empty or added after the record is written
+	if len(keys) != len(subNames) && f.Value == nil && (f.Op == dbflex.OpEq || f.Op == dbflex.OpNe) {
+		return f.Op == dbflex.OpEq, nil
+	}
+
 	// If the field is not found and filter operatrion is not AND, OR, RANGE return error
 	if len(keys) != len(subNames) && f.Op != dbflex.OpAnd && f.Op != dbflex.OpOr && f.Op != dbflex.OpRange && f.Op != dbflex.OpNot {
-		return false, toolkit.Errorf("Field with name %s is not exist in the table", f.Field)
+		return false, dbflex.NewError(dbflex.ErrUnknownField, toolkit.Sprintf("Field with name %s is not exist in the table", f.Field), nil).WithField(f.Field)
 	}
 
 	// Get the data value if field name is found
@@ -257,7 +398,7 @@ func isIncluded(data toolkit.M, f *dbflex.Filter) (bool, error) {
 			return false, nil
 		}
 	} else if f.Op == dbflex.OpContains {
-		keywords := f.Value.([]string)
+		keywords := filterValues(f.Value)
 		match := false
 		for _, keyword := range keywords {
 			if strings.Contains(strings.ToLower(dataValue), strings.ToLower(keyword)) {
@@ -272,7 +413,7 @@ func isIncluded(data toolkit.M, f *dbflex.Filter) (bool, error) {
 	} else if f.Op == dbflex.OpEndWith {
 		return strings.HasSuffix(dataValue, fmt.Sprint(f.Value)), nil
 	} else if f.Op == dbflex.OpIn {
-		keywords := f.Value.([]string)
+		keywords := filterValues(f.Value)
 		match := false
 		for _, keyword := range keywords {
 			if strings.ToLower(dataValue) == strings.ToLower(keyword) {
@@ -283,7 +424,7 @@ func isIncluded(data toolkit.M, f *dbflex.Filter) (bool, error) {
 
 		return match, nil
 	} else if f.Op == dbflex.OpNin {
-		keywords := f.Value.([]string)
+		keywords := filterValues(f.Value)
 		match := true
 		for _, keyword := range keywords {
 			if strings.ToLower(dataValue) == strings.ToLower(keyword) {
@@ -424,6 +565,21 @@ func isIncluded(data toolkit.M, f *dbflex.Filter) (bool, error) {
 	return true, nil
 }
 
+// filterValues return values of $contains, $in and $nin filter as text, value can be slice of string or slice of interface
+func filterValues(value interface{}) []string {
+	switch vs := value.(type) {
+	case []string:
+		return vs
+	case []interface{}:
+		res := make([]string, len(vs))
+		for i, v := range vs {
+			res[i] = fmt.Sprint(v)
+		}
+		return res
+	}
+	return []string{fmt.Sprint(value)}
+}
+
 // AggregatorHelper
 func aggregate(data interface{}, aggrItems []*dbflex.AggrItem, groups ...string) ([]interface{}, error) {
 	rv := reflect.ValueOf(reflect.ValueOf(data).Elem().Interface())
@@ -0,0 +1,488 @@
+package json
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filecodec"
+	"git.kanosolution.net/kano/dbflex/drivers/filemeta"
+	"github.com/eaciit/toolkit"
+	flock "github.com/theckman/go-flock"
+)
+
+const (
+	indexKindNumber = "number"
+	indexKindText   = "text"
+)
+
+// indexFile is persisted secondary indexes of a table. It is rebuilt on every write and only used when
+// size and modification time of the data file and size of the tombstone are still the same with when it is built
+type indexFile struct {
+	Size     int64                 `json:"size"`
+	ModTime  int64                 `json:"modtime"`
+	TombSize int64                 `json:"tombsize"`
+	Starts   []int64               `json:"starts"`
+	Ends     []int64               `json:"ends"`
+	Indexes  map[string]*indexData `json:"indexes"`
+}
+
+// indexData hold row numbers of a field. Hash is used for equality filter, Sorted is used for range filter and sorting
+type indexData struct {
+	Field    string             `json:"field"`
+	Type     filemeta.IndexType `json:"type"`
+	Hash     map[string][]int   `json:"hash"`
+	Sorted   []sortedEntry      `json:"sorted,omitempty"`
+	Kind     string             `json:"kind,omitempty"`
+	Complete bool               `json:"complete"`
+}
+
+type sortedEntry struct {
+	Num  float64 `json:"n,omitempty"`
+	Text string  `json:"t,omitempty"`
+	Row  int     `json:"r"`
+}
+
+func indexPath(dirPath, tableName string) string {
+	return filepath.Join(dirPath, filemeta.Prefix+"."+strings.ToLower(tableName)+".idx")
+}
+
+// buildIndex read all records of the data file and write the secondary indexes of the table.
+// Index file is removed if the table has no secondary index. Compressed table is not indexed since its records can't be read by offset
+func buildIndex(dirPath, tableName, dataPath string, defs []*filemeta.Index) error {
+	idxPath := indexPath(dirPath, tableName)
+	if len(defs) == 0 || filecodec.ByFileName(dataPath) != nil {
+		if err := os.Remove(idxPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	file, err := os.Open(dataPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	ix := new(indexFile)
+	ix.Indexes = map[string]*indexData{}
+	for _, def := range defs {
+		ix.Indexes[strings.ToLower(def.Fields[0])] = &indexData{Field: def.Fields[0], Type: def.Type, Hash: map[string][]int{}, Complete: true}
+	}
+
+	tombPath := tombstonePath(dirPath, tableName)
+	next, err := openRecords(file, tombPath)
+	if err != nil {
+		return toolkit.Errorf("unable to build index of %s. %w", tableName, err)
+	}
+
+	hasNum := map[string]bool{}
+	hasText := map[string]bool{}
+	hasOther := map[string]bool{}
+	for row := 0; ; row++ {
+		r, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return toolkit.Errorf("unable to build index of %s. %w", tableName, err)
+		}
+		data := r.data
+		ix.Starts = append(ix.Starts, r.start)
+		ix.Ends = append(ix.Ends, r.end)
+
+		for name, idx := range ix.Indexes {
+			v, ok := filemeta.Lookup(data, idx.Field)
+			if !ok || v == nil {
+				idx.Complete = false
+				continue
+			}
+
+			key := fmt.Sprint(v)
+			idx.Hash[key] = append(idx.Hash[key], row)
+			if idx.Type != filemeta.IndexSorted {
+				continue
+			}
+
+			switch tv := v.(type) {
+			case float64:
+				hasNum[name] = true
+				idx.Sorted = append(idx.Sorted, sortedEntry{Num: tv, Row: row})
+			case string:
+				hasText[name] = true
+				idx.Sorted = append(idx.Sorted, sortedEntry{Text: tv, Row: row})
+			default:
+				hasOther[name] = true
+			}
+		}
+	}
+
+	for name, idx := range ix.Indexes {
+		if idx.Type != filemeta.IndexSorted {
+			continue
+		}
+
+		// Sorted index is only usable if all values have the same kind
+		switch {
+		case hasNum[name] && !hasText[name] && !hasOther[name]:
+			idx.Kind = indexKindNumber
+			sort.SliceStable(idx.Sorted, func(i, j int) bool { return idx.Sorted[i].Num < idx.Sorted[j].Num })
+		case hasText[name] && !hasNum[name] && !hasOther[name]:
+			idx.Kind = indexKindText
+			sort.SliceStable(idx.Sorted, func(i, j int) bool { return idx.Sorted[i].Text < idx.Sorted[j].Text })
+		default:
+			idx.Sorted = nil
+		}
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	ix.Size = fi.Size()
+	ix.ModTime = fi.ModTime().UnixNano()
+	ix.TombSize = fileSize(tombPath)
+
+	bs, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+
+	// write to temp file then rename it, so cursor never read partially written index
+	tmpPath := idxPath + "_temp_" + toolkit.RandomString(16)
+	if err = ioutil.WriteFile(tmpPath, bs, 0644); err != nil {
+		return toolkit.Errorf("unable to write index of %s. %w", tableName, err)
+	}
+	return os.Rename(tmpPath, idxPath)
+}
+
+// loadIndex return secondary indexes of the table, it return nil if table has no index or the index is outdated
+func loadIndex(dirPath, tableName, dataPath string) *indexFile {
+	bs, err := ioutil.ReadFile(indexPath(dirPath, tableName))
+	if err != nil {
+		return nil
+	}
+
+	ix := new(indexFile)
+	if err = json.Unmarshal(bs, ix); err != nil {
+		return nil
+	}
+
+	fi, err := os.Stat(dataPath)
+	if err != nil || fi.Size() != ix.Size || fi.ModTime().UnixNano() != ix.ModTime || fileSize(tombstonePath(dirPath, tableName)) != ix.TombSize {
+		return nil
+	}
+	return ix
+}
+
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// plan return rows that may match the filter, it return false if the filter can't use the index.
+// Returned rows are candidates only, each of them still need to be checked using isIncluded
+func (ix *indexFile) plan(f *dbflex.Filter) ([]int, bool) {
+	if f == nil {
+		return nil, false
+	}
+
+	switch f.Op {
+	case dbflex.OpAnd:
+		var res []int
+		planned := false
+		for _, item := range f.Items {
+			rows, ok := ix.plan(item)
+			if !ok {
+				continue
+			}
+			if !planned {
+				res = rows
+				planned = true
+			} else {
+				res = intersectRows(res, rows)
+			}
+		}
+		return res, planned
+
+	case dbflex.OpOr:
+		res := []int{}
+		for _, item := range f.Items {
+			rows, ok := ix.plan(item)
+			if !ok {
+				return nil, false
+			}
+			res = unionRows(res, rows)
+		}
+		return res, true
+	}
+
+	idx, ok := ix.Indexes[strings.ToLower(f.Field)]
+	if !ok {
+		return nil, false
+	}
+
+	switch f.Op {
+	case dbflex.OpEq:
+		// nil and missing values are not indexed, so records that match nil can only be found by reading all of them
+		if f.Value == nil {
+			return nil, false
+		}
+		return idx.Hash[fmt.Sprint(f.Value)], true
+
+	case dbflex.OpIn:
+		// $in is case insensitive, so all keys need to be compared
+		res := []int{}
+		values := filterValues(f.Value)
+		for _, v := range values {
+			if v == fmt.Sprint(nil) {
+				return nil, false
+			}
+		}
+		for key, rows := range idx.Hash {
+			for _, v := range values {
+				if strings.EqualFold(key, v) {
+					res = unionRows(res, rows)
+					break
+				}
+			}
+		}
+		return res, true
+
+	case dbflex.OpGt, dbflex.OpGte, dbflex.OpLt, dbflex.OpLte:
+		if idx.Kind != indexKindNumber {
+			return nil, false
+		}
+		v, err := strconv.ParseFloat(fmt.Sprint(f.Value), 64)
+		if err != nil {
+			return nil, false
+		}
+
+		switch f.Op {
+		case dbflex.OpGt:
+			return idx.numberRange(v, false, 0, false, true, false), true
+		case dbflex.OpGte:
+			return idx.numberRange(v, true, 0, false, true, false), true
+		case dbflex.OpLt:
+			return idx.numberRange(0, false, v, false, false, true), true
+		default:
+			return idx.numberRange(0, false, v, true, false, true), true
+		}
+
+	case dbflex.OpRange:
+		values, isSlice := f.Value.([]interface{})
+		if idx.Kind != indexKindNumber || !isSlice || len(values) != 2 {
+			return nil, false
+		}
+		lo, err := strconv.ParseFloat(fmt.Sprint(values[0]), 64)
+		if err != nil {
+			return nil, false
+		}
+		hi, err := strconv.ParseFloat(fmt.Sprint(values[1]), 64)
+		if err != nil {
+			return nil, false
+		}
+		return idx.numberRange(lo, true, hi, true, true, true), true
+	}
+
+	return nil, false
+}
+
+// numberRange return rows within given range ordered by row number
+func (idx *indexData) numberRange(lo float64, loInclusive bool, hi float64, hiInclusive bool, hasLo, hasHi bool) []int {
+	start, end := 0, len(idx.Sorted)
+	if hasLo {
+		start = sort.Search(len(idx.Sorted), func(i int) bool {
+			if loInclusive {
+				return idx.Sorted[i].Num >= lo
+			}
+			return idx.Sorted[i].Num > lo
+		})
+	}
+	if hasHi {
+		end = sort.Search(len(idx.Sorted), func(i int) bool {
+			if hiInclusive {
+				return idx.Sorted[i].Num > hi
+			}
+			return idx.Sorted[i].Num >= hi
+		})
+	}
+
+	res := []int{}
+	for i := start; i < end; i++ {
+		res = append(res, idx.Sorted[i].Row)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// order return all rows sorted by given field, prefix the field with - for descending order.
+// It return false if the field has no sorted index or some records don't have the field
+func (ix *indexFile) order(field string) ([]int, bool) {
+	desc := strings.HasPrefix(field, "-")
+	idx, ok := ix.Indexes[strings.ToLower(strings.TrimPrefix(field, "-"))]
+	if !ok || idx.Kind == "" || !idx.Complete {
+		return nil, false
+	}
+
+	res := make([]int, len(idx.Sorted))
+	for i, e := range idx.Sorted {
+		if desc {
+			res[len(res)-1-i] = e.Row
+		} else {
+			res[i] = e.Row
+		}
+	}
+	return res, true
+}
+
+// readRow read single record of the data file using the index offsets
+func (ix *indexFile) readRow(file *os.File, row int) (toolkit.M, error) {
+	bs := make([]byte, ix.Ends[row]-ix.Starts[row])
+	if _, err := file.ReadAt(bs, ix.Starts[row]); err != nil {
+		return nil, err
+	}
+
+	data := toolkit.M{}
+	// record of JSON array is preceded by separator of the previous record
+	if err := json.Unmarshal(bytes.TrimLeft(bs, " \t\r\n,"), &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// EnsureIndex create or replace secondary index of a table field and build it
+func (c *Connection) EnsureIndex(tableName, name string, indexType filemeta.IndexType, field string) error {
+	if indexType != filemeta.IndexHash && indexType != filemeta.IndexSorted {
+		return toolkit.Errorf("invalid index type %s", indexType)
+	}
+
+	err := filemeta.Update(c.dirPath, func(m *filemeta.Meta) error {
+		t, ok := m.Tables[strings.ToLower(tableName)]
+		if !ok {
+			t = new(filemeta.Table)
+			m.Tables[strings.ToLower(tableName)] = t
+		}
+		t.SetIndex(&filemeta.Index{Name: name, Fields: []string{field}, Type: indexType})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.rebuildIndex(tableName)
+}
+
+// DropIndex remove index of a table
+func (c *Connection) DropIndex(tableName, name string) error {
+	err := filemeta.Update(c.dirPath, func(m *filemeta.Meta) error {
+		if t, ok := m.Tables[strings.ToLower(tableName)]; ok {
+			t.DeleteIndex(name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.rebuildIndex(tableName)
+}
+
+// rebuildIndex lock the data file of the table and rebuild its secondary indexes
+func (c *Connection) rebuildIndex(tableName string) error {
+	tableMeta, err := filemeta.LoadTable(c.dirPath, tableName)
+	if err != nil {
+		return err
+	}
+
+	dataPath, err := c.tablePath(tableName)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(dataPath); err != nil {
+		// index will be built on first write
+		return buildIndex(c.dirPath, tableName, dataPath, nil)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	lockCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	lockPath := filemeta.LockPath(c.dirPath, tableName)
+	fileLock := flock.NewFlock(lockPath)
+	if _, err = fileLock.TryLockContext(lockCtx, 10*time.Millisecond); err != nil {
+		return dbflex.NewError(dbflex.ErrLockTimeout, toolkit.Sprintf("unable to lock file %s", lockPath), err).WithTable(tableName)
+	}
+	defer fileLock.Unlock()
+
+	return buildIndex(c.dirPath, tableName, dataPath, tableMeta.SecondaryIndexes())
+}
+
+// indexedRecords return function that read given rows of the data file, it return io.EOF after the last row
+func indexedRecords(file *os.File, ix *indexFile, rows []int) func() (toolkit.M, error) {
+	i := 0
+	return func() (toolkit.M, error) {
+		if i >= len(rows) {
+			return nil, io.EOF
+		}
+		data, err := ix.readRow(file, rows[i])
+		i++
+		return data, err
+	}
+}
+
+func intersectRows(a, b []int) []int {
+	res := []int{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			res = append(res, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return res
+}
+
+func unionRows(a, b []int) []int {
+	res := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j >= len(b) || (i < len(a) && a[i] < b[j]):
+			res = append(res, a[i])
+			i++
+		case i >= len(a) || b[j] < a[i]:
+			res = append(res, b[j])
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
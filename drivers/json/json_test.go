@@ -1,12 +1,18 @@
 package json
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filemeta"
 	"git.kanosolution.net/kano/dbflex/testbase"
 	"github.com/eaciit/toolkit"
 	. "github.com/smartystreets/goconvey/convey"
@@ -213,8 +219,769 @@ func TestSaveCommand(t *testing.T) {
 		err = conn.Cursor(cmd, nil).Fetchs(&buffer, 0).Error()
 		So(err, ShouldBeNil)
 		So(buffer[0]["Value"], ShouldEqual, "Believe Us")
+
+		Convey("Save using given keys", func() {
+			keyTable := "employees-save-keys"
+			_, err := conn.Execute(dbflex.From(keyTable).Delete(), nil)
+			So(err, ShouldBeNil)
+
+			saveCmd := dbflex.From(keyTable).Save("Code")
+			_, err = conn.Execute(saveCmd, toolkit.M{}.Set("data", toolkit.M{}.Set("Code", "E1").Set("Name", "Bagus")))
+			So(err, ShouldBeNil)
+			out, err := conn.Execute(saveCmd, toolkit.M{}.Set("data", toolkit.M{}.Set("Code", "E1").Set("Name", "Cahyono")))
+			So(err, ShouldBeNil)
+			res, _ := dbflex.ToExecResult(out)
+			So(res.RowsMatched, ShouldEqual, 1)
+
+			buffer := []toolkit.M{}
+			err = conn.Cursor(dbflex.From(keyTable).Where(dbflex.Eq("Code", "E1")), nil).Fetchs(&buffer, 0).Error()
+			So(err, ShouldBeNil)
+			So(len(buffer), ShouldEqual, 1)
+			So(buffer[0]["Name"], ShouldEqual, "Cahyono")
+		})
+	})
+}
+
+func TestInterceptor(t *testing.T) {
+	Convey("Interceptor", t, func() {
+		tableName := "employees-intercept"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json", workpath), toolkit.M{})
+		So(err, ShouldBeNil)
+
+		err = conn.Connect()
+		So(err, ShouldBeNil)
+
+		actions := []dbflex.InterceptAction{}
+		conn.AddInterceptor(func(info *dbflex.InterceptInfo, next dbflex.InterceptHandler) error {
+			actions = append(actions, info.Action)
+			if info.Action == dbflex.InterceptExecute && info.Parm.Has("reject") {
+				return errors.New("rejected")
+			}
+			return next(info)
+		})
+
+		_, err = conn.Execute(dbflex.From(tableName).Delete(), nil)
+		So(err, ShouldBeNil)
+		So(actions, ShouldResemble, []dbflex.InterceptAction{dbflex.InterceptPrepare, dbflex.InterceptExecute})
+
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "A")).Set("reject", true))
+		So(err, ShouldNotBeNil)
+
+		buffer := []toolkit.M{}
+		err = conn.Cursor(dbflex.From(tableName).Select(), nil).Fetchs(&buffer, 0).Error()
+		So(err, ShouldBeNil)
+		So(len(buffer), ShouldEqual, 0)
+		So(actions[len(actions)-1], ShouldEqual, dbflex.InterceptCursor)
+	})
+}
+
+func TestQueryLog(t *testing.T) {
+	Convey("Query log", t, func() {
+		type FakeUser struct {
+			ID       string `json:"_id"`
+			Password string `json:"password" sensitive:"1"`
+		}
+
+		tableName := "users-log"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json", workpath), toolkit.M{})
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+
+		events := []*dbflex.QueryLogEvent{}
+		dbflex.SetQueryLog(&dbflex.QueryLogOptions{Hook: func(ev *dbflex.QueryLogEvent) {
+			events = append(events, ev)
+		}})
+		defer dbflex.SetQueryLog(nil)
+
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", &FakeUser{"U1", "secret"}))
+		So(err, ShouldBeNil)
+
+		cursor := conn.Cursor(dbflex.From(tableName).Select(), nil)
+		buffer := []toolkit.M{}
+		So(cursor.Fetchs(&buffer, 0).Error(), ShouldBeNil)
+		cursor.Close()
+
+		So(len(events), ShouldEqual, 3)
+		So(events[1].Driver, ShouldEqual, "json")
+		So(events[1].Table, ShouldEqual, tableName)
+		So(events[1].CommandType, ShouldEqual, dbflex.QueryInsert)
+		So(events[1].Data.(toolkit.M)["password"], ShouldEqual, dbflex.RedactedValue)
+		So(events[1].Rows, ShouldEqual, 1)
+		So(events[2].Action, ShouldEqual, string(dbflex.InterceptCursor))
+		So(events[2].Rows, ShouldEqual, 1)
+
+		Convey("Cursor is logged after the first fetch and slow check exclude the time it is kept open", func() {
+			dbflex.SetQueryLog(&dbflex.QueryLogOptions{SlowThreshold: 100 * time.Millisecond, Hook: func(ev *dbflex.QueryLogEvent) {
+				events = append(events, ev)
+			}})
+			events = events[:0]
+
+			cursor := conn.Cursor(dbflex.From(tableName).Select(), nil)
+			user := toolkit.M{}
+			So(cursor.Fetch(&user).Error(), ShouldBeNil)
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Rows, ShouldEqual, 1)
+
+			time.Sleep(150 * time.Millisecond)
+			cursor.Close()
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Slow, ShouldBeFalse)
+		})
+	})
+}
+
+type fakeSpan struct {
+	name  string
+	attrs toolkit.M
+	ended bool
+}
+
+func (s *fakeSpan) SetAttribute(key string, value interface{}) { s.attrs.Set(key, value) }
+func (s *fakeSpan) RecordError(err error)                      { s.attrs.Set("error", err.Error()) }
+func (s *fakeSpan) End()                                       { s.ended = true }
+
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string) (context.Context, dbflex.ISpan) {
+	span := &fakeSpan{name: name, attrs: toolkit.M{}}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestTracer(t *testing.T) {
+	Convey("Tracer", t, func() {
+		tableName := "employees-trace"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json", workpath), toolkit.M{})
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+
+		tracer := new(fakeTracer)
+		dbflex.SetTracer(tracer)
+		defer dbflex.SetTracer(nil)
+
+		ctx := context.Background()
+		conn.Execute(dbflex.WithContext(dbflex.From(tableName).Delete(), ctx), nil)
+		conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "A")))
+
+		cursor := conn.Cursor(dbflex.WithContext(dbflex.From(tableName).Select(), ctx), nil)
+		buffer := []toolkit.M{}
+		cursor.Fetchs(&buffer, 0)
+		cursor.Close()
+
+		names := []string{}
+		for _, span := range tracer.spans {
+			So(span.ended, ShouldBeTrue)
+			names = append(names, span.name)
+		}
+		So(names, ShouldResemble, []string{"dbflex.prepare", "dbflex.execute", "dbflex.prepare", "dbflex.execute",
+			"dbflex.prepare", "dbflex.cursor", "dbflex.fetchs"})
+
+		cursorSpan := tracer.spans[5]
+		So(cursorSpan.attrs.GetString(dbflex.TraceAttrTable), ShouldEqual, tableName)
+		So(cursorSpan.attrs.GetString(dbflex.TraceAttrCommandType), ShouldEqual, dbflex.QuerySelect)
+		So(cursorSpan.attrs.GetInt(dbflex.TraceAttrRows), ShouldEqual, 1)
+
+		Convey("Span of cursor that is not closed is ended after the first fetch", func() {
+			tracer.spans = nil
+			cursor := conn.Cursor(dbflex.From(tableName).Select(), nil)
+			So(cursor.Fetch(&toolkit.M{}).Error(), ShouldBeNil)
+			So(len(tracer.spans), ShouldEqual, 3)
+			So(tracer.spans[1].name, ShouldEqual, "dbflex.cursor")
+			So(tracer.spans[1].ended, ShouldBeTrue)
+			So(tracer.spans[1].attrs.GetInt(dbflex.TraceAttrRows), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestCacheConnection(t *testing.T) {
+	Convey("Cache connection", t, func() {
+		tableName := "employees-cache"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json", workpath), toolkit.M{})
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+
+		cacheConn := dbflex.NewCacheConnection(conn, nil)
+		cacheConn.Execute(dbflex.From(tableName).Delete(), nil)
+		cacheConn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "A").Set("Grade", 1)))
+
+		cmd := func() dbflex.ICommand {
+			return dbflex.From(tableName).Select().Where(dbflex.Gte("Grade", 1))
+		}
+		buffer := []toolkit.M{}
+		So(cacheConn.Cursor(cmd(), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+		So(len(buffer), ShouldEqual, 1)
+
+		// write directly to the connection, cached result should be returned
+		conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "B").Set("Grade", 2)))
+		So(cacheConn.Cursor(cmd(), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+		So(len(buffer), ShouldEqual, 1)
+
+		// write through the cache connection invalidate the table
+		cacheConn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "C").Set("Grade", 3)))
+		So(cacheConn.Cursor(cmd(), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+		So(len(buffer), ShouldEqual, 3)
+
+		one := toolkit.M{}
+		cursor := cacheConn.Cursor(cmd(), nil)
+		So(cursor.Fetch(&one).Error(), ShouldBeNil)
+		So(one.GetString("_id"), ShouldEqual, "A")
+		So(cursor.Fetch(&one).Error(), ShouldBeNil)
+		So(one.GetString("_id"), ShouldEqual, "B")
+
+		Convey("Result read before the table is invalidated is not cached", func() {
+			conn.AddInterceptor(func(info *dbflex.InterceptInfo, next dbflex.InterceptHandler) error {
+				err := next(info)
+				// simulate write of other goroutine while the cursor is read
+				if info.Action == dbflex.InterceptCursor {
+					cacheConn.Invalidate(tableName)
+				}
+				return err
+			})
+			staleCmd := dbflex.From(tableName).Select().Where(dbflex.Gte("Grade", 2))
+			So(cacheConn.Cursor(staleCmd, nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(len(buffer), ShouldEqual, 2)
+
+			conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "D").Set("Grade", 4)))
+			So(cacheConn.Cursor(staleCmd, nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(len(buffer), ShouldEqual, 3)
+		})
+
+		Convey("Write using prepared query, SQL or Command invalidate the cache", func() {
+			q, err := cacheConn.Prepare(dbflex.From(tableName).Insert())
+			So(err, ShouldBeNil)
+			_, err = q.Execute(toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "D").Set("Grade", 4)))
+			So(err, ShouldBeNil)
+			So(cacheConn.Cursor(cmd(), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(len(buffer), ShouldEqual, 4)
+
+			conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "E").Set("Grade", 5)))
+			cacheConn.Execute(dbflex.From(tableName).SQL("delete from other"), nil)
+			So(cacheConn.Cursor(cmd(), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(len(buffer), ShouldEqual, 5)
+		})
+
+		Convey("Result that has more rows than MaxRows is not cached", func() {
+			limitConn := dbflex.NewCacheConnection(conn, &dbflex.CacheOptions{MaxRows: 2})
+			So(limitConn.Cursor(cmd(), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(len(buffer), ShouldEqual, 3)
+
+			conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "D").Set("Grade", 4)))
+			So(limitConn.Cursor(cmd(), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(len(buffer), ShouldEqual, 4)
+
+			small := dbflex.From(tableName).Select().Where(dbflex.Eq("Grade", 1))
+			So(limitConn.Cursor(small, nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(len(buffer), ShouldEqual, 1)
+			conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "E").Set("Grade", 1)))
+			So(limitConn.Cursor(small, nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(len(buffer), ShouldEqual, 1)
+		})
+
+		Convey("Evicted key is reported", func() {
+			cache := dbflex.NewLRUCache(1)
+			evicted := []string{}
+			cache.OnEvict(func(key string) {
+				evicted = append(evicted, key)
+			})
+			cache.Set("a", []toolkit.M{}, time.Minute)
+			cache.Set("b", []toolkit.M{}, time.Minute)
+			cache.Set("c", []toolkit.M{}, -time.Second)
+			_, ok := cache.Get("c")
+			So(ok, ShouldBeFalse)
+			So(evicted, ShouldResemble, []string{"a", "b", "c"})
+		})
+	})
+}
+
+type uniqueEmployee struct {
+	EmployeeID string `json:"EmployeeID" key:"1"`
+	Email      string `json:"Email" unique:"1"`
+	Grade      int    `json:"Grade"`
+}
+
+func TestUniqueKey(t *testing.T) {
+	Convey("Unique key", t, func() {
+		tableName := "employees-unique"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json", workpath), toolkit.M{})
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+		defer conn.Close()
+
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		So(conn.(*Connection).EnsureTable(tableName, nil, uniqueEmployee{}), ShouldBeNil)
+		So(conn.ObjectNames(dbflex.ObjTypeTable), ShouldNotContain, "_dbflex")
+
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", uniqueEmployee{"E1", "e1@mail.com", 1}))
+		So(err, ShouldBeNil)
+
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", uniqueEmployee{"E1", "e2@mail.com", 1}))
+		So(errors.Is(err, dbflex.ErrDuplicateKey), ShouldBeTrue)
+
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", uniqueEmployee{"E2", "e1@mail.com", 1}))
+		So(errors.Is(err, dbflex.ErrDuplicateKey), ShouldBeTrue)
+
+		_, err = conn.Execute(dbflex.From("missing-folder/"+tableName).Insert(), toolkit.M{}.Set("data", uniqueEmployee{"E1", "e1@mail.com", 1}))
+		So(errors.Is(err, dbflex.ErrStorage), ShouldBeTrue)
+
+		Convey("Save use declared key", func() {
+			_, err = conn.Execute(dbflex.From(tableName).Save(), toolkit.M{}.Set("data", uniqueEmployee{"E1", "e1@mail.com", 5}))
+			So(err, ShouldBeNil)
+			_, err = conn.Execute(dbflex.From(tableName).Save(), toolkit.M{}.Set("data", uniqueEmployee{"E2", "e2@mail.com", 2}))
+			So(err, ShouldBeNil)
+
+			buffer := []uniqueEmployee{}
+			So(conn.Cursor(dbflex.From(tableName).Select(), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(len(buffer), ShouldEqual, 2)
+			So(buffer[0].Grade, ShouldEqual, 5)
+
+			_, err = conn.Execute(dbflex.From(tableName).Update().Where(dbflex.Eq("EmployeeID", "E2")), toolkit.M{}.Set("data", toolkit.M{}.Set("Email", "e1@mail.com")))
+			So(errors.Is(err, dbflex.ErrConstraint), ShouldBeTrue)
+		})
+
+		Convey("Ensure table without keys keep registered keys", func() {
+			So(conn.(*Connection).EnsureTable(tableName, nil, toolkit.M{}), ShouldBeNil)
+			table, err := filemeta.LoadTable(workpath, tableName)
+			So(err, ShouldBeNil)
+			So(table.Keys, ShouldResemble, []string{"EmployeeID"})
+		})
+	})
+}
+
+func TestSecondaryIndex(t *testing.T) {
+	Convey("Secondary index", t, func() {
+		tableName := "employees-index"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json", workpath), toolkit.M{})
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+		defer conn.Close()
+
+		jsonConn := conn.(*Connection)
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		So(jsonConn.EnsureIndex(tableName, "dept", filemeta.IndexHash, "Dept"), ShouldBeNil)
+		So(jsonConn.EnsureIndex(tableName, "grade", filemeta.IndexSorted, "Grade"), ShouldBeNil)
+
+		depts := []string{"HR", "IT", "Finance"}
+		for i := 0; i < 30; i++ {
+			_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data",
+				toolkit.M{}.Set("_id", toolkit.Sprintf("E%d", i)).Set("Dept", depts[i%3]).Set("Grade", (i*7)%30)))
+			So(err, ShouldBeNil)
+		}
+
+		ix := loadIndex(jsonConn.dirPath, tableName, filepath.Join(jsonConn.dirPath, tableName+".json"))
+		So(ix, ShouldNotBeNil)
+
+		fetch := func(cmd dbflex.ICommand) []toolkit.M {
+			buffer := []toolkit.M{}
+			So(conn.Cursor(cmd, nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			return buffer
+		}
+
+		Convey("Filter use the index", func() {
+			rows, ok := ix.plan(dbflex.And(dbflex.Eq("Dept", "IT"), dbflex.Gte("Grade", 10)))
+			So(ok, ShouldBeTrue)
+			So(len(rows), ShouldEqual, 7)
+			_, ok = ix.plan(dbflex.Contains("Dept", "I"))
+			So(ok, ShouldBeFalse)
+
+			So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.Eq("Dept", "IT")))), ShouldEqual, 10)
+			So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.In("Dept", "it", "hr")))), ShouldEqual, 20)
+			So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.Range("Grade", 5, 9)))), ShouldEqual, 5)
+			So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.Or(dbflex.Lt("Grade", 3), dbflex.Eq("Dept", "HR"))))), ShouldEqual, 12)
+			So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.Contains("Dept", "n")))), ShouldEqual, 10)
+		})
+
+		Convey("Filter on nil does not use the index", func() {
+			_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data",
+				toolkit.M{}.Set("_id", "E30").Set("Dept", nil).Set("Grade", 1)))
+			So(err, ShouldBeNil)
+
+			ix := loadIndex(jsonConn.dirPath, tableName, filepath.Join(jsonConn.dirPath, tableName+".json"))
+			So(ix, ShouldNotBeNil)
+			_, ok := ix.plan(dbflex.Eq("Dept", nil))
+			So(ok, ShouldBeFalse)
+			_, ok = ix.plan(dbflex.In("Dept", "IT", nil))
+			So(ok, ShouldBeFalse)
+
+			So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.Eq("Dept", nil)))), ShouldEqual, 1)
+			So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.And(dbflex.Eq("Dept", nil), dbflex.Eq("Grade", 1))))), ShouldEqual, 1)
+
+			// missing field is null
+			So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.Eq("Manager", nil)))), ShouldEqual, 31)
+			So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.Ne("Manager", nil)))), ShouldEqual, 0)
+		})
+
+		Convey("Sort use the index", func() {
+			res := fetch(dbflex.From(tableName).Select().Where(dbflex.Eq("Dept", "HR")).OrderBy("-Grade").Skip(1).Take(3))
+			So(len(res), ShouldEqual, 3)
+			So(res[0].GetInt("Grade"), ShouldEqual, 24)
+			So(res[2].GetInt("Grade"), ShouldEqual, 18)
+		})
+
+		Convey("Index is maintained on write", func() {
+			_, err = conn.Execute(dbflex.From(tableName).Update().Where(dbflex.Eq("_id", "E1")), toolkit.M{}.Set("data", toolkit.M{}.Set("Dept", "HR")))
+			So(err, ShouldBeNil)
+			So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.Eq("Dept", "HR")))), ShouldEqual, 11)
+
+			_, err = conn.Execute(dbflex.From(tableName).Delete().Where(dbflex.Eq("Dept", "HR")), nil)
+			So(err, ShouldBeNil)
+			So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.Eq("Dept", "HR")))), ShouldEqual, 0)
+			So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.Eq("Dept", "IT")))), ShouldEqual, 9)
+		})
+	})
+}
+
+func TestLinesFormat(t *testing.T) {
+	Convey("JSON Lines format", t, func() {
+		tableName := "employees-lines"
+		dirPath := filepath.Join(workpath, "dbflex-jsonl")
+		So(os.MkdirAll(dirPath, 0755), ShouldBeNil)
+
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json&format=jsonl", dirPath), toolkit.M{})
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+		defer conn.Close()
+
+		dataPath := filepath.Join(dirPath, tableName+".json")
+		tombPath := tombstonePath(dirPath, tableName)
+		fetch := func(cmd dbflex.ICommand) []toolkit.M {
+			buffer := []toolkit.M{}
+			So(conn.Cursor(cmd, nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			return buffer
+		}
+		lines := func() int {
+			bs, _ := ioutil.ReadFile(dataPath)
+			return len(strings.Split(strings.TrimSpace(string(bs)), "\n"))
+		}
+
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		So(conn.(*Connection).EnsureIndex(tableName, "grade", filemeta.IndexSorted, "Grade"), ShouldBeNil)
+		for i := 0; i < 3; i++ {
+			_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", toolkit.Sprintf("E%d", i)).Set("Grade", i)))
+			So(err, ShouldBeNil)
+		}
+		So(lines(), ShouldEqual, 3)
+
+		_, err = conn.Execute(dbflex.From(tableName).Update().Where(dbflex.Eq("_id", "E0")), toolkit.M{}.Set("data", toolkit.M{}.Set("Grade", 10)))
+		So(err, ShouldBeNil)
+		So(lines(), ShouldEqual, 4)
+		So(fileSize(tombPath), ShouldBeGreaterThan, 0)
+		So(fetch(dbflex.From(tableName).Select().Where(dbflex.Eq("_id", "E0")))[0].GetInt("Grade"), ShouldEqual, 10)
+		So(len(fetch(dbflex.From(tableName).Select().Where(dbflex.Gt("Grade", 1)))), ShouldEqual, 2)
+
+		_, err = conn.Execute(dbflex.From(tableName).Delete().Where(dbflex.Eq("_id", "E1")), nil)
+		So(err, ShouldBeNil)
+		So(len(fetch(dbflex.From(tableName).Select())), ShouldEqual, 2)
+
+		// third dead line trigger compaction
+		_, err = conn.Execute(dbflex.From(tableName).Delete().Where(dbflex.Eq("_id", "E2")), nil)
+		So(err, ShouldBeNil)
+		So(lines(), ShouldEqual, 1)
+		So(fileSize(tombPath), ShouldEqual, 0)
+		So(fileSize(pendingPath(tombPath)), ShouldEqual, 0)
+
+		Convey("Stopped compaction", func() {
+			for _, id := range []string{"X0", "X1"} {
+				_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", id).Set("Grade", 20)))
+				So(err, ShouldBeNil)
+			}
+			bs, _ := ioutil.ReadFile(dataPath)
+			rows := strings.Split(strings.TrimSpace(string(bs)), "\n")
+			So(len(rows), ShouldEqual, 3)
+
+			// X0 was deleted and the process stopped before the compacted file replace the data file
+			So(ioutil.WriteFile(pendingPath(tombPath), []byte("3 2\n1\n"), 0644), ShouldBeNil)
+			So(len(fetch(dbflex.From(tableName).Select())), ShouldEqual, 2)
+			So(fileSize(pendingPath(tombPath)), ShouldEqual, 0)
+			So(fileSize(tombPath), ShouldBeGreaterThan, 0)
+
+			// the process stopped after the compacted file replace the data file, tombstones should not be applied again
+			So(conn.(*Connection).Compact(tableName), ShouldBeNil)
+			So(lines(), ShouldEqual, 2)
+			So(ioutil.WriteFile(pendingPath(tombPath), []byte("3 2\n1\n"), 0644), ShouldBeNil)
+			So(len(fetch(dbflex.From(tableName).Select())), ShouldEqual, 2)
+			So(fileSize(pendingPath(tombPath)), ShouldEqual, 0)
+			So(fileSize(tombPath), ShouldEqual, 0)
+
+			_, err = conn.Execute(dbflex.From(tableName).Delete(), nil)
+			So(err, ShouldBeNil)
+			So(len(fetch(dbflex.From(tableName).Select())), ShouldEqual, 0)
+			So(fileSize(tombPath), ShouldEqual, 0)
+		})
+
+		Convey("Convert directory", func() {
+			So(ConvertDir(dirPath, "json", FormatArray), ShouldBeNil)
+			bs, _ := ioutil.ReadFile(dataPath)
+			So(string(bs), ShouldStartWith, "[")
+
+			arrayConn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json", dirPath), toolkit.M{})
+			So(err, ShouldBeNil)
+			So(arrayConn.Connect(), ShouldBeNil)
+			buffer := []toolkit.M{}
+			So(arrayConn.Cursor(dbflex.From(tableName).Select().Where(dbflex.Eq("Grade", 10)), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(len(buffer), ShouldEqual, 1)
+
+			So(ConvertDir(dirPath, "json", FormatLines), ShouldBeNil)
+			So(len(fetch(dbflex.From(tableName).Select())), ShouldEqual, 1)
+		})
 	})
 }
+
+func TestCompressedTable(t *testing.T) {
+	Convey("Compressed table", t, func() {
+		tableName := "employees-gzip"
+		dirPath := filepath.Join(workpath, "dbflex-gzip")
+		So(os.MkdirAll(dirPath, 0755), ShouldBeNil)
+
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json&compression=gzip", dirPath), toolkit.M{})
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+		defer conn.Close()
+
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		for i := 0; i < 3; i++ {
+			_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", toolkit.Sprintf("E%d", i)).Set("Grade", i)))
+			So(err, ShouldBeNil)
+		}
+
+		bs, err := ioutil.ReadFile(filepath.Join(dirPath, tableName+".json.gz"))
+		So(err, ShouldBeNil)
+		So(bs[:2], ShouldResemble, []byte{0x1f, 0x8b})
+		So(conn.ObjectNames(dbflex.ObjTypeTable), ShouldContain, tableName)
+
+		_, err = conn.Execute(dbflex.From(tableName).Update().Where(dbflex.Eq("_id", "E1")), toolkit.M{}.Set("data", toolkit.M{}.Set("Grade", 10)))
+		So(err, ShouldBeNil)
+		_, err = conn.Execute(dbflex.From(tableName).Delete().Where(dbflex.Eq("_id", "E0")), nil)
+		So(err, ShouldBeNil)
+
+		buffer := []toolkit.M{}
+		So(conn.Cursor(dbflex.From(tableName).Select().OrderBy("-Grade"), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+		So(len(buffer), ShouldEqual, 2)
+		So(buffer[0].GetInt("Grade"), ShouldEqual, 10)
+
+		// temporary plain files are removed, the table is locked using separate lock file since the table file is replaced
+		files, _ := ioutil.ReadDir(dirPath)
+		tableFiles := []string{}
+		for _, fi := range files {
+			if !filemeta.IsMetaFile(fi.Name()) {
+				tableFiles = append(tableFiles, fi.Name())
+			}
+		}
+		So(tableFiles, ShouldResemble, []string{tableName + ".json.gz"})
+		_, err = os.Stat(filemeta.LockPath(dirPath, tableName))
+		So(err, ShouldBeNil)
+	})
+}
+
+type bulkEmployee struct {
+	EmployeeID string `json:"EmployeeID"`
+	Email      string `json:"Email"`
+	Grade      int    `json:"Grade"`
+}
+
+func (e *bulkEmployee) Validate() error {
+	if e.Grade <= 0 {
+		return dbflex.NewError(dbflex.ErrConstraint, "grade should be positive", nil).WithField("Grade")
+	}
+	return nil
+}
+
+func TestBulkInsert(t *testing.T) {
+	Convey("Bulk insert", t, func() {
+		sourceTable, tableName := "employees-bulk-source", "employees-bulk"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json", workpath), toolkit.M{})
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+		defer conn.Close()
+
+		conn.Execute(dbflex.From(sourceTable).Delete(), nil)
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		So(conn.(*Connection).EnsureTable(tableName, nil, uniqueEmployee{}), ShouldBeNil)
+
+		source := []toolkit.M{}
+		for i := 1; i <= 10; i++ {
+			source = append(source, toolkit.M{}.
+				Set("EmployeeID", toolkit.Sprintf("E%d", i)).
+				Set("Email", toolkit.Sprintf("e%d@mail.com", i)).
+				Set("Grade", i%4))
+		}
+		source[6]["Email"] = "e1@mail.com"
+		_, err = conn.Execute(dbflex.From(sourceTable).Insert(), toolkit.M{}.Set("data", source))
+		So(err, ShouldBeNil)
+
+		inserts := 0
+		conn.AddInterceptor(func(info *dbflex.InterceptInfo, next dbflex.InterceptHandler) error {
+			if info.Action == dbflex.InterceptExecute {
+				inserts++
+			}
+			return next(info)
+		})
+
+		c := conn.Cursor(dbflex.From(sourceTable).Select(), nil)
+		defer c.Close()
+		report, err := dbflex.BulkInsert(conn, tableName, c, &dbflex.BulkOptions{BatchSize: 4, Model: bulkEmployee{}})
+		So(err, ShouldBeNil)
+		So(report.Read, ShouldEqual, 10)
+		So(report.Inserted, ShouldEqual, 7)
+		So(len(report.Rejected), ShouldEqual, 3)
+		So(report.Rejected[0].Index, ShouldEqual, 3)
+		So(errors.Is(report.Rejected[0].Err, dbflex.ErrConstraint), ShouldBeTrue)
+		So(report.Rejected[1].Index, ShouldEqual, 6)
+		So(errors.Is(report.Rejected[1].Err, dbflex.ErrDuplicateKey), ShouldBeTrue)
+		So(report.Rejected[2].Index, ShouldEqual, 7)
+		// records of the batch with duplicate key are inserted one by one
+		So(inserts, ShouldEqual, 1+(1+4))
+
+		buffer := []uniqueEmployee{}
+		So(conn.Cursor(dbflex.From(tableName).Select(), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+		So(len(buffer), ShouldEqual, 7)
+
+		Convey("Stop when too many records are rejected", func() {
+			report, err := dbflex.BulkInsert(conn, tableName, source, &dbflex.BulkOptions{MaxRejected: 2})
+			So(errors.Is(err, dbflex.ErrTooManyRejected), ShouldBeTrue)
+			So(len(report.Rejected), ShouldEqual, 3)
+		})
+	})
+}
+
+func TestExecResult(t *testing.T) {
+	Convey("Execute result", t, func() {
+		tableName := "employees-result"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json", workpath), toolkit.M{})
+		So(err, ShouldBeNil)
+
+		err = conn.Connect()
+		So(err, ShouldBeNil)
+
+		_, err = conn.Execute(dbflex.From(tableName).Delete(), nil)
+		So(err, ShouldBeNil)
+
+		out, err := conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", []toolkit.M{
+			{"_id": "A", "Grade": "1"}, {"_id": "B", "Grade": "1"}, {"_id": "C", "Grade": "2"},
+		}))
+		So(err, ShouldBeNil)
+		res, ok := dbflex.ToExecResult(out)
+		So(ok, ShouldBeTrue)
+		So(res.RowsAffected, ShouldEqual, 3)
+		So(res.InsertedIDs, ShouldResemble, []interface{}{"A", "B", "C"})
+
+		out, err = conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("Grade", "1")).Update("Grade"),
+			toolkit.M{}.Set("data", toolkit.M{}.Set("Grade", "2")))
+		So(err, ShouldBeNil)
+		res, _ = dbflex.ToExecResult(out)
+		So(res.RowsMatched, ShouldEqual, 2)
+		So(res.RowsAffected, ShouldEqual, 2)
+
+		Convey("Update that match nothing or change nothing", func() {
+			out, err := conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("_id", "X")).Update("Grade"),
+				toolkit.M{}.Set("data", toolkit.M{}.Set("Grade", "3")))
+			So(err, ShouldBeNil)
+			res, _ := dbflex.ToExecResult(out)
+			So(res.RowsMatched, ShouldEqual, 0)
+
+			out, err = conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("_id", "A")).Update("Grade"),
+				toolkit.M{}.Set("data", toolkit.M{}.Set("Grade", "2")))
+			So(err, ShouldBeNil)
+			res, _ = dbflex.ToExecResult(out)
+			So(res.RowsMatched, ShouldEqual, 1)
+			So(res.RowsAffected, ShouldEqual, 0)
+		})
+
+		Convey("Save and delete", func() {
+			out, err := conn.Execute(dbflex.From(tableName).Save(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "A").Set("Grade", "3")))
+			So(err, ShouldBeNil)
+			res, _ := dbflex.ToExecResult(out)
+			So(res.RowsMatched, ShouldEqual, 1)
+			So(len(res.InsertedIDs), ShouldEqual, 0)
+
+			out, err = conn.Execute(dbflex.From(tableName).Save(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "D").Set("Grade", "3")))
+			So(err, ShouldBeNil)
+			res, _ = dbflex.ToExecResult(out)
+			So(res.RowsMatched, ShouldEqual, 0)
+			So(res.InsertedIDs, ShouldResemble, []interface{}{"D"})
+
+			out, err = conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("Grade", "2")).Delete(), nil)
+			So(err, ShouldBeNil)
+			res, _ = dbflex.ToExecResult(out)
+			So(res.RowsAffected, ShouldEqual, 2)
+
+			out, err = conn.Execute(dbflex.From(tableName).Delete(), nil)
+			So(err, ShouldBeNil)
+			res, _ = dbflex.ToExecResult(out)
+			So(res.RowsAffected, ShouldEqual, 2)
+		})
+	})
+}
+
+func TestUpdateDoc(t *testing.T) {
+	Convey("Update document", t, func() {
+		tableName := "employees-updatedoc"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json", workpath), toolkit.M{})
+		So(err, ShouldBeNil)
+
+		err = conn.Connect()
+		So(err, ShouldBeNil)
+
+		_, err = conn.Execute(dbflex.From(tableName).Delete(), nil)
+		So(err, ShouldBeNil)
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", []toolkit.M{
+			{"_id": "A", "Name": "Bagus", "Grade": 1, "Score": 70},
+			{"_id": "B", "Name": "Cahyono", "Grade": 2, "Score": 80},
+		}))
+		So(err, ShouldBeNil)
+
+		read := func(id string) toolkit.M {
+			buffer := []toolkit.M{}
+			err := conn.Cursor(dbflex.From(tableName).Where(dbflex.Eq("_id", id)), nil).Fetchs(&buffer, 0).Error()
+			So(err, ShouldBeNil)
+			So(len(buffer), ShouldEqual, 1)
+			return buffer[0]
+		}
+
+		Convey("Apply operators", func() {
+			doc := dbflex.NewUpdate().Inc("Grade", 2).Mul("Score", 2).Max("Name", "Dody")
+			out, err := conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("_id", "A")).Update(), toolkit.M{}.Set("data", doc))
+			So(err, ShouldBeNil)
+			res, _ := dbflex.ToExecResult(out)
+			So(res.RowsAffected, ShouldEqual, 1)
+
+			m := read("A")
+			So(m.GetInt("Grade"), ShouldEqual, 3)
+			So(m.GetInt("Score"), ShouldEqual, 140)
+			So(m.GetString("Name"), ShouldEqual, "Dody")
+
+			_, err = conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("_id", "B")).Update(),
+				toolkit.M{}.Set("data", dbflex.NewUpdate().Min("Score", 90).Unset("Name")))
+			So(err, ShouldBeNil)
+			m = read("B")
+			So(m.GetInt("Score"), ShouldEqual, 80)
+			So(m.GetString("Name"), ShouldEqual, "")
+		})
+
+		Convey("Only named fields are updated", func() {
+			_, err := conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("_id", "A")).Update("Grade"),
+				toolkit.M{}.Set("data", toolkit.M{}.Set("Name", "Dody").Set("Grade", 5)))
+			So(err, ShouldBeNil)
+			m := read("A")
+			So(m.GetInt("Grade"), ShouldEqual, 5)
+			So(m.GetString("Name"), ShouldEqual, "Bagus")
+		})
+
+		Convey("Save update document", func() {
+			doc := dbflex.NewUpdate().Set("_id", "C").Set("Name", "Eko").Set("Score", 60).Inc("Grade", 1)
+			_, err := conn.Execute(dbflex.From(tableName).Save(), toolkit.M{}.Set("data", doc))
+			So(err, ShouldBeNil)
+			So(read("C").GetInt("Grade"), ShouldEqual, 1)
+
+			_, err = conn.Execute(dbflex.From(tableName).Save(), toolkit.M{}.Set("data", doc))
+			So(err, ShouldBeNil)
+			So(read("C").GetInt("Grade"), ShouldEqual, 2)
+		})
+	})
+}
+
 func TestCRUD(t *testing.T) {
 	crud := testbase.NewCRUD(t, toolkit.Sprintf("json://localhost/%s?extension=json", workpath), 1000, toolkit.M{})
 	crud.RunTest()
@@ -239,3 +1006,103 @@ func TestCRUD(t *testing.T) {
 		}
 	})
 }
+
+// failingConnection fail the first n Execute and Cursor calls with err
+type failingConnection struct {
+	dbflex.IConnection
+
+	n     int
+	err   error
+	calls int
+}
+
+func (c *failingConnection) Execute(cmd dbflex.ICommand, m toolkit.M) (interface{}, error) {
+	if c.calls++; c.calls <= c.n {
+		return nil, c.err
+	}
+	return c.IConnection.Execute(cmd, m)
+}
+
+func (c *failingConnection) Cursor(cmd dbflex.ICommand, m toolkit.M) dbflex.ICursor {
+	if c.calls++; c.calls <= c.n {
+		cursor := new(dbflex.CursorBase)
+		cursor.SetError(c.err)
+		return cursor
+	}
+	return c.IConnection.Cursor(cmd, m)
+}
+
+func TestRetryConnection(t *testing.T) {
+	Convey("Retry connection", t, func() {
+		tableName := "employees-retry"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json", workpath), toolkit.M{})
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "A").Set("Grade", 1)))
+
+		lockErr := dbflex.NewError(dbflex.ErrLockTimeout, "table is locked", nil)
+		fake := &failingConnection{IConnection: conn, n: 2, err: lockErr}
+		policy := &dbflex.RetryPolicy{MaxAttempts: 3, BaseDelay: 20 * time.Millisecond, MaxDelay: 40 * time.Millisecond}
+		retryConn := dbflex.NewRetryConnection(fake, policy)
+		cmd := dbflex.From(tableName).Select()
+
+		Convey("Transient error is retried with backoff", func() {
+			buffer := []toolkit.M{}
+			start := time.Now()
+			So(retryConn.Cursor(cmd, nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(fake.calls, ShouldEqual, 3)
+			So(len(buffer), ShouldEqual, 1)
+			// jitter keep at least half of 20ms and 40ms delays
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 30*time.Millisecond)
+		})
+
+		Convey("Error is returned after max attempts", func() {
+			fake.n = 5
+			err := retryConn.Cursor(cmd, nil).Error()
+			So(fake.calls, ShouldEqual, 3)
+			So(errors.Is(err, dbflex.ErrLockTimeout), ShouldBeTrue)
+			var retryErr *dbflex.RetryError
+			So(errors.As(err, &retryErr), ShouldBeTrue)
+			So(retryErr.Attempts, ShouldEqual, 3)
+		})
+
+		Convey("Non retryable error is returned immediately", func() {
+			fake.err = errors.New("syntax error")
+			So(dbflex.IsTransientError(fake.err), ShouldBeFalse)
+			So(dbflex.IsTransientError(errors.New("Deadlock found when trying to get lock")), ShouldBeTrue)
+			So(dbflex.IsTransientError(dbflex.NewError(dbflex.ErrNotFound, "not found", nil)), ShouldBeFalse)
+
+			So(retryConn.Cursor(cmd, nil).Error(), ShouldEqual, fake.err)
+			So(fake.calls, ShouldEqual, 1)
+		})
+
+		Convey("Non retryable error after retries keep the attempts", func() {
+			syntaxErr := errors.New("syntax error")
+			err := policy.Run(func() error {
+				fake.calls++
+				if fake.calls == 1 {
+					return lockErr
+				}
+				return syntaxErr
+			})
+			So(errors.Is(err, syntaxErr), ShouldBeTrue)
+			var retryErr *dbflex.RetryError
+			So(errors.As(err, &retryErr), ShouldBeTrue)
+			So(retryErr.Attempts, ShouldEqual, 2)
+		})
+
+		Convey("Write is not retried unless RetryWrites is set", func() {
+			insert := dbflex.From(tableName).Insert()
+			_, err := retryConn.Execute(insert, toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "B").Set("Grade", 2)))
+			So(errors.Is(err, dbflex.ErrLockTimeout), ShouldBeTrue)
+			So(fake.calls, ShouldEqual, 1)
+
+			fake.calls = 0
+			policy.RetryWrites = true
+			_, err = retryConn.Execute(insert, toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "B").Set("Grade", 2)))
+			So(err, ShouldBeNil)
+			So(fake.calls, ShouldEqual, 3)
+		})
+	})
+}
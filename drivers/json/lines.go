@@ -0,0 +1,719 @@
+package json
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filecodec"
+	"git.kanosolution.net/kano/dbflex/drivers/filemeta"
+	"github.com/eaciit/toolkit"
+	flock "github.com/theckman/go-flock"
+)
+
+const (
+	// FormatArray store each table as one JSON array, this is the default format
+	FormatArray = "array"
+	// FormatLines store each table as JSON Lines, one record per line. Insert is appended to the end of the file,
+	// update and delete mark the old lines in a tombstone file and the file is compacted once it has too many dead lines
+	FormatLines = "jsonl"
+)
+
+// CompactRatio is ratio of dead lines to all lines of a JSON Lines file that trigger compaction after update or delete
+var CompactRatio = 0.5
+
+// record is a record read from data file, start and end are its position on the file
+type record struct {
+	data  toolkit.M
+	line  int
+	start int64
+	end   int64
+}
+
+func tombstonePath(dirPath, tableName string) string {
+	return filepath.Join(dirPath, filemeta.Prefix+"."+strings.ToLower(tableName)+".tomb")
+}
+
+// loadTombstones return line numbers of dead lines
+func loadTombstones(path string) (map[int]bool, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[int]bool{}, nil
+		}
+		return nil, err
+	}
+	return parseTombstones(path, bs)
+}
+
+func parseTombstones(path string, bs []byte) (map[int]bool, error) {
+	res := map[int]bool{}
+	for _, txt := range strings.Split(string(bs), "\n") {
+		if txt = strings.TrimSpace(txt); txt == "" {
+			continue
+		}
+		line, err := strconv.Atoi(txt)
+		if err != nil {
+			return nil, toolkit.Errorf("invalid tombstone %s. %w", path, err)
+		}
+		res[line] = true
+	}
+	return res, nil
+}
+
+func appendTombstones(path string, lines []int) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	txt := ""
+	for _, line := range lines {
+		txt += strconv.Itoa(line) + "\n"
+	}
+	if _, err = f.WriteString(txt); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// isLinesFile return true if the file is not a JSON array, empty file is considered as JSON Lines
+func isLinesFile(file *os.File) bool {
+	buf := make([]byte, 512)
+	n, _ := file.ReadAt(buf, 0)
+	trimmed := bytes.TrimLeft(buf[:n], " \t\r\n")
+	return len(trimmed) == 0 || trimmed[0] != '['
+}
+
+// openRecords return function that read live records of the data file one by one from the beginning of the file
+func openRecords(file *os.File, tombPath string) (func() (*record, error), error) {
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	return readerRecords(file, tombPath)
+}
+
+// readerRecords return function that read live records one by one, it return io.EOF after the last record.
+// Format of the data is detected from its content, tombstones are only used for JSON Lines
+func readerRecords(r io.Reader, tombPath string) (func() (*record, error), error) {
+	reader := bufio.NewReader(r)
+	// empty data is considered as JSON Lines
+	head, _ := reader.Peek(512)
+	head = bytes.TrimLeft(head, " \t\r\n")
+
+	if len(head) > 0 && head[0] == '[' {
+		decoder := json.NewDecoder(reader)
+		// Read open bracket
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+
+		line := 0
+		offset := decoder.InputOffset()
+		return func() (*record, error) {
+			if !decoder.More() {
+				return nil, io.EOF
+			}
+
+			r := &record{data: toolkit.M{}, line: line, start: offset}
+			if err := decoder.Decode(&r.data); err != nil {
+				return nil, err
+			}
+			offset = decoder.InputOffset()
+			r.end = offset
+			line++
+			return r, nil
+		}, nil
+	}
+
+	tombs, err := loadTombstones(tombPath)
+	if err != nil {
+		return nil, err
+	}
+
+	line := -1
+	offset := int64(0)
+	return func() (*record, error) {
+		for {
+			bs, err := reader.ReadBytes('\n')
+			if len(bs) == 0 && err != nil {
+				return nil, err
+			}
+			line++
+			start := offset
+			offset += int64(len(bs))
+
+			if tombs[line] || len(bytes.TrimSpace(bs)) == 0 {
+				continue
+			}
+
+			r := &record{data: toolkit.M{}, line: line, start: start, end: offset}
+			if err := json.Unmarshal(bs, &r.data); err != nil {
+				return nil, toolkit.Errorf("invalid record on line %d. %w", line+1, err)
+			}
+			return r, nil
+		}
+	}, nil
+}
+
+// scanRecords return function that read records one by one, it return io.EOF after the last record
+func scanRecords(r io.Reader, tombPath string) (func() (toolkit.M, error), error) {
+	next, err := readerRecords(r, tombPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (toolkit.M, error) {
+		r, err := next()
+		if err != nil {
+			return nil, err
+		}
+		return r.data, nil
+	}, nil
+}
+
+func readRecords(file *os.File, tombPath string) ([]*record, error) {
+	next, err := openRecords(file, tombPath)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*record{}
+	for {
+		r, err := next()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+}
+
+// appendLines write each data as a line at the end of the file
+func appendLines(file *os.File, datas []interface{}) error {
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	for _, data := range datas {
+		bs, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		buf.Write(bs)
+		buf.WriteByte('\n')
+	}
+
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// compactLines rewrite the file without dead lines if number of dead lines is more than CompactRatio or force is true.
+// The file is replaced by a new file with the same name, so it should not be used after compaction
+func compactLines(file *os.File, tombPath string, force bool) error {
+	tombData, err := ioutil.ReadFile(tombPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	tombs, err := parseTombstones(tombPath, tombData)
+	if err != nil {
+		return err
+	}
+	if len(tombs) == 0 {
+		return nil
+	}
+
+	if _, err = file.Seek(0, 0); err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	reader := bufio.NewReader(file)
+	total := 0
+	for line := 0; ; line++ {
+		bs, err := reader.ReadBytes('\n')
+		if len(bs) == 0 && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		total++
+		if !tombs[line] && len(bytes.TrimSpace(bs)) > 0 {
+			buf.Write(bytes.TrimRight(bs, "\n"))
+			buf.WriteByte('\n')
+		}
+	}
+
+	if !force && float64(len(tombs)) <= CompactRatio*float64(total) {
+		return nil
+	}
+
+	// Tombstones are moved to the pending file together with line count of the file before and after compaction.
+	// If the process stop before the compaction is finished, recoverLines use the line count of the data file
+	// to decide whether the tombstones still apply to it
+	pending := toolkit.Sprintf("%d %d\n", total, bytes.Count(buf.Bytes(), []byte("\n")))
+	if err = writeFileSync(pendingPath(tombPath), append([]byte(pending), tombData...)); err != nil {
+		return err
+	}
+	if err = os.Remove(tombPath); err != nil {
+		return err
+	}
+	return writeFileSync(file.Name(), buf.Bytes())
+}
+
+func pendingPath(tombPath string) string {
+	return tombPath + ".pending"
+}
+
+// endCompaction remove the pending tombstones once the compacted file is stored as the table data
+func endCompaction(tombPath string) error {
+	if err := os.Remove(pendingPath(tombPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// recoverPending finish compaction that was stopped before endCompaction using content of the table data.
+// If the data still has the line count before compaction, the pending tombstones are restored
+func recoverPending(r io.Reader, tombPath string) error {
+	bs, err := ioutil.ReadFile(pendingPath(tombPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	header, tombData := string(bs), []byte{}
+	if i := strings.Index(header, "\n"); i >= 0 {
+		header, tombData = header[:i], bs[i+1:]
+	}
+	var before, after int
+	if _, err = fmt.Sscanf(header, "%d %d", &before, &after); err != nil {
+		return toolkit.Errorf("invalid pending tombstone %s. %w", pendingPath(tombPath), err)
+	}
+
+	total, err := countLines(r)
+	if err != nil {
+		return err
+	}
+	if total != after {
+		if err = writeFileSync(tombPath, tombData); err != nil {
+			return err
+		}
+	}
+	return endCompaction(tombPath)
+}
+
+// recoverLines run recoverPending on the table file with the table locked, it is used by readers that don't lock the table
+func recoverLines(dirPath, tableName, dataPath string) error {
+	tombPath := tombstonePath(dirPath, tableName)
+	if _, err := os.Stat(pendingPath(tombPath)); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	lockCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	lockPath := filemeta.LockPath(dirPath, tableName)
+	fileLock := flock.NewFlock(lockPath)
+	if _, err := fileLock.TryLockContext(lockCtx, 10*time.Millisecond); err != nil {
+		return dbflex.NewError(dbflex.ErrLockTimeout, toolkit.Sprintf("unable to lock file %s", lockPath), err).WithTable(tableName)
+	}
+	defer fileLock.Unlock()
+
+	file, err := filecodec.Open(dataPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return endCompaction(tombPath)
+		}
+		return err
+	}
+	defer file.Close()
+	return recoverPending(file, tombPath)
+}
+
+// countLines return number of lines of the data counted the same way as compactLines
+func countLines(r io.Reader) (int, error) {
+	reader := bufio.NewReader(r)
+	total := 0
+	for {
+		bs, err := reader.ReadBytes('\n')
+		if len(bs) == 0 && err != nil {
+			if err == io.EOF {
+				return total, nil
+			}
+			return 0, err
+		}
+		total++
+	}
+}
+
+// writeFileSync replace content of the file by writing it to a temporary file that is renamed to the path
+func writeFileSync(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func dataSlice(data interface{}) []interface{} {
+	if reflect.TypeOf(data).Kind() != reflect.Slice {
+		return []interface{}{data}
+	}
+
+	d := reflect.ValueOf(data)
+	res := make([]interface{}, d.Len())
+	for i := 0; i < d.Len(); i++ {
+		res[i] = d.Index(i).Interface()
+	}
+	return res
+}
+
+// executeLines run insert, update, delete and save command on JSON Lines file
+func (q *Query) executeLines(cmdType string, parm toolkit.M, filter *dbflex.Filter, file *os.File, tableName string, tableMeta *filemeta.Table) (*dbflex.ExecResult, error) {
+	tombPath := tombstonePath(q.Connection().(*Connection).dirPath, tableName)
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	if err := recoverPending(file, tombPath); err != nil {
+		return nil, err
+	}
+
+	insert := func(data interface{}) (*dbflex.ExecResult, error) {
+		datas := dataSlice(data)
+
+		// Existing records are only needed if the table has key or unique index
+		if filemeta.NewUniqueChecker(tableName, tableMeta) != nil {
+			existing, err := readRecords(file, tombPath)
+			if err != nil {
+				return nil, err
+			}
+
+			records := []interface{}{}
+			for _, r := range existing {
+				records = append(records, r.data)
+			}
+			if err = checkUnique(tableName, tableMeta, append(records, datas...)...); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := appendLines(file, datas); err != nil {
+			return nil, err
+		}
+		return &dbflex.ExecResult{RowsAffected: int64(len(datas)), InsertedIDs: insertedIDs(tableMeta, datas)}, nil
+	}
+
+	// update append the updated records as new lines and mark the old lines as dead
+	update := func(data interface{}, fields []string, filter *dbflex.Filter) (*dbflex.ExecResult, error) {
+		apply, err := updater(data, fields)
+		if err != nil {
+			return nil, err
+		}
+
+		existing, err := readRecords(file, tombPath)
+		if err != nil {
+			return nil, err
+		}
+
+		res := new(dbflex.ExecResult)
+		records := make([]interface{}, len(existing))
+		updated := []interface{}{}
+		deadLines := []int{}
+		for i, r := range existing {
+			records[i] = r.data
+
+			ok, err := isIncluded(r.data, filter)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			res.RowsMatched++
+			// unchanged record is kept in its line
+			changed, err := apply(r.data)
+			if err != nil {
+				return nil, err
+			}
+			if !changed {
+				continue
+			}
+			res.RowsAffected++
+			updated = append(updated, r.data)
+			deadLines = append(deadLines, r.line)
+		}
+
+		if len(updated) == 0 {
+			return res, nil
+		}
+
+		if err = checkUnique(tableName, tableMeta, records...); err != nil {
+			return nil, err
+		}
+		if err = appendLines(file, updated); err != nil {
+			return nil, err
+		}
+		if err = appendTombstones(tombPath, deadLines); err != nil {
+			return nil, err
+		}
+		return res, compactLines(file, tombPath, false)
+	}
+
+	switch cmdType {
+	case dbflex.QuerySelect:
+		return nil, toolkit.Errorf("select command should use cursor instead of execute")
+
+	case dbflex.QueryInsert:
+		data, hasData := parm["data"]
+		if !hasData {
+			return nil, toolkit.Errorf("insert fail, no data")
+		}
+		return insert(data)
+
+	case dbflex.QueryUpdate:
+		data, hasData := parm["data"]
+		if !hasData {
+			return nil, toolkit.Errorf("update fail, no data")
+		}
+		return update(data, q.Config(dbflex.ConfigKeyFields, []string{}).([]string), filter)
+
+	case dbflex.QuerySave:
+		data, hasData := parm["data"]
+		if !hasData {
+			return nil, toolkit.Errorf("update fail, no data")
+		}
+		// Update document is inserted as the record it produce
+		record, err := saveRecord(data)
+		if err != nil {
+			return nil, err
+		}
+		mData, err := objToM(record)
+		if err != nil {
+			return nil, err
+		}
+
+		// Create filter from keys of the command or the table, _id is used if both are not set.
+		// If any of the key is not exist, data will be inserted
+		keys := q.saveKeys(tableMeta)
+		keyFilters := []*dbflex.Filter{}
+		for _, k := range keys {
+			if v, ok := filemeta.Lookup(mData, k); ok {
+				keyFilters = append(keyFilters, dbflex.Eq(k, v))
+			}
+		}
+		if len(keyFilters) != len(keys) {
+			return insert(record)
+		}
+
+		keyFilter := keyFilters[0]
+		if len(keyFilters) > 1 {
+			keyFilter = dbflex.And(keyFilters...)
+		}
+		res, err := update(data, nil, keyFilter)
+		if err != nil || res.RowsMatched > 0 {
+			return res, err
+		}
+		return insert(record)
+
+	case dbflex.QueryDelete:
+		existing, err := readRecords(file, tombPath)
+		if err != nil {
+			return nil, err
+		}
+
+		// If there is no filter at all then it means delete all data
+		// If there is no filter at all then it means delete all data, all lines are marked as dead and the file is compacted
+		deadLines := []int{}
+		for _, r := range existing {
+			ok := filter == nil
+			if !ok {
+				if ok, err = isIncluded(r.data, filter); err != nil {
+					return nil, err
+				}
+			}
+			if ok {
+				deadLines = append(deadLines, r.line)
+			}
+		}
+		if err = appendTombstones(tombPath, deadLines); err != nil {
+			return nil, err
+		}
+		res := &dbflex.ExecResult{RowsMatched: int64(len(deadLines)), RowsAffected: int64(len(deadLines))}
+		return res, compactLines(file, tombPath, filter == nil)
+
+	default:
+		return nil, toolkit.Errorf("unknown command: %s", cmdType)
+	}
+}
+
+// Compact rewrite JSON Lines file of the table without its dead lines
+func (c *Connection) Compact(tableName string) error {
+	dataPath, err := c.tablePath(tableName)
+	if err != nil {
+		return err
+	}
+	return c.withTableLock(tableName, dataPath, func(file *os.File) error {
+		if !isLinesFile(file) {
+			return nil
+		}
+		return compactLines(file, tombstonePath(c.dirPath, tableName), true)
+	})
+}
+
+// withTableLock lock the data file of the table, call fn with the opened file and rebuild secondary indexes of the table.
+// Compressed file is decompressed before fn is called and compressed back after it
+func (c *Connection) withTableLock(tableName, dataPath string, fn func(*os.File) error) error {
+	tableMeta, err := filemeta.LoadTable(c.dirPath, tableName)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	lockCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	lockPath := filemeta.LockPath(c.dirPath, tableName)
+	fileLock := flock.NewFlock(lockPath)
+	if _, err = fileLock.TryLockContext(lockCtx, 10*time.Millisecond); err != nil {
+		return dbflex.NewError(dbflex.ErrLockTimeout, toolkit.Sprintf("unable to lock file %s", lockPath), err).WithTable(tableName)
+	}
+	defer fileLock.Unlock()
+
+	tombPath := tombstonePath(c.dirPath, tableName)
+	err = filecodec.Edit(dataPath, func(plainPath string) error {
+		file, err := os.OpenFile(plainPath, os.O_RDWR, os.ModeExclusive)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if err = recoverPending(file, tombPath); err != nil {
+			return err
+		}
+		return fn(file)
+	})
+	if err != nil {
+		return err
+	}
+	if err = endCompaction(tombPath); err != nil {
+		return err
+	}
+	return buildIndex(c.dirPath, tableName, dataPath, tableMeta.SecondaryIndexes())
+}
+
+// ConvertDir convert all tables of a directory to given format (FormatArray or FormatLines).
+// Connection that use the directory should be reconnected using the new format after it
+func ConvertDir(dirPath, extension, format string) error {
+	if format != FormatArray && format != FormatLines {
+		return toolkit.Errorf("unknown format %s", format)
+	}
+
+	conn := new(Connection)
+	conn.dirPath = dirPath
+	conn.extension = extension
+
+	for _, tableName := range conn.ObjectNames(dbflex.ObjTypeTable) {
+		dataPath, err := conn.tablePath(tableName)
+		if err != nil {
+			return err
+		}
+		tombPath := tombstonePath(dirPath, tableName)
+
+		err = conn.withTableLock(tableName, dataPath, func(file *os.File) error {
+			if isLinesFile(file) == (format == FormatLines) {
+				return nil
+			}
+
+			existing, err := readRecords(file, tombPath)
+			if err != nil {
+				return err
+			}
+
+			if format == FormatArray {
+				datas := make([]toolkit.M, len(existing))
+				for i, r := range existing {
+					datas[i] = r.data
+				}
+				err = writeToJSONFile(datas, file)
+			} else {
+				datas := make([]interface{}, len(existing))
+				for i, r := range existing {
+					datas[i] = r.data
+				}
+				// Array file doesn't use tombstones, so they are removed before the lines are written
+				if err = os.Remove(tombPath); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				if err = file.Truncate(0); err == nil {
+					err = appendLines(file, datas)
+				}
+			}
+			if err != nil {
+				return toolkit.Errorf("unable to convert %s. %w", tableName, err)
+			}
+
+			if err = os.Remove(tombPath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,12 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"os"
-	"path/filepath"
 	"reflect"
-	"strings"
 	"time"
 
 	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filecodec"
+	"git.kanosolution.net/kano/dbflex/drivers/filemeta"
 	"github.com/eaciit/toolkit"
 	flock "github.com/theckman/go-flock"
 )
@@ -33,16 +33,21 @@ func (q *Query) BuildCommand() (interface{}, error) {
 
 func (q *Query) filePath() (string, error) {
 	conn := q.Connection().(*Connection)
-	filename := ""
 	tablename := q.Config(dbflex.ConfigKeyTableName, "").(string)
 
 	if tablename == "" {
 		return "", toolkit.Errorf("no tablename is specified")
 	}
 
-	filename = tablename + "." + conn.extension
-	filePath := filepath.Join(conn.dirPath, filename)
-	return filePath, nil
+	return conn.tablePath(tablename)
+}
+
+// saveKeys return key fields of Save command, they are keys of the command or keys of the table. _id is used if both are not set
+func (q *Query) saveKeys(meta *filemeta.Table) []string {
+	if keys, ok := q.Config(dbflex.ConfigKeyKeys, []string{}).([]string); ok && len(keys) > 0 {
+		return keys
+	}
+	return meta.KeyFields("_id")
 }
 
 // Cursor return cursor object for this query
@@ -71,11 +76,12 @@ func (q *Query) Cursor(toolkit.M) dbflex.ICursor {
 	c.extra = q.Config(dbflex.ConfigKeyGroupedQueryItems, dbflex.QueryItems{}).(dbflex.QueryItems)
 
 	c.filePath = filePath
+	c.tableName = q.Config(dbflex.ConfigKeyTableName, "").(string)
 	return c
 }
 
 // Execute the query with its configuration
-func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
+func (q *Query) Execute(parm toolkit.M) (res interface{}, err error) {
 	cmdType := q.Config(dbflex.ConfigKeyCommandType, "").(string)
 	where := q.Config(dbflex.ConfigKeyWhere, nil)
 
@@ -96,13 +102,16 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 
 	//-- if save, insert, update and delete. create the file
 	if (cmdType == dbflex.QueryInsert || cmdType == dbflex.QuerySave || cmdType == dbflex.QueryUpdate || cmdType == dbflex.QueryDelete) && !fileExist {
-		// Since this is json file write empty json array to the newly created file
-		file, err := os.Create(filePath)
-		file.WriteString("[]")
-		file.Close()
+		// Since this is json file write empty json array to the newly created file, JSON Lines file is left empty
+		content := "[]"
+		if q.Connection().(*Connection).format == FormatLines {
+			content = ""
+		}
+		err := filecodec.WriteFile(filePath, []byte(content))
 
 		if err != nil {
-			return err, toolkit.Errorf("unable to create file %s. %s", filePath, err.Error())
+			return nil, dbflex.NewError(dbflex.ErrStorage, toolkit.Sprintf("unable to create file %s", filePath), err).
+				WithTable(q.Config(dbflex.ConfigKeyTableName, "").(string))
 		}
 	}
 
@@ -114,11 +123,15 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 	lockCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	fileLock := flock.NewFlock(filePath)
+	tableName := q.Config(dbflex.ConfigKeyTableName, "").(string)
+	lockPath := filemeta.LockPath(q.Connection().(*Connection).dirPath, tableName)
+	fileLock := flock.NewFlock(lockPath)
 	// Try to get exclusive lock every 10ms until time out above
 	_, err = fileLock.TryLockContext(lockCtx, 10*time.Millisecond)
 	if err != nil {
-		return err, toolkit.Errorf("unable to lock file %s. %s", filePath, err.Error())
+		q.Connection().(*Connection).Unlock()
+		return nil, dbflex.NewError(dbflex.ErrLockTimeout, toolkit.Sprintf("unable to lock file %s", lockPath), err).
+			WithTable(tableName)
 	}
 
 	defer func() {
@@ -126,6 +139,30 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 		q.Connection().(*Connection).Unlock()
 	}()
 
+	// Compaction of JSON Lines file is finished once the data file is stored, after it is compressed back
+	defer func() {
+		if err == nil {
+			err = endCompaction(tombstonePath(q.Connection().(*Connection).dirPath, tableName))
+		}
+	}()
+
+	// Compressed table is decompressed to a temporary plain file, and compressed back after the command succeed
+	dataPath := filePath
+	if filecodec.ByFileName(filePath) != nil {
+		var plainPath string
+		if plainPath, err = filecodec.Decompress(filePath); err != nil {
+			return nil, err
+		}
+		filePath = plainPath
+
+		defer func() {
+			if err == nil {
+				err = filecodec.Compress(plainPath, dataPath)
+			}
+			os.Remove(plainPath)
+		}()
+	}
+
 	// Open file for writing mode
 	file, err := os.OpenFile(filePath, os.O_RDWR, os.ModeExclusive)
 	if err != nil {
@@ -134,37 +171,55 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 	// Don't forget to close the file after ;)
 	defer file.Close()
 
-	// Insert block
-	insert := func() error {
-		data, hasData := parm["data"]
-		if !hasData {
-			return toolkit.Errorf("insert fail, no data")
+	// Keys and unique indexes of the table, nil if table is not declared using EnsureTable
+	tableMeta, err := filemeta.LoadTable(q.Connection().(*Connection).dirPath, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rebuild secondary indexes after the data is changed, before the file lock is released
+	defer func() {
+		if err == nil {
+			err = buildIndex(q.Connection().(*Connection).dirPath, tableName, dataPath, tableMeta.SecondaryIndexes())
+		}
+	}()
+
+	if q.Connection().(*Connection).format == FormatLines {
+		execRes, err := q.executeLines(cmdType, parm, filter, file, tableName, tableMeta)
+		if err != nil {
+			return nil, err
 		}
+		return execRes, nil
+	}
 
+	// Insert block
+	insert := func(data interface{}) (*dbflex.ExecResult, error) {
 		datas := []interface{}{}
 
 		decoder := json.NewDecoder(file)
 		decoder.Decode(&datas)
 
+		nd := []interface{}{data}
 		if reflect.TypeOf(data).Kind() == reflect.Slice {
 			d := reflect.ValueOf(data)
-			nd := make([]interface{}, d.Len())
+			nd = make([]interface{}, d.Len())
 
 			for i := 0; i < d.Len(); i++ {
 				nd[i] = d.Index(i).Interface()
 			}
+		}
+		datas = append(datas, nd...)
 
-			datas = append(datas, nd...)
-		} else {
-			datas = append(datas, data)
+		if err := checkUnique(tableName, tableMeta, datas...); err != nil {
+			return nil, err
 		}
 
 		err := writeToJSONFile(datas, file)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		return nil
+		return &dbflex.ExecResult{RowsAffected: int64(len(nd)), InsertedIDs: insertedIDs(tableMeta, nd)}, nil
 	}
 	// End of insert block
 
@@ -177,17 +232,36 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 		if !hasData {
 			return nil, toolkit.Errorf("update fail, no data")
 		}
+		// Update document is inserted as the record it produce
+		record, err := saveRecord(data)
+		if err != nil {
+			return nil, err
+		}
 		// Convert to flat M for easier access
-		mData, err := objToM(data)
+		mData, err := objToM(record)
 		if err != nil {
 			return nil, err
 		}
+		apply, err := updater(data, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Create filter from keys of the command or the table, _id is used if both are not set.
+		// If any of the key is not exist, data will be inserted
+		keys := q.saveKeys(tableMeta)
+		keyFilters := []*dbflex.Filter{}
+		for _, k := range keys {
+			if v, ok := filemeta.Lookup(mData, k); ok {
+				keyFilters = append(keyFilters, dbflex.Eq(k, v))
+			}
+		}
 
-		// BUG: if the ID is not _id this command will not work
-		// Check if _id is exist
-		if v, ok := mData["_id"]; ok {
-			// If exist, create a filter eq _id
-			filter = dbflex.Eq("_id", v)
+		if len(keyFilters) == len(keys) {
+			filter = keyFilters[0]
+			if len(keyFilters) > 1 {
+				filter = dbflex.And(keyFilters...)
+			}
 
 			// Initiate new decoder from stream
 			decoder := json.NewDecoder(file)
@@ -199,7 +273,7 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 
 			// Initiate updated datas
 			updatedData := []interface{}{}
-			updatedCount := 0
+			updatedCount, changedCount := 0, 0
 
 			// Check if there is more data
 			for decoder.More() {
@@ -217,49 +291,57 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 				}
 
 				if ok {
-					// If old data match with given filter
-					// Convert the updated fields and its value to M
-					m, err := objToM(data)
+					// If old data match with given filter, apply the update to it
+					changed, err := apply(ed)
 					if err != nil {
-						return "", err
+						return nil, err
 					}
-
-					for _, h := range ed.Keys() {
-						for k, v := range m {
-							// Check old data header with updated fields name
-							if strings.ToLower(h) == strings.ToLower(k) {
-								ed[h] = v
-								break
-							}
-						}
+					if changed {
+						changedCount++
 					}
-
 					updatedCount++
 				}
 
 				updatedData = append(updatedData, ed)
 			}
 
+			res := &dbflex.ExecResult{RowsMatched: int64(updatedCount), RowsAffected: int64(changedCount)}
 			if updatedCount == 0 {
-				updatedData = append(updatedData, data)
+				updatedData = append(updatedData, record)
+				res.RowsAffected = 1
+				res.InsertedIDs = insertedIDs(tableMeta, []interface{}{record})
+			}
+
+			if err = checkUnique(tableName, tableMeta, updatedData...); err != nil {
+				return nil, err
 			}
 
 			err = writeToJSONFile(updatedData, file)
 			if err != nil {
 				return nil, err
 			}
+			return res, nil
 		} else {
-			return nil, insert()
+			return insert(record)
 		}
 
 	case dbflex.QueryInsert:
-		return nil, insert()
+		data, hasData := parm["data"]
+		if !hasData {
+			return nil, toolkit.Errorf("insert fail, no data")
+		}
+		return insert(data)
 
 	case dbflex.QueryUpdate:
 		data, hasData := parm["data"]
 		if !hasData {
 			return nil, toolkit.Errorf("update fail, no data")
 		}
+		// Only fields of the command are updated if they are given
+		apply, err := updater(data, q.Config(dbflex.ConfigKeyFields, []string{}).([]string))
+		if err != nil {
+			return nil, err
+		}
 
 		// Initiate new decoder from stream
 		decoder := json.NewDecoder(file)
@@ -271,6 +353,7 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 
 		// Initiate updated datas
 		updatedData := []toolkit.M{}
+		res := new(dbflex.ExecResult)
 
 		// Check if there is more data
 		for decoder.More() {
@@ -288,36 +371,44 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 			}
 
 			if ok {
-				// If old data match with given filter
-				// Convert the updated fields and its value to M
-				m, err := objToM(data)
+				// If old data match with given filter, apply the update to it
+				changed, err := apply(ed)
 				if err != nil {
-					return "", err
+					return nil, err
 				}
-
-				for _, h := range ed.Keys() {
-					for k, v := range m {
-						// Check old data header with updated fields name
-						if strings.ToLower(h) == strings.ToLower(k) {
-							ed[h] = v
-							break
-						}
-					}
+				if changed {
+					res.RowsAffected++
 				}
+				res.RowsMatched++
 			}
 
 			updatedData = append(updatedData, ed)
 		}
 
+		records := make([]interface{}, len(updatedData))
+		for i, ed := range updatedData {
+			records[i] = ed
+		}
+		if err = checkUnique(tableName, tableMeta, records...); err != nil {
+			return nil, err
+		}
+
 		err = writeToJSONFile(updatedData, file)
 		if err != nil {
 			return nil, err
 		}
+		return res, nil
 
 	case dbflex.QueryDelete:
+		res := new(dbflex.ExecResult)
 		// If there is no filter at all then it means delete all data
 		deleteAll := where == nil
 		if deleteAll {
+			datas := []interface{}{}
+			json.NewDecoder(file).Decode(&datas)
+			res.RowsMatched = int64(len(datas))
+			res.RowsAffected = res.RowsMatched
+
 			err := writeToJSONFile([]interface{}{}, file)
 			if err != nil {
 				return nil, err
@@ -351,6 +442,9 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 
 				if !ok {
 					updatedData = append(updatedData, data)
+				} else {
+					res.RowsMatched++
+					res.RowsAffected++
 				}
 			}
 
@@ -365,10 +459,9 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 				return nil, err
 			}
 		}
+		return res, nil
 
 	default:
 		return nil, toolkit.Errorf("unknown command: %s", cmdType)
 	}
-
-	return nil, nil
 }
@@ -1,9 +1,22 @@
 package rdbms
 
 import (
+	"database/sql"
+
 	"git.kanosolution.net/kano/dbflex"
 )
 
 type Connection struct {
 	dbflex.ConnectionBase
 }
+
+// SQLExecutor run sql command that return no rows, it is implemented by *sql.DB, *sql.Conn and *sql.Tx
+type SQLExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// SQLConnection is implemented by connection of sql driver to let Query.Execute run the command.
+// Executor should return the transaction if the connection is in transaction
+type SQLConnection interface {
+	Executor() SQLExecutor
+}
@@ -163,10 +163,13 @@ func (c *Cursor) Scan() error {
 	}
 
 	if !c.fetcher.Next() {
+		if err := c.fetcher.Err(); err != nil {
+			return ToError(err, "")
+		}
 		return dbflex.EOF
 	}
 
-	return c.fetcher.Scan(c.valuesPtr...)
+	return ToError(c.fetcher.Scan(c.valuesPtr...), "")
 }
 
 func (c *Cursor) Values() []interface{} {
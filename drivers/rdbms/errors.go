@@ -0,0 +1,44 @@
+package rdbms
+
+import (
+	"database/sql"
+	"strings"
+
+	"git.kanosolution.net/kano/dbflex"
+)
+
+// errorKinds map part of error message of the common databases into dbflex error kind, in order they are checked
+var errorKinds = []struct {
+	kind     error
+	messages []string
+}{
+	{dbflex.ErrLockTimeout, []string{"lock wait timeout", "lock timeout", "lock request time out"}},
+	{dbflex.ErrDuplicateKey, []string{"duplicate key", "duplicate entry", "unique constraint", "cannot insert duplicate"}},
+	{dbflex.ErrFKNotEmpty, []string{"is still referenced", "cannot delete or update a parent row", "conflicted with the reference constraint"}},
+	{dbflex.ErrFKViolation, []string{"foreign key constraint", "cannot add or update a child row"}},
+}
+
+// ToError return dbflex.Error of err that has kind of the sql error, e.g. sql.ErrNoRows is dbflex.ErrNotFound and duplicate
+// of unique key is dbflex.ErrDuplicateKey. Error of unknown kind is returned as is
+func ToError(err error, table string) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*dbflex.Error); ok {
+		return err
+	}
+
+	if err == sql.ErrNoRows {
+		return dbflex.NewError(dbflex.ErrNotFound, "", err).WithTable(table)
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, k := range errorKinds {
+		for _, m := range k.messages {
+			if strings.Contains(msg, m) {
+				return dbflex.NewError(k.kind, "", err).WithTable(table)
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package rdbms
+
+import (
+	"bytes"
+	"text/template"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/eaciit/toolkit"
+)
+
+// CommandSQL return sql of the command that is prepared by BuildCommand. FIELDS and VALUES of insert and save command
+// are filled using data of the input, data can be a record or slice of records. Save is written in dialect of the query.
+// FIELDVALUES of update command is filled using the record, or dbflex.UpdateDoc to update the fields using its operators
+func (q *Query) CommandSQL(in toolkit.M) (string, error) {
+	cmdTxt, ok := q.Config(dbflex.ConfigKeyCommand, "").(string)
+	if !ok || cmdTxt == "" {
+		return "", toolkit.Errorf("command is not prepared")
+	}
+
+	qr := q.This().(RdbmsQuery)
+	data := in.Get("data")
+	values := toolkit.M{}
+	switch q.Config(dbflex.ConfigKeyCommandType, "") {
+	case dbflex.QueryInsert:
+		fields, rows, err := InsertValues(qr, data)
+		if err != nil {
+			return "", err
+		}
+		values.Set("FIELDS", fields).Set("VALUES", rows)
+
+	case dbflex.QueryUpdate:
+		fields, _ := q.Config(dbflex.ConfigKeyFields, []string{}).([]string)
+		fieldValues, err := UpdateValues(qr, data, fields)
+		if err != nil {
+			return "", err
+		}
+		values.Set("FIELDVALUES", fieldValues)
+
+	case dbflex.QuerySave:
+		keys, _ := q.Config(dbflex.ConfigKeyKeys, []string{}).([]string)
+		saveValues, err := SaveValues(qr, dialectOf(qr), data, keys)
+		if err != nil {
+			return "", err
+		}
+		values = saveValues
+
+	default:
+		return cmdTxt, nil
+	}
+
+	var buff bytes.Buffer
+	tmp, err := template.New("main").Parse(cmdTxt)
+	if err != nil {
+		return "", toolkit.Errorf("parsing template error. %s. template: %s", err.Error(), cmdTxt)
+	}
+	if err = tmp.Execute(&buff, values); err != nil {
+		return "", toolkit.Errorf("execute template error. %s", err.Error())
+	}
+	return buff.String(), nil
+}
+
+// Execute fill the command using CommandSQL, run it using executor of the connection and return *dbflex.ExecResult.
+// Connection of the query should implement SQLConnection, error of the executor is converted using ToError
+func (q *Query) Execute(in toolkit.M) (interface{}, error) {
+	conn, ok := q.Connection().(SQLConnection)
+	if !ok {
+		return nil, toolkit.Errorf("connection of the query does not implement rdbms.SQLConnection")
+	}
+
+	cmdType, _ := q.Config(dbflex.ConfigKeyCommandType, "").(string)
+	if cmdType == dbflex.QuerySelect {
+		return nil, toolkit.Errorf("select command should use cursor instead of execute")
+	}
+
+	cmdTxt, err := q.CommandSQL(in)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := conn.Executor().Exec(cmdTxt)
+	if err != nil {
+		return nil, toolkit.Errorf("unable to execute %s. %w", cmdTxt, ToError(err, q.Config(dbflex.ConfigKeyTableName, "").(string)))
+	}
+	return NewExecResult(cmdType, res)
+}
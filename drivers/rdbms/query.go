@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -41,6 +42,7 @@ func (q *Query) Templates() map[string]string {
 			"SET {{.FIELDVALUES}} {{." + dbflex.QueryWhere + "}}",
 		dbflex.QueryDelete: "DELETE FROM {{." + dbflex.ConfigKeyTableName + "}} " +
 			"{{." + dbflex.QueryWhere + "}}",
+		dbflex.QuerySave:       SaveTemplate(dialectOf(q.This().(RdbmsQuery))),
 		dbflex.AggrMax:         "MAX({{.FIELD}})",
 		dbflex.AggrMin:         "MIN({{.FIELD}})",
 		string(dbflex.AggrSum): "SUM({{.FIELD}})",
@@ -105,6 +107,16 @@ func (q *Query) buildCommandTemplate(data toolkit.M) (string, error) {
 		data.Set("FIELDS", "{{.FIELDS}}").Set("VALUES", "{{.VALUES}}")
 	} else if cmdType == dbflex.QueryUpdate {
 		data.Set("FIELDVALUES", "{{.FIELDVALUES}}")
+	} else if cmdType == dbflex.QuerySave {
+		keyData, err := saveKeyData(q.Config(dbflex.ConfigKeyKeys, []string{}).([]string))
+		if err != nil {
+			return "", err
+		}
+		data.Set("FIELDS", "{{.FIELDS}}").Set("VALUES", "{{.VALUES}}").
+			Set("UPSERTVALUES", "{{.UPSERTVALUES}}").Set("SOURCEFIELDS", "{{.SOURCEFIELDS}}")
+		for k, v := range keyData {
+			data.Set(k, v)
+		}
 	}
 
 	var buff bytes.Buffer
@@ -167,7 +179,9 @@ func (q *Query) BuildFilter(f *dbflex.Filter) (interface{}, error) {
 		ret = f.Field + " like '" + f.Value.(string) + "%'"
 
 	case dbflex.OpEq:
-		if strings.HasPrefix(sqlfmts[0], "'") {
+		if f.Value == nil {
+			ret = f.Field + " is null"
+		} else if strings.HasPrefix(sqlfmts[0], "'") {
 			ret = f.Field + " = " + sqlfmts[0]
 		} else {
 			ret = f.Field + " = " + sqlfmts[0]
@@ -199,7 +213,9 @@ func (q *Query) BuildFilter(f *dbflex.Filter) (interface{}, error) {
 		ret = strings.Join(items, " or ")
 
 	case dbflex.OpNe:
-		if strings.HasPrefix(sqlfmts[0], "'") {
+		if f.Value == nil {
+			ret = f.Field + " is not null"
+		} else if strings.HasPrefix(sqlfmts[0], "'") {
 			ret = f.Field + " not like " + sqlfmts[0]
 		} else {
 			ret = f.Field + " != " + sqlfmts[0]
@@ -379,7 +395,8 @@ func ParseSQLMetadata(
 		for fieldIdx := 0; fieldIdx < nf; fieldIdx++ {
 			f := r.Field(fieldIdx)
 			ft := t.Field(fieldIdx)
-			if !f.CanSet() {
+			// struct that is passed by value is not addressable, so exported field is checked by its PkgPath instead of CanSet
+			if ft.PkgPath != "" {
 				continue
 			}
 			v := f.Interface()
@@ -445,3 +462,52 @@ func (qr *Query) ValueToSQlValue(v interface{}) string {
 		return toolkit.Sprintf("'%s'", CleanupSQL(fmt.Sprintf("%v", toolkit.JsonString(v))))
 	}
 }
+
+// InsertValues return FIELDS and VALUES of insert command template for a record or slice of records.
+// Slice of records is written as multi rows insert, VALUES of the rows are joined by "),(" so the template
+// becomes INSERT INTO table (a,b) VALUES (1,2),(3,4). Fields are taken from the first record and missing field is NULL
+func InsertValues(qr RdbmsQuery, data interface{}) (string, string, error) {
+	rv := reflect.Indirect(reflect.ValueOf(data))
+	if !rv.IsValid() {
+		return "", "", toolkit.Errorf("insert fail, no data")
+	}
+
+	records := []interface{}{data}
+	if rv.Kind() == reflect.Slice {
+		records = make([]interface{}, rv.Len())
+		for i := range records {
+			records[i] = rv.Index(i).Interface()
+		}
+	}
+	if len(records) == 0 {
+		return "", "", toolkit.Errorf("insert fail, no data")
+	}
+
+	fields := []string{}
+	rows := make([]string, len(records))
+	for i, record := range records {
+		names, _, _, sqlvalues := ParseSQLMetadata(qr, record)
+		if i == 0 {
+			fields = append(fields, names...)
+			if reflect.Indirect(reflect.ValueOf(record)).Kind() == reflect.Map {
+				// map keys has no order, sort them so generated command is always the same
+				sort.Strings(fields)
+			}
+		}
+
+		values := make(map[string]string, len(names))
+		for idx, name := range names {
+			values[name] = sqlvalues[idx]
+		}
+		row := make([]string, len(fields))
+		for idx, name := range fields {
+			v, ok := values[name]
+			if !ok {
+				v = "NULL"
+			}
+			row[idx] = v
+		}
+		rows[i] = strings.Join(row, ",")
+	}
+	return strings.Join(fields, ","), strings.Join(rows, "),("), nil
+}
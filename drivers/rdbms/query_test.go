@@ -0,0 +1,231 @@
+package rdbms
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/eaciit/toolkit"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testConnection struct {
+	Connection
+
+	dialect Dialect
+	sqls    []string
+	err     error
+}
+
+func newTestConnection() *testConnection {
+	c := new(testConnection)
+	c.SetThis(c)
+	return c
+}
+
+func (c *testConnection) State() string {
+	return dbflex.StateConnected
+}
+
+func (c *testConnection) NewQuery() dbflex.IQuery {
+	q := new(testQuery)
+	q.SetThis(q)
+	q.dialect = c.dialect
+	return q
+}
+
+func (c *testConnection) Executor() SQLExecutor {
+	return c
+}
+
+func (c *testConnection) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.sqls = append(c.sqls, query)
+	return testResult(2), nil
+}
+
+type testResult int64
+
+func (r testResult) LastInsertId() (int64, error) {
+	return 0, errors.New("not supported")
+}
+
+func (r testResult) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+type testQuery struct {
+	Query
+
+	dialect Dialect
+}
+
+func (q *testQuery) Dialect() Dialect {
+	if q.dialect == "" {
+		return q.Query.Dialect()
+	}
+	return q.dialect
+}
+
+func (q *testQuery) ValueToSQlValue(v interface{}) string {
+	if txt, ok := v.(string); ok {
+		return "'" + CleanupSQL(txt) + "'"
+	}
+	return q.Query.ValueToSQlValue(v)
+}
+
+func (c *testConnection) commandSQL(cmd dbflex.ICommand, data interface{}) (string, error) {
+	q, err := c.Prepare(cmd)
+	if err != nil {
+		return "", err
+	}
+	return q.(*testQuery).CommandSQL(toolkit.M{}.Set("data", data))
+}
+
+type testEmployee struct {
+	ID    string
+	Name  string
+	Grade int
+}
+
+func TestInsertSQL(t *testing.T) {
+	Convey("Insert command", t, func() {
+		conn := newTestConnection()
+
+		sql, err := conn.commandSQL(dbflex.From("employees").Insert(), testEmployee{"E1", "O'Neil", 1})
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, "INSERT INTO employees (ID,Name,Grade) VALUES ('E1','O''Neil',1)")
+
+		sql, err = conn.commandSQL(dbflex.From("employees").Insert(), []toolkit.M{
+			toolkit.M{}.Set("ID", "E1").Set("Grade", 1),
+			toolkit.M{}.Set("ID", "E2"),
+		})
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, "INSERT INTO employees (Grade,ID) VALUES (1,'E1'),(NULL,'E2')")
+
+		_, err = conn.commandSQL(dbflex.From("employees").Insert(), nil)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestExecute(t *testing.T) {
+	Convey("Execute run the command using executor of the connection", t, func() {
+		conn := newTestConnection()
+
+		res, err := conn.Execute(dbflex.From("employees").Delete().Where(dbflex.Eq("Grade", 1)), nil)
+		So(err, ShouldBeNil)
+		So(conn.sqls, ShouldResemble, []string{"DELETE FROM employees WHERE Grade = 1"})
+		execRes, ok := dbflex.ToExecResult(res)
+		So(ok, ShouldBeTrue)
+		So(execRes.RowsAffected, ShouldEqual, 2)
+		So(execRes.RowsMatched, ShouldEqual, 2)
+		So(len(execRes.InsertedIDs), ShouldEqual, 0)
+
+		_, err = conn.Execute(dbflex.From("employees").Insert(), toolkit.M{}.Set("data", testEmployee{"E1", "Ann", 1}))
+		So(err, ShouldBeNil)
+		So(conn.sqls[1], ShouldEqual, "INSERT INTO employees (ID,Name,Grade) VALUES ('E1','Ann',1)")
+
+		_, err = conn.Execute(dbflex.From("employees").Select(), nil)
+		So(err, ShouldNotBeNil)
+
+		conn.err = errors.New("connection reset")
+		_, err = conn.Execute(dbflex.From("employees").Delete(), nil)
+		So(errors.Is(err, conn.err), ShouldBeTrue)
+		So(errors.Is(err, dbflex.ErrDuplicateKey), ShouldBeFalse)
+
+		conn.err = errors.New("Error 1062: Duplicate entry 'E1' for key 'PRIMARY'")
+		_, err = conn.Execute(dbflex.From("employees").Insert(), toolkit.M{}.Set("data", testEmployee{"E1", "Ann", 1}))
+		So(errors.Is(err, dbflex.ErrDuplicateKey), ShouldBeTrue)
+		So(errors.Is(err, conn.err), ShouldBeTrue)
+		var dbErr *dbflex.Error
+		So(errors.As(err, &dbErr), ShouldBeTrue)
+		So(dbErr.Table, ShouldEqual, "employees")
+
+		conn.err = errors.New(`update or delete on table "depts" violates foreign key constraint "fk_dept" on table "employees". Key is still referenced`)
+		_, err = conn.Execute(dbflex.From("depts").Delete(), nil)
+		So(errors.Is(err, dbflex.ErrFKNotEmpty), ShouldBeTrue)
+
+		So(errors.Is(ToError(sql.ErrNoRows, "employees"), dbflex.ErrNotFound), ShouldBeTrue)
+		So(errors.Is(ToError(errors.New("Lock wait timeout exceeded; try restarting transaction"), ""), dbflex.ErrLockTimeout), ShouldBeTrue)
+	})
+}
+
+func TestSaveSQL(t *testing.T) {
+	Convey("Save command use dialect of the query", t, func() {
+		conn := newTestConnection()
+		cmd := dbflex.From("employees").Save("ID")
+		data := testEmployee{"E1", "Ann", 2}
+
+		sql, err := conn.commandSQL(cmd, data)
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, "INSERT INTO employees AS target (ID,Name,Grade) VALUES ('E1','Ann',2) "+
+			"ON CONFLICT (ID) DO UPDATE SET Name=EXCLUDED.Name,Grade=EXCLUDED.Grade")
+
+		conn.dialect = DialectMySQL
+		sql, err = conn.commandSQL(cmd, data)
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, "INSERT INTO employees (ID,Name,Grade) VALUES ('E1','Ann',2) "+
+			"ON DUPLICATE KEY UPDATE Name=VALUES(Name),Grade=VALUES(Grade)")
+
+		conn.dialect = DialectMSSQL
+		sql, err = conn.commandSQL(cmd, data)
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, "MERGE INTO employees AS target USING (VALUES ('E1','Ann',2)) AS source (ID,Name,Grade) "+
+			"ON target.ID=source.ID WHEN MATCHED THEN UPDATE SET target.Name=source.Name,target.Grade=source.Grade "+
+			"WHEN NOT MATCHED THEN INSERT (ID,Name,Grade) VALUES (source.ID,source.Name,source.Grade);")
+
+		Convey("Save of update document insert its record and apply it to existing data", func() {
+			doc := dbflex.NewUpdate().Set("ID", "E1").Inc("Grade", 1)
+
+			conn.dialect = DialectPostgres
+			sql, err := conn.commandSQL(cmd, doc)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, "INSERT INTO employees AS target (Grade,ID) VALUES (1,'E1') "+
+				"ON CONFLICT (ID) DO UPDATE SET ID='E1',Grade=COALESCE(target.Grade,0)+1")
+
+			conn.dialect = DialectMySQL
+			sql, err = conn.commandSQL(cmd, doc)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, "INSERT INTO employees (Grade,ID) VALUES (1,'E1') "+
+				"ON DUPLICATE KEY UPDATE ID='E1',Grade=COALESCE(Grade,0)+1")
+		})
+
+		Convey("Save require key", func() {
+			_, err := conn.commandSQL(dbflex.From("employees").Save(), data)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUpdateSQL(t *testing.T) {
+	Convey("Update command", t, func() {
+		conn := newTestConnection()
+		where := dbflex.Eq("ID", "E1")
+
+		sql, err := conn.commandSQL(dbflex.From("employees").Update().Where(where), testEmployee{"E1", "Ann", 2})
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, "UPDATE employees SET ID='E1',Name='Ann',Grade=2 WHERE ID = 'E1'")
+
+		sql, err = conn.commandSQL(dbflex.From("employees").Update("grade").Where(where), testEmployee{"E1", "Ann", 2})
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, "UPDATE employees SET Grade=2 WHERE ID = 'E1'")
+
+		sql, err = conn.commandSQL(dbflex.From("employees").Update().Where(where), toolkit.M{}.Set("Name", "Bob").Set("Grade", 3))
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, "UPDATE employees SET Grade=3,Name='Bob' WHERE ID = 'E1'")
+
+		doc := dbflex.NewUpdate().Inc("Grade", 1).Unset("Name").Max("Score", 10)
+		sql, err = conn.commandSQL(dbflex.From("employees").Update().Where(where), doc)
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, "UPDATE employees SET Grade=COALESCE(Grade,0)+1,Name=NULL,"+
+			"Score=CASE WHEN Score IS NULL OR 10>Score THEN 10 ELSE Score END WHERE ID = 'E1'")
+
+		_, err = conn.commandSQL(dbflex.From("employees").Update().Where(where), dbflex.NewUpdate().Push("Tags", "a"))
+		So(err, ShouldNotBeNil)
+		_, err = conn.commandSQL(dbflex.From("employees").Update("Unknown").Where(where), testEmployee{"E1", "Ann", 2})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -1,5 +1,11 @@
 package rdbms
 
+import (
+	"database/sql"
+
+	"git.kanosolution.net/kano/dbflex"
+)
+
 var timeFormat string
 
 func TimeFormat() string {
@@ -12,3 +18,21 @@ func TimeFormat() string {
 func (c *Cursor) SetTimeFormat(f string) {
 	timeFormat = f
 }
+
+// NewExecResult convert result of sql command into dbflex.ExecResult, it should be returned by Execute of the sql drivers.
+// Rows matched is the same as rows affected since it is not reported by database/sql, and inserted ID of insert command
+// is only set if the database support LastInsertId
+func NewExecResult(cmdType string, res sql.Result) (*dbflex.ExecResult, error) {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	execRes := &dbflex.ExecResult{RowsMatched: affected, RowsAffected: affected}
+	if cmdType == dbflex.QueryInsert {
+		if id, err := res.LastInsertId(); err == nil {
+			execRes.InsertedIDs = []interface{}{id}
+		}
+	}
+	return execRes, nil
+}
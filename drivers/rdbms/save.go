@@ -0,0 +1,131 @@
+package rdbms
+
+import (
+	"strings"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/eaciit/toolkit"
+)
+
+// Dialect is SQL dialect of the command that can't be written in standard SQL, e.g. Save
+type Dialect string
+
+const (
+	// DialectPostgres use INSERT ... ON CONFLICT, it is also supported by SQLite
+	DialectPostgres Dialect = "postgres"
+	// DialectMySQL use INSERT ... ON DUPLICATE KEY UPDATE, the conflict is detected using primary key and unique indexes of the table
+	DialectMySQL Dialect = "mysql"
+	// DialectMSSQL use MERGE, it is also supported by Oracle
+	DialectMSSQL Dialect = "mssql"
+)
+
+// Dialect return SQL dialect of the query, it is used by Templates and CommandSQL for Save command.
+// Driver of other dialect should override it, default is DialectPostgres
+func (q *Query) Dialect() Dialect {
+	return DialectPostgres
+}
+
+// dialectOf return dialect of the query, DialectPostgres is used if the query doesn't have Dialect method
+func dialectOf(qr RdbmsQuery) Dialect {
+	if dq, ok := qr.(interface{ Dialect() Dialect }); ok {
+		return dq.Dialect()
+	}
+	return DialectPostgres
+}
+
+// SaveTemplate return template of Save command for the dialect, Templates use it with dialect of the query.
+// FIELDS, VALUES, UPSERTVALUES and SOURCEFIELDS of the template are filled using SaveValues when the command is executed
+func SaveTemplate(d Dialect) string {
+	table := "{{." + dbflex.ConfigKeyTableName + "}}"
+	switch d {
+	case DialectMySQL:
+		return "INSERT INTO " + table + " ({{.FIELDS}}) VALUES ({{.VALUES}}) ON DUPLICATE KEY UPDATE {{.UPSERTVALUES}}"
+	case DialectMSSQL:
+		return "MERGE INTO " + table + " AS target USING (VALUES ({{.VALUES}})) AS source ({{.FIELDS}}) ON {{.KEYMATCH}} " +
+			"WHEN MATCHED THEN UPDATE SET {{.UPSERTVALUES}} " +
+			"WHEN NOT MATCHED THEN INSERT ({{.FIELDS}}) VALUES ({{.SOURCEFIELDS}});"
+	}
+	return "INSERT INTO " + table + " AS target ({{.FIELDS}}) VALUES ({{.VALUES}}) ON CONFLICT ({{.KEYS}}) DO UPDATE SET {{.UPSERTVALUES}}"
+}
+
+// saveKeyData return KEYS and KEYMATCH of Save command template, it is built when the command is prepared
+func saveKeyData(keys []string) (toolkit.M, error) {
+	if len(keys) == 0 {
+		return nil, toolkit.Errorf("save command require key fields")
+	}
+
+	matches := make([]string, len(keys))
+	for i, k := range keys {
+		matches[i] = "target." + k + "=source." + k
+	}
+	return toolkit.M{}.Set("KEYS", strings.Join(keys, ",")).Set("KEYMATCH", strings.Join(matches, " and ")), nil
+}
+
+// SaveValues return FIELDS, VALUES, UPSERTVALUES and SOURCEFIELDS of Save command template for a record or slice of records.
+// UPSERTVALUES set non key fields with the saved values, key is set to itself if all fields are keys.
+// If data is dbflex.UpdateDoc, the record it produce is inserted and UPSERTVALUES apply it to the existing data
+func SaveValues(qr RdbmsQuery, d Dialect, data interface{}, keys []string) (toolkit.M, error) {
+	doc, isDoc := dbflex.ToUpdateDoc(data)
+	if isDoc {
+		record, err := doc.Record()
+		if err != nil {
+			return nil, err
+		}
+		data = record
+	}
+
+	fieldTxt, values, err := InsertValues(qr, data)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Split(fieldTxt, ",")
+
+	isKey := map[string]bool{}
+	for _, k := range keys {
+		isKey[strings.ToLower(k)] = true
+	}
+	updated := []string{}
+	for _, f := range fields {
+		if !isKey[strings.ToLower(f)] {
+			updated = append(updated, f)
+		}
+	}
+	if len(updated) == 0 && len(keys) > 0 {
+		updated = keys[:1]
+	}
+
+	sets := make([]string, len(updated))
+	for i, f := range updated {
+		switch d {
+		case DialectMySQL:
+			sets[i] = f + "=VALUES(" + f + ")"
+		case DialectMSSQL:
+			sets[i] = "target." + f + "=source." + f
+		default:
+			sets[i] = f + "=EXCLUDED." + f
+		}
+	}
+
+	if isDoc {
+		prefix := "target."
+		if d == DialectMySQL {
+			prefix = ""
+		}
+		upsertValues, err := UpdateDocValues(qr, doc, prefix)
+		if err != nil {
+			return nil, err
+		}
+		sets = []string{upsertValues}
+	}
+
+	sources := make([]string, len(fields))
+	for i, f := range fields {
+		sources[i] = "source." + f
+	}
+
+	return toolkit.M{}.
+		Set("FIELDS", fieldTxt).
+		Set("VALUES", values).
+		Set("UPSERTVALUES", strings.Join(sets, ",")).
+		Set("SOURCEFIELDS", strings.Join(sources, ",")), nil
+}
@@ -0,0 +1,87 @@
+package rdbms
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/eaciit/toolkit"
+)
+
+// UpdateValues return FIELDVALUES of update command template for a record or update document, e.g. Name='Ann',Grade=2.
+// If fields are given, only those fields of the record are updated. Fields of update document are always its items
+func UpdateValues(qr RdbmsQuery, data interface{}, fields []string) (string, error) {
+	if doc, ok := dbflex.ToUpdateDoc(data); ok {
+		return UpdateDocValues(qr, doc, "")
+	}
+	if toolkit.IsNil(data) {
+		return "", toolkit.Errorf("update fail, no data")
+	}
+
+	names, _, _, sqlvalues := ParseSQLMetadata(qr, data)
+	values := make(map[string]string, len(names))
+	for i, name := range names {
+		values[name] = sqlvalues[i]
+	}
+	if reflect.Indirect(reflect.ValueOf(data)).Kind() == reflect.Map {
+		// map keys has no order, sort them so generated command is always the same
+		names = append([]string{}, names...)
+		sort.Strings(names)
+	}
+
+	sets := []string{}
+	for _, name := range names {
+		if len(fields) > 0 && !hasField(fields, name) {
+			continue
+		}
+		sets = append(sets, name+"="+values[name])
+	}
+	if len(sets) == 0 {
+		return "", toolkit.Errorf("update fail, no field is updated")
+	}
+	return strings.Join(sets, ","), nil
+}
+
+func hasField(fields []string, name string) bool {
+	for _, f := range fields {
+		if strings.EqualFold(f, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateDocValues return FIELDVALUES of update command template for update document, e.g. Qty=COALESCE(Qty,0)+1,Note=NULL.
+// Existing value of the field is referred with prefix, e.g. "target." for Save command. Array operators are not supported
+func UpdateDocValues(qr RdbmsQuery, doc *dbflex.UpdateDoc, prefix string) (string, error) {
+	if doc == nil || len(doc.Items) == 0 {
+		return "", toolkit.Errorf("update fail, no data")
+	}
+
+	sets := make([]string, len(doc.Items))
+	for i, item := range doc.Items {
+		field := prefix + item.Field
+		value := qr.ValueToSQlValue(item.Value)
+
+		var expr string
+		switch item.Op {
+		case dbflex.UpdateSet:
+			expr = value
+		case dbflex.UpdateUnset:
+			expr = "NULL"
+		case dbflex.UpdateInc:
+			expr = "COALESCE(" + field + ",0)+" + value
+		case dbflex.UpdateMul:
+			expr = "COALESCE(" + field + ",0)*" + value
+		case dbflex.UpdateMin:
+			expr = "CASE WHEN " + field + " IS NULL OR " + value + "<" + field + " THEN " + value + " ELSE " + field + " END"
+		case dbflex.UpdateMax:
+			expr = "CASE WHEN " + field + " IS NULL OR " + value + ">" + field + " THEN " + value + " ELSE " + field + " END"
+		default:
+			return "", toolkit.Errorf("update operator %s of %s is not supported", item.Op, item.Field)
+		}
+		sets[i] = item.Field + "=" + expr
+	}
+	return strings.Join(sets, ","), nil
+}
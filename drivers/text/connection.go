@@ -10,6 +10,8 @@ import (
 	"github.com/eaciit/toolkit"
 
 	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filecodec"
+	"git.kanosolution.net/kano/dbflex/drivers/filemeta"
 )
 
 func init() {
@@ -29,10 +31,11 @@ type Connection struct {
 	dbflex.ConnectionBase
 	sync.Mutex
 
-	dirInfo   os.FileInfo
-	dirPath   string
-	extension string
-	config    *Config
+	dirInfo     os.FileInfo
+	dirPath     string
+	extension   string
+	compression string
+	config      *Config
 }
 
 var _ dbflex.IConnection = &Connection{}
@@ -58,6 +61,10 @@ func (c *Connection) Connect() error {
 
 	c.extension = c.Config.Get("extension", "").(string)
 	c.config = c.Config.Get("text_obj_setting", NewConfig(',')).(*Config)
+	c.compression = c.Config.Get("compression", "").(string)
+	if c.compression != "" && filecodec.ByName(c.compression) == nil {
+		return toolkit.Errorf("unknown compression %s", c.compression)
+	}
 	return nil
 }
 
@@ -95,6 +102,11 @@ func (c *Connection) ObjectNames(dbflex.ObjTypeEnum) []string {
 	names := []string{}
 	for _, fi := range files {
 		name := strings.ToLower(fi.Name())
+		if filemeta.IsMetaFile(name) {
+			continue
+		}
+		// compressed table is named with codec extension after the table extension
+		name, _ = filecodec.Split(name)
 		if len(c.extension) == 0 {
 			names = append(names, name)
 		} else {
@@ -111,8 +123,50 @@ func (c *Connection) ValidateTable(interface{}, bool) error {
 	return nil
 }
 
+// tablePath return path of the table file, it could be a compressed file
+func (c *Connection) tablePath(name string) (string, error) {
+	return filecodec.TablePath(c.dirPath, name, c.extension, c.compression)
+}
+
 // DropTable remove the file of given table name
 func (c *Connection) DropTable(name string) error {
 	filepath := filepath.Join(c.dirPath, name)
-	return os.Remove(filepath)
+	if err := os.Remove(filepath); err != nil {
+		return err
+	}
+	return filemeta.DeleteTable(c.dirPath, name)
+}
+
+// EnsureTable record keys, unique indexes and columns of the table into directory metadata, so they are enforced on every write.
+// If keys is empty, fields of obj that has key tag will be used. Fields of obj that has unique tag will be added as unique index.
+// Column types are taken from field types of obj, see filemeta.NewColumns
+func (c *Connection) EnsureTable(name string, keys []string, obj interface{}) error {
+	t := filemeta.NewTable(keys, obj, c.FieldNameTag(), c.KeyNameTag())
+	t.Columns = filemeta.NewColumns(obj, c.FieldNameTag())
+	return filemeta.Update(c.dirPath, func(m *filemeta.Meta) error {
+		if existing, ok := m.Tables[strings.ToLower(name)]; ok {
+			for _, idx := range t.Indexes {
+				existing.SetIndex(idx)
+			}
+			existing.SetColumns(t.Columns...)
+			existing.Keys = t.Keys
+			return nil
+		}
+		m.Tables[strings.ToLower(name)] = t
+		return nil
+	})
+}
+
+// SetSchema add or replace typed columns of the table. Values of the columns are parsed using its type when data is read,
+// compared by its type on filter and validated when data is written using ModeStrict
+func (c *Connection) SetSchema(name string, columns ...*filemeta.Column) error {
+	return filemeta.Update(c.dirPath, func(m *filemeta.Meta) error {
+		t, ok := m.Tables[strings.ToLower(name)]
+		if !ok {
+			t = new(filemeta.Table)
+			m.Tables[strings.ToLower(name)] = t
+		}
+		t.SetColumns(columns...)
+		return nil
+	})
 }
@@ -0,0 +1,271 @@
+package text
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/eaciit/toolkit"
+)
+
+// QuoteMode decide which fields are quoted when data is written
+type QuoteMode string
+
+const (
+	// QuoteString quote all string values, this is the default
+	QuoteString QuoteMode = "string"
+	// QuoteMinimal only quote fields that contain delimiter, quote or line break as described in RFC 4180
+	QuoteMinimal QuoteMode = "minimal"
+	// QuoteAll quote all fields
+	QuoteAll QuoteMode = "all"
+)
+
+// utf8BOM is byte order mark that some applications put at the beginning of the file
+const utf8BOM = "\uFEFF"
+
+// quotes return open and close character used to quote field when data is written, it is the first of Signs
+func (t *Config) quotes() (rune, rune) {
+	if len(t.Signs) > 0 && len(t.Signs[0]) > 0 {
+		if len(t.Signs[0]) > 1 {
+			return t.Signs[0][0], t.Signs[0][1]
+		}
+		return t.Signs[0][0], t.Signs[0][0]
+	}
+	return '"', '"'
+}
+
+// closeQuote return close character if given character is an open quote
+func (t *Config) closeQuote(ch rune) (rune, bool) {
+	for _, sign := range t.Signs {
+		if len(sign) > 0 && sign[0] == ch {
+			if len(sign) > 1 {
+				return sign[1], true
+			}
+			return ch, true
+		}
+	}
+	return 0, false
+}
+
+// parseRecord split text of a record into its field values and whether each field is quoted.
+// Quote is only recognized at the beginning of a field, inside quoted field the close quote is escaped by doubling it
+// or by Config.Escape. It return false if quote of the write sign is not closed, since the record may continue on the next line.
+// If lenient is true, unclosed quote is treated as a literal character
+func parseRecord(txt string, cfg *Config, lenient bool) ([]string, []bool, bool) {
+	if !cfg.hasHeader() {
+		return parseFixed(txt, cfg)
+	}
+
+	writeQuote, _ := cfg.quotes()
+	runes := []rune(txt)
+	fields := []string{}
+	quoted := []bool{}
+
+	var buf strings.Builder
+	isQuoted := false
+	fieldStart := true
+	for i := 0; i < len(runes); {
+		ch := runes[i]
+
+		if fieldStart && cfg.UseSign {
+			if closeQuote, ok := cfg.closeQuote(ch); ok {
+				escape := cfg.Escape
+				if escape == closeQuote {
+					escape = 0
+				}
+
+				var qb strings.Builder
+				closed := false
+				j := i + 1
+				for j < len(runes) {
+					c := runes[j]
+					if escape != 0 && c == escape && j+1 < len(runes) {
+						qb.WriteRune(runes[j+1])
+						j += 2
+						continue
+					}
+					if c == closeQuote {
+						if escape == 0 && j+1 < len(runes) && runes[j+1] == closeQuote {
+							qb.WriteRune(c)
+							j += 2
+							continue
+						}
+						closed = true
+						j++
+						break
+					}
+					qb.WriteRune(c)
+					j++
+				}
+
+				if closed {
+					buf.WriteString(qb.String())
+					isQuoted = true
+					fieldStart = false
+					i = j
+					continue
+				}
+				if !lenient && ch == writeQuote {
+					return nil, nil, false
+				}
+				// quote is never closed, keep it as literal character
+			}
+		}
+
+		fieldStart = false
+		if ch == cfg.Delimeter {
+			fields = append(fields, buf.String())
+			quoted = append(quoted, isQuoted)
+			buf.Reset()
+			isQuoted = false
+			fieldStart = true
+			i++
+			continue
+		}
+
+		buf.WriteRune(ch)
+		i++
+	}
+
+	fields = append(fields, buf.String())
+	quoted = append(quoted, isQuoted)
+	return fields, quoted, true
+}
+
+// encodeField return text of a field to be written, the field is quoted according to Config.QuoteMode
+// or when it contains delimiter, line break or starts with a quote
+func encodeField(txt string, isString bool, cfg *Config) string {
+	if !cfg.UseSign || !cfg.hasHeader() {
+		return txt
+	}
+
+	open, close := cfg.quotes()
+	needQuote := cfg.QuoteMode == QuoteAll || (isString && cfg.QuoteMode != QuoteMinimal) ||
+		strings.ContainsRune(txt, cfg.Delimeter) || strings.ContainsAny(txt, "\r\n")
+	if !needQuote && txt != "" {
+		_, needQuote = cfg.closeQuote([]rune(txt)[0])
+	}
+	if !needQuote {
+		return txt
+	}
+
+	escape := cfg.Escape
+	if escape != 0 && escape != close {
+		txt = strings.Replace(txt, string(escape), string(escape)+string(escape), -1)
+		txt = strings.Replace(txt, string(close), string(escape)+string(close), -1)
+	} else {
+		txt = strings.Replace(txt, string(close), string(close)+string(close), -1)
+	}
+	return string(open) + txt + string(close)
+}
+
+// EncodeRecord encode text values into a line using the delimiter and quoting of the config, it implements dbflex.IRecordEncoder.
+// Value is only quoted if it is required or QuoteMode is QuoteAll, layout of fixed width file is not used
+func (t *Config) EncodeRecord(values []string) string {
+	cfg := *t
+	cfg.Layout = nil
+	return encodeRecord(values, false, &cfg)
+}
+
+// encodeRecord join fields into a record text
+func encodeRecord(fields []string, isString bool, cfg *Config) string {
+	txts := make([]string, len(fields))
+	for i, f := range fields {
+		txts[i] = encodeField(f, isString, cfg)
+	}
+	return strings.Join(txts, string(cfg.Delimeter))
+}
+
+// recordReader read records of a text file. Unlike bufio.Scanner it has no limit of line length,
+// and a quoted field can contain line break
+type recordReader struct {
+	r          *bufio.Reader
+	cfg        *Config
+	started    bool
+	hasBOM     bool
+	headerRead bool
+	line       int
+	raw        string
+}
+
+func newRecordReader(r io.Reader, cfg *Config) *recordReader {
+	rr := new(recordReader)
+	rr.r = bufio.NewReader(r)
+	rr.cfg = cfg
+	return rr
+}
+
+// readLine return next physical line without its line terminator, BOM of the first line is removed
+func (rr *recordReader) readLine() (string, error) {
+	line, err := rr.r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	rr.line++
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+	if !rr.started {
+		rr.started = true
+		if strings.HasPrefix(line, utf8BOM) {
+			rr.hasBOM = true
+			line = line[len(utf8BOM):]
+		}
+	}
+	return line, nil
+}
+
+// Read return field values of the next record and whether each field is quoted, empty lines are skipped.
+// For fixed width file the first record is names of the layout columns, it is not read from the file and its Raw is empty.
+// It return io.EOF if there is no more record, and io.ErrUnexpectedEOF with the line number of the record if its quote is never closed
+func (rr *recordReader) Read() ([]string, []bool, error) {
+	if !rr.cfg.hasHeader() && !rr.headerRead {
+		rr.headerRead = true
+		rr.raw = ""
+		names := rr.cfg.layoutNames()
+		return names, make([]bool, len(names)), nil
+	}
+
+	for {
+		first := !rr.started
+		line, err := rr.readLine()
+		if err != nil {
+			return nil, nil, err
+		}
+		if line == "" {
+			continue
+		}
+
+		raw := line
+		startLine := rr.line
+		for {
+			fields, quoted, complete := parseRecord(raw, rr.cfg, false)
+			if !complete {
+				next, err := rr.readLine()
+				if err == nil {
+					raw += "\n" + next
+					continue
+				}
+				if err == io.EOF {
+					return nil, nil, toolkit.Errorf("quote of record at line %d is not closed. %w", startLine, io.ErrUnexpectedEOF)
+				}
+				return nil, nil, err
+			}
+
+			if first && rr.hasBOM {
+				raw = utf8BOM + raw
+			}
+			rr.raw = raw
+			return fields, quoted, nil
+		}
+	}
+}
+
+// Raw return text of the last record as it is in the file, including BOM if it is the first record
+func (rr *recordReader) Raw() string {
+	return rr.raw
+}
+
+// HasBOM return true if the file is started with BOM
+func (rr *recordReader) HasBOM() bool {
+	return rr.hasBOM
+}
@@ -1,14 +1,13 @@
 package text
 
 import (
-	"bufio"
-	"os"
+	"io"
 	"reflect"
-	"strings"
 
 	"github.com/eaciit/toolkit"
 
 	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filecodec"
 )
 
 // Cursor responsible for converting the text data to given buffer
@@ -16,10 +15,13 @@ import (
 type Cursor struct {
 	dbflex.CursorBase
 
-	f                 *os.File
+	f                 io.ReadCloser
 	filePath          string
-	scanner           *bufio.Scanner
+	reader            *recordReader
+	header            []string
+	fetched           int
 	textObjectSetting *Config
+	schema            *schema
 	filter            *dbflex.Filter
 	extra             dbflex.QueryItems
 }
@@ -58,14 +60,6 @@ func (c *Cursor) Fetch(out interface{}) dbflex.ICursor {
 
 // Fetchs multiple data and require slice as buffer
 func (c *Cursor) Fetchs(result interface{}, n int) dbflex.ICursor {
-	if c.scanner == nil {
-		c.openFile()
-	}
-
-	if c.Error() != nil {
-		return c
-	}
-
 	// Check if there is aggragation and groupby command
 	aggrs, hasAggr := c.extra[dbflex.QueryAggr]
 	groupby, hasGroup := c.extra[dbflex.QueryGroup]
@@ -73,42 +67,70 @@ func (c *Cursor) Fetchs(result interface{}, n int) dbflex.ICursor {
 	skip := 0
 	take := n
 
+	// Sorted and aggregated data need all records, so the file is read again from the beginning
+	if (hasSort || hasAggr || hasGroup) && c.fetched > 0 {
+		c.Close()
+	}
+
+	if c.reader == nil {
+		c.openFile()
+	}
+
+	if c.Error() != nil {
+		return c
+	}
+
 	if items, ok := c.extra[dbflex.QuerySkip]; ok {
 		skip = items.Value.(int)
 	}
 
+	v := reflect.TypeOf(result).Elem().Elem()
+	// Create empty slice of buffer element type
+	ivs := reflect.MakeSlice(reflect.SliceOf(v), 0, 0)
+
 	if items, ok := c.extra[dbflex.QueryTake]; ok {
-		take = items.Value.(int)
+		// Take is shared by all fetchs of the cursor
+		limit := items.Value.(int) - c.fetched
+		if limit <= 0 {
+			reflect.Indirect(reflect.ValueOf(result)).Set(ivs)
+			return c
+		}
+		if take <= 0 || take > limit {
+			take = limit
+		}
 	}
 
 	loop := true
-	header := []string{}
-	read := -1
+	read := 0
 	shouldFetched := 0
 
 	if !hasSort && !hasAggr && !hasGroup {
-		read -= skip
+		// The reader continue from the previous fetchs, so skip is only applied once
+		if c.fetched == 0 {
+			read -= skip
+		}
 		shouldFetched = take
 	}
 
-	v := reflect.TypeOf(result).Elem().Elem()
-	// Create empty slice of buffer element type
-	ivs := reflect.MakeSlice(reflect.SliceOf(v), 0, 0)
+	for loop {
+		values, _, err := c.reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			c.SetError(toolkit.Errorf("unable to read file %s. %s", c.filePath, err.Error()))
+			return c
+		}
 
-	for c.scanner.Scan() && loop {
 		// Don't fetch header
-		if read < 0 && len(header) == 0 {
-			// If the first line and there is no header saved yet
+		if c.header == nil {
+			// If the first record and there is no header saved yet
 			// Read it as header
-			header = strings.Split(c.scanner.Text(), string(c.textObjectSetting.Delimeter))
-
-			read++
+			c.header = values
 			continue
 		}
 
-		data := c.scanner.Text()
 		// Check if the data is match with given filter
-		ok, err := isIncluded(strings.Split(data, string(c.textObjectSetting.Delimeter)), header, c.filter)
+		ok, err := isIncluded(values, c.header, c.filter, c.schema)
 		if err != nil {
 			c.SetError(err)
 			return c
@@ -119,13 +141,13 @@ func (c *Cursor) Fetchs(result interface{}, n int) dbflex.ICursor {
 			read++
 
 			// Check if we shoul put it in the fetched data
-			// Because of skip function we need to make sure that read is above or equal with 0
-			if read >= 0 {
+			// Because of skip function we need to make sure that read is above 0
+			if read > 0 {
 				// If match then convert text to the type of given buffer element
 				iv := reflect.New(v).Interface()
-				err = textToObj(data, iv, c.textObjectSetting, header...)
+				err = fieldsToObj(values, iv, c.textObjectSetting, c.schema, c.header...)
 				if err != nil {
-					err = toolkit.Errorf("unable to serialize data. %s - %s", data, err.Error())
+					err = toolkit.Errorf("unable to serialize data. %s - %s", c.reader.Raw(), err.Error())
 					c.SetError(err)
 					return c
 				}
@@ -223,13 +245,17 @@ func (c *Cursor) Fetchs(result interface{}, n int) dbflex.ICursor {
 		}
 	}
 
-	if (hasSort || hasAggr || hasGroup) && (skip != 0 || take != 0) {
+	if (hasSort || hasAggr || hasGroup) && (skip != 0 || take != 0 || c.fetched != 0) {
 		rv := reflect.Indirect(reflect.ValueOf(result))
 
-		start := skip
-		end := take + skip
+		// Records of the previous fetchs are skipped
+		start := skip + c.fetched
+		end := take + start
 
-		if take+skip > rv.Len() {
+		if start > rv.Len() {
+			start = rv.Len()
+		}
+		if take <= 0 || end > rv.Len() {
 			end = rv.Len()
 		}
 
@@ -237,43 +263,41 @@ func (c *Cursor) Fetchs(result interface{}, n int) dbflex.ICursor {
 		rv.Set(ivs)
 	}
 
+	c.fetched += reflect.Indirect(reflect.ValueOf(result)).Len()
 	return c
 }
 
 // Count return count of data with give filter
 // BUG: Only filter that applied in this function, group by is not yet implemented
 func (c *Cursor) Count() int {
-	// Start from -1 because of file header
-	if c.scanner == nil {
-		c.openFile()
+	// Read the file separately, so it doesn't move the fetch position
+	f, err := filecodec.Open(c.filePath)
+	if err != nil {
+		c.SetError(err)
+		return 0
 	}
+	defer f.Close()
 
-	// Reset file pointer
-	c.f.Seek(0, 0)
-
-	header := []string{}
-	read := -1
-
-	for c.scanner.Scan() {
-		// Don't fetch header
-		if read < 0 {
-			tempHeader := strings.Split(c.scanner.Text(), string(c.textObjectSetting.Delimeter))
-			for _, v := range tempHeader {
-				header = append(header, strings.Trim(v, "\""))
-			}
+	reader := newRecordReader(f, c.textObjectSetting)
+	header, _, err := reader.Read()
+	if err != nil {
+		return 0
+	}
 
-			read++
-			continue
+	count := 0
+	for {
+		values, _, err := reader.Read()
+		if err != nil {
+			break
 		}
 
-		data := c.scanner.Text()
-		ok, _ := isIncluded(strings.Split(data, string(c.textObjectSetting.Delimeter)), header, c.filter)
+		ok, _ := isIncluded(values, header, c.filter, c.schema)
 		if ok {
-			read++
+			count++
 		}
 	}
 
-	return read
+	return count
 }
 
 // Close the current file
@@ -283,20 +307,21 @@ func (c *Cursor) Close() error {
 		c.f.Close()
 
 		c.f = nil
-		c.scanner = nil
+		c.reader = nil
+		c.header = nil
 	}
 	return e
 }
 
 func (c *Cursor) openFile() {
 	c.SetError(nil)
-	f, err := os.Open(c.filePath)
+	// compressed file is decompressed while it is read
+	f, err := filecodec.Open(c.filePath)
 	if err != nil {
 		c.SetError(err)
 		return
 	}
 
-	scanner := bufio.NewScanner(f)
 	c.f = f
-	c.scanner = scanner
+	c.reader = newRecordReader(f, c.textObjectSetting)
 }
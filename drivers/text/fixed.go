@@ -0,0 +1,145 @@
+package text
+
+import (
+	"strings"
+
+	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filemeta"
+	"github.com/eaciit/toolkit"
+)
+
+// Alignment is position of value inside fixed width column
+type Alignment string
+
+const (
+	// AlignLeft put value at the beginning of the column and pad the rest, this is the default
+	AlignLeft Alignment = "left"
+	// AlignRight put value at the end of the column and pad the beginning, usually used for numbers
+	AlignRight Alignment = "right"
+)
+
+// FixedColumn is a column of fixed width text file
+type FixedColumn struct {
+	Name string
+	// Start is zero based position of the first character of the column
+	Start  int
+	Length int
+	// Type is optional, if it is set the value is parsed and compared using the type
+	Type filemeta.ColumnType
+	// Pad is character used to fill the rest of the column, default is space
+	Pad   rune
+	Align Alignment
+}
+
+func (fc *FixedColumn) pad() rune {
+	if fc.Pad == 0 {
+		return ' '
+	}
+	return fc.Pad
+}
+
+// SetLayout set columns of fixed width file, Delimeter and signs are not used if layout is set.
+// Fixed width file doesn't have header line, the header is names of the columns
+func (t *Config) SetLayout(cols ...*FixedColumn) *Config {
+	t.Layout = cols
+	return t
+}
+
+// hasHeader return false for fixed width file
+func (t *Config) hasHeader() bool {
+	return len(t.Layout) == 0
+}
+
+// layoutNames return names of fixed width columns
+func (t *Config) layoutNames() []string {
+	names := make([]string, len(t.Layout))
+	for i, fc := range t.Layout {
+		names[i] = fc.Name
+	}
+	return names
+}
+
+// parseFixed split a line of fixed width file into values of the layout columns, padding of the values is removed
+func parseFixed(txt string, cfg *Config) ([]string, []bool, bool) {
+	runes := []rune(txt)
+	values := make([]string, len(cfg.Layout))
+	for i, fc := range cfg.Layout {
+		start, end := fc.Start, fc.Start+fc.Length
+		if start > len(runes) {
+			start = len(runes)
+		}
+		if end > len(runes) {
+			end = len(runes)
+		}
+
+		raw := string(runes[start:end])
+		pad := string(fc.pad())
+		value := ""
+		if fc.Align == AlignRight {
+			value = strings.TrimLeft(raw, pad)
+			// number that is padded with zero
+			if value == "" && raw != "" && pad == "0" {
+				value = "0"
+			}
+		} else {
+			value = strings.TrimRight(raw, pad)
+		}
+		if pad != " " {
+			value = strings.TrimSpace(value)
+		}
+		values[i] = value
+	}
+	return values, make([]bool, len(values)), true
+}
+
+// joinFixed put texts into their column position, text is matched with the layout column using header.
+// It return dbflex.ErrConstraint error if a text is longer than its column
+func joinFixed(header, txts []string, cfg *Config) (string, error) {
+	width := 0
+	for _, fc := range cfg.Layout {
+		if fc.Start+fc.Length > width {
+			width = fc.Start + fc.Length
+		}
+	}
+
+	line := []rune(strings.Repeat(" ", width))
+	for i, name := range header {
+		if i >= len(txts) {
+			break
+		}
+
+		var fc *FixedColumn
+		for _, c := range cfg.Layout {
+			if strings.EqualFold(c.Name, name) {
+				fc = c
+				break
+			}
+		}
+		if fc == nil {
+			continue
+		}
+
+		txt := []rune(txts[i])
+		if len(txt) > fc.Length {
+			return "", dbflex.NewError(dbflex.ErrConstraint,
+				toolkit.Sprintf("value of %s is longer than %d characters: %s", fc.Name, fc.Length, txts[i]), nil).WithField(fc.Name)
+		}
+
+		padding := []rune(strings.Repeat(string(fc.pad()), fc.Length-len(txt)))
+		if fc.Align == AlignRight {
+			txt = append(padding, txt...)
+		} else {
+			txt = append(txt, padding...)
+		}
+		copy(line[fc.Start:], txt)
+	}
+	return string(line), nil
+}
+
+// joinFields join texts of a record using the delimiter, or put them into their column position for fixed width file
+func joinFields(header, txts []string, cfg *Config) (string, error) {
+	if !cfg.hasHeader() {
+		return joinFixed(header, txts, cfg)
+	}
+	return strings.Join(txts, string(cfg.Delimeter)), nil
+}
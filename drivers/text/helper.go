@@ -3,6 +3,8 @@ package text
 import (
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"reflect"
 	"sort"
 	"strconv"
@@ -10,6 +12,7 @@ import (
 	"time"
 
 	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filemeta"
 
 	"github.com/eaciit/toolkit"
 )
@@ -35,6 +38,16 @@ type Config struct {
 	Signs       [][]rune
 	DateFormats map[string]string
 	WriteMode   WriteMode
+
+	// QuoteMode decide which fields are quoted on write, default is QuoteString
+	QuoteMode QuoteMode
+	// Escape is used to escape quote inside quoted field, if it is empty the quote is escaped by doubling it
+	Escape rune
+	// WriteBOM write UTF-8 byte order mark when new file is created
+	WriteBOM bool
+
+	// Layout is columns of fixed width file, see SetLayout
+	Layout []*FixedColumn
 }
 
 // NewConfig create new TextObjectSetting with default value and given delimiter
@@ -63,6 +76,24 @@ func (t *Config) SetSign(runes ...rune) *Config {
 	return t
 }
 
+// SetQuoteMode set which fields are quoted on write
+func (t *Config) SetQuoteMode(mode QuoteMode) *Config {
+	t.QuoteMode = mode
+	return t
+}
+
+// SetEscape set escape character of quote
+func (t *Config) SetEscape(escape rune) *Config {
+	t.Escape = escape
+	return t
+}
+
+// SetWriteBOM set whether new file is started with byte order mark
+func (t *Config) SetWriteBOM(b bool) *Config {
+	t.WriteBOM = b
+	return t
+}
+
 // SetDateFormat set a date format for the give key
 func (t *Config) SetDateFormat(key, value string) *Config {
 	if t.DateFormats == nil {
@@ -91,6 +122,13 @@ func (t *Config) DateFormat(key string) string {
 }
 
 func textToObj(txt string, out interface{}, cfg *Config, headers ...string) error {
+	values, _, _ := parseRecord(txt, cfg, true)
+	return fieldsToObj(values, out, cfg, nil, headers...)
+}
+
+// fieldsToObj set field values of a record to out, if headers is empty and out is a struct, field names of the struct are used as headers.
+// Value of column that is defined on the schema is parsed using its type
+func fieldsToObj(values []string, out interface{}, cfg *Config, sch *schema, headers ...string) error {
 	vt := reflect.Indirect(reflect.ValueOf(out)).Type()
 	if len(headers) == 0 && vt.Kind() == reflect.Struct {
 		for i := 0; i < vt.NumField(); i++ {
@@ -99,92 +137,38 @@ func textToObj(txt string, out interface{}, cfg *Config, headers ...string) erro
 		}
 	}
 
-	var closeQuote rune
-	var processBufferToObj bool
-
-	inQuote := false
-	txtBuff := ""
-	idx := 0
-
-	i := 0
-	runesTxt := []rune(txt)
-	startQuotePos := -1
-	bufferQuotePos := -1
-
-	for i < len(runesTxt) {
-		char := runesTxt[i]
-		addRune := true
-		processBufferToObj = false
-		if cfg.UseSign {
-			if !inQuote {
-				for _, sign := range cfg.Signs {
-					if len(sign) > 0 {
-						if char == sign[0] {
-							inQuote = true
-							startQuotePos = i
-							bufferQuotePos = len(txtBuff)
-							if len(sign) == 1 {
-								closeQuote = char
-							} else {
-								closeQuote = sign[1]
-							}
-
-							addRune = false
-							break
-						}
-					}
-				}
-			} else if char == closeQuote {
-				inQuote = false
-				addRune = false
-			}
-		}
-
-		if char == cfg.Delimeter && !inQuote {
-			addRune = false
-			processBufferToObj = true
-		}
-
-		if addRune {
-			txtBuff += string(char)
-		}
-
-		// Close quote is missing
-		if i == len(runesTxt)-1 && inQuote && char != closeQuote {
-			inQuote = false
-			i = startQuotePos
-			txtBuff = txtBuff[:bufferQuotePos] + string(closeQuote)
-		}
-
-		if processBufferToObj {
-			fieldname := ""
-			if idx < len(headers) {
-				fieldname = headers[idx]
-			} else {
-				fieldname = toolkit.ToString(idx)
-			}
-			processTxtToObjField(txtBuff, out, fieldname, cfg)
-			txtBuff = ""
-			idx++
-		}
-
-		i++
-	}
-
-	//-- process buff if last operation doesnt does it
-	if !processBufferToObj {
+	for idx, value := range values {
 		fieldname := ""
 		if idx < len(headers) {
 			fieldname = headers[idx]
 		} else {
 			fieldname = toolkit.ToString(idx)
 		}
-		processTxtToObjField(txtBuff, out, fieldname, cfg)
+
+		if col := sch.column(fieldname); col != nil && isMapOutput(out) {
+			v, err := sch.parse(col, value)
+			if err != nil {
+				return err
+			}
+			if err = setMapField(out, fieldname, v); err != nil {
+				return err
+			}
+			continue
+		}
+		processTxtToObjField(value, out, fieldname, sch.config(cfg))
 	}
 
 	return nil
 }
 
+func isMapOutput(out interface{}) bool {
+	rt := reflect.Indirect(reflect.ValueOf(out)).Type()
+	if rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	return rt.Kind() == reflect.Map
+}
+
 func processTxtToObjField(txt string, obj interface{}, fieldname string, cfg *Config) error {
 	rv := reflect.Indirect(reflect.ValueOf(obj))
 	rt := rv.Type()
@@ -221,28 +205,7 @@ func processTxtToObjField(txt string, obj interface{}, fieldname string, cfg *Co
 				}
 			}
 
-			if rv.IsNil() {
-				rv.Set(reflect.MakeMap(rt))
-			}
-
-			subFieldNames := strings.Split(fieldname, ".")
-
-			for i, subFieldName := range subFieldNames {
-				if i < len(subFieldNames)-1 {
-					index := reflect.ValueOf(subFieldName)
-					newRv := rv.MapIndex(index)
-
-					if newRv.IsValid() {
-						rv = reflect.ValueOf(newRv.Interface())
-					} else {
-						rv.SetMapIndex(index, reflect.MakeMap(rt))
-						rv = reflect.ValueOf(rv.MapIndex(index).Interface())
-					}
-				} else {
-					rv.SetMapIndex(reflect.ValueOf(subFieldName), reflect.ValueOf(objField))
-				}
-			}
-
+			setMapValue(rv, fieldname, objField)
 		} else {
 			return errors.New("output type is a map and need to have string as its key")
 		}
@@ -293,6 +256,53 @@ func processTxtToObjField(txt string, obj interface{}, fieldname string, cfg *Co
 	return nil
 }
 
+// setMapField set value of a map output, dot separated field name is set as nested map
+func setMapField(obj interface{}, fieldname string, value interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(obj))
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Type().Key().Kind() != reflect.String {
+		return errors.New("output type is a map and need to have string as its key")
+	}
+	setMapValue(rv, fieldname, value)
+	return nil
+}
+
+func setMapValue(rv reflect.Value, fieldname string, value interface{}) {
+	rt := rv.Type()
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(rt))
+	}
+
+	subFieldNames := strings.Split(fieldname, ".")
+
+	for i, subFieldName := range subFieldNames {
+		if i < len(subFieldNames)-1 {
+			index := reflect.ValueOf(subFieldName)
+			newRv := rv.MapIndex(index)
+
+			if newRv.IsValid() {
+				rv = reflect.ValueOf(newRv.Interface())
+			} else {
+				rv.SetMapIndex(index, reflect.MakeMap(rt))
+				rv = reflect.ValueOf(rv.MapIndex(index).Interface())
+			}
+		} else {
+			mv := reflect.ValueOf(value)
+			if !mv.IsValid() {
+				// nil value of typed column
+				mv = reflect.Zero(rt.Elem())
+			}
+			rv.SetMapIndex(reflect.ValueOf(subFieldName), mv)
+		}
+	}
+}
+
 func textToInterface(txt string, receiverType reflect.Type, dateFormat string) interface{} {
 	typeName := receiverType.Name()
 	var objField interface{}
@@ -337,6 +347,11 @@ func objToM(data interface{}, parents ...string) (toolkit.M, error) {
 			// Check of there is a sql tag for this field
 			tag, ok := f.Tag.Lookup("sql")
 
+			// Unexported field and field that is ignored by sql tag are not part of the data
+			if f.PkgPath != "" || tag == "-" {
+				continue
+			}
+
 			// If the type is struct but not time.Time or is a map
 			if (f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Time{})) || f.Type.Kind() == reflect.Map {
 				// Then we need to call this function again to fetch the sub value
@@ -378,6 +393,12 @@ func objToM(data interface{}, parents ...string) (toolkit.M, error) {
 		// If the data element is kind of map
 		// Iterate through all avilable keys
 		for _, key := range rv.MapKeys() {
+			// Nil value doesn't have type, put it in the result directly
+			if !rv.MapIndex(key).Elem().IsValid() {
+				res[prefix+key.String()] = nil
+				continue
+			}
+
 			// Get the map value type of the specified key
 			t := rv.MapIndex(key).Elem().Type()
 			// If the type is struct but not time.Time or is a map
@@ -428,35 +449,32 @@ func objToText(data interface{}, header []string, cfg *Config) (string, error) {
 		}
 
 		rvfield := reflect.Indirect(reflect.ValueOf(m[name]))
-		fieldName := name
-
-		txt := ""
 		kind := rvfield.Kind()
-
-		if rvfield.IsValid() {
-			if val, ok := rvfield.Interface().(time.Time); ok {
-				txt = toolkit.Date2String(val, cfg.DateFormat(fieldName))
-			} else if kind == reflect.Struct || kind == reflect.Map || kind == reflect.Interface {
-				txt, err = objToText(rvfield.Interface(), header, cfg)
+		if rvfield.IsValid() && (kind == reflect.Struct || kind == reflect.Map || kind == reflect.Interface) {
+			if _, ok := rvfield.Interface().(time.Time); !ok {
+				txt, err := objToText(rvfield.Interface(), header, cfg)
 				if err != nil {
 					return "", err
 				}
-			} else if kind == reflect.Int || kind == reflect.Int16 || kind == reflect.Int32 || kind == reflect.Int64 || kind == reflect.Int8 {
-				txt = fmt.Sprintf("%d", rvfield.Int())
-			} else if kind == reflect.Float32 || kind == reflect.Float64 {
-				txt = fmt.Sprintf("%f", rvfield.Float())
-			} else if kind == reflect.String {
-				txt = fmt.Sprintf("\"%s\"", rvfield.String())
+				txts = append(txts, txt)
+				continue
 			}
 		}
 
-		txts = append(txts, txt)
+		txts = append(txts, interfaceToText(m[name], name, cfg))
 	}
 
-	return strings.Join(txts, string(cfg.Delimeter)), nil
+	return joinFields(header, txts, cfg)
 }
 
+// interfaceToText return text of a value to be written to the file, quoted according to the config
 func interfaceToText(data interface{}, fieldName string, cfg *Config) string {
+	txt, isString := valueToText(data, fieldName, cfg)
+	return encodeField(txt, isString, cfg)
+}
+
+// valueToText return unquoted text of a value and whether it is a string
+func valueToText(data interface{}, fieldName string, cfg *Config) (string, bool) {
 	rvfield := reflect.Indirect(reflect.ValueOf(data))
 
 	txt := ""
@@ -467,14 +485,19 @@ func interfaceToText(data interface{}, fieldName string, cfg *Config) string {
 			txt = toolkit.Date2String(val, cfg.DateFormat(fieldName))
 		} else if kind == reflect.Int || kind == reflect.Int16 || kind == reflect.Int32 || kind == reflect.Int64 || kind == reflect.Int8 {
 			txt = fmt.Sprintf("%d", rvfield.Int())
+		} else if (kind == reflect.Float32 || kind == reflect.Float64) && !cfg.hasHeader() {
+			// fixed width column is usually narrow, use the shortest text
+			txt = strconv.FormatFloat(rvfield.Float(), 'f', -1, 64)
 		} else if kind == reflect.Float32 || kind == reflect.Float64 {
 			txt = fmt.Sprintf("%f", rvfield.Float())
+		} else if kind == reflect.Bool {
+			txt = strconv.FormatBool(rvfield.Bool())
 		} else if kind == reflect.String {
-			txt = fmt.Sprintf("\"%s\"", rvfield.String())
+			return rvfield.String(), true
 		}
 	}
 
-	return txt
+	return txt, false
 }
 
 func objHeader(data interface{}) []string {
@@ -483,8 +506,9 @@ func objHeader(data interface{}) []string {
 }
 
 // isIncluded return true if the data is match with the given filter, if not then return false.
-// If there is an error while checking the data, then it return false, and the error
-func isIncluded(data []string, header []string, f *dbflex.Filter) (bool, error) {
+// If there is an error while checking the data, then it return false, and the error.
+// Field that is defined on the schema is compared using its type
+func isIncluded(data []string, header []string, f *dbflex.Filter, sch *schema) (bool, error) {
 	// if the filter is nil, we assume that the caller want to show all the data
 	// So we return true
 	if f == nil {
@@ -502,26 +526,36 @@ func isIncluded(data []string, header []string, f *dbflex.Filter) (bool, error)
 
 	// If the field is not found and filter operatrion is not AND, OR, RANGE return error
 	if i < 0 && f.Op != dbflex.OpAnd && f.Op != dbflex.OpOr && f.Op != dbflex.OpRange && f.Op != dbflex.OpNot {
-		return false, toolkit.Errorf("Field with name %s is not exist in the table", f.Field)
+		return false, dbflex.NewError(dbflex.ErrUnknownField, toolkit.Sprintf("Field with name %s is not exist in the table", f.Field), nil).WithField(f.Field)
 	}
 
 	// Get the data value if field name is found
 	dataValue := ""
 	if i >= 0 {
-		dataValue = strings.Trim(data[i], "\"")
+		if i < len(data) {
+			dataValue = strings.Trim(data[i], "\"")
+		}
+
+		if match, ok, err := sch.match(dataValue, f); ok || err != nil {
+			return match, err
+		}
 	}
 
-	// Check the field operation and do operation accordingly
+	// Check the field operation and do operation accordingly, nil value is written as empty text
+	filterValue := fmt.Sprint(f.Value)
+	if f.Value == nil {
+		filterValue = ""
+	}
 	if f.Op == dbflex.OpEq {
-		if dataValue != fmt.Sprint(f.Value) {
+		if dataValue != filterValue {
 			return false, nil
 		}
 	} else if f.Op == dbflex.OpNe {
-		if dataValue == fmt.Sprint(f.Value) {
+		if dataValue == filterValue {
 			return false, nil
 		}
 	} else if f.Op == dbflex.OpContains {
-		keywords := f.Value.([]string)
+		keywords := filterValues(f.Value)
 		match := false
 		for _, keyword := range keywords {
 			if strings.Contains(strings.ToLower(dataValue), strings.ToLower(keyword)) {
@@ -536,7 +570,7 @@ func isIncluded(data []string, header []string, f *dbflex.Filter) (bool, error)
 	} else if f.Op == dbflex.OpEndWith {
 		return strings.HasSuffix(dataValue, fmt.Sprint(f.Value)), nil
 	} else if f.Op == dbflex.OpIn {
-		keywords := f.Value.([]string)
+		keywords := filterValues(f.Value)
 		match := false
 		for _, keyword := range keywords {
 			if strings.ToLower(dataValue) == strings.ToLower(keyword) {
@@ -547,7 +581,7 @@ func isIncluded(data []string, header []string, f *dbflex.Filter) (bool, error)
 
 		return match, nil
 	} else if f.Op == dbflex.OpNin {
-		keywords := f.Value.([]string)
+		keywords := filterValues(f.Value)
 		match := true
 		for _, keyword := range keywords {
 			if strings.ToLower(dataValue) == strings.ToLower(keyword) {
@@ -608,7 +642,7 @@ func isIncluded(data []string, header []string, f *dbflex.Filter) (bool, error)
 	} else if f.Op == dbflex.OpRange {
 		// If filter operation is RANGE that means value should be slice with first value is the lowest and second value is the highest
 		// Check if the data is GTE than first filter value
-		firstResult, err := isIncluded(data, header, dbflex.Gte(f.Field, f.Value.([]interface{})[0]))
+		firstResult, err := isIncluded(data, header, dbflex.Gte(f.Field, f.Value.([]interface{})[0]), sch)
 		if err != nil {
 			return false, err
 		}
@@ -619,7 +653,7 @@ func isIncluded(data []string, header []string, f *dbflex.Filter) (bool, error)
 		}
 
 		// Check if the data is LTE than second filter value
-		secondResult, err := isIncluded(data, header, dbflex.Lte(f.Field, f.Value.([]interface{})[1]))
+		secondResult, err := isIncluded(data, header, dbflex.Lte(f.Field, f.Value.([]interface{})[1]), sch)
 		if err != nil {
 			return false, err
 		}
@@ -634,7 +668,7 @@ func isIncluded(data []string, header []string, f *dbflex.Filter) (bool, error)
 		// Iterate through all filter items
 		for _, ff := range fs {
 			// Get the result for each filter item
-			r, err := isIncluded(data, header, ff)
+			r, err := isIncluded(data, header, ff, sch)
 			if err != nil {
 				return false, err
 			}
@@ -657,7 +691,7 @@ func isIncluded(data []string, header []string, f *dbflex.Filter) (bool, error)
 		// Iterate through all filter items
 		for _, ff := range fs {
 			// Get the result for each filter item
-			r, err := isIncluded(data, header, ff)
+			r, err := isIncluded(data, header, ff, sch)
 			if err != nil {
 				return false, err
 			}
@@ -673,7 +707,7 @@ func isIncluded(data []string, header []string, f *dbflex.Filter) (bool, error)
 
 		return match, nil
 	} else if f.Op == dbflex.OpNot {
-		r, err := isIncluded(data, header, f.Items[0])
+		r, err := isIncluded(data, header, f.Items[0], sch)
 		if err != nil {
 			return false, err
 		}
@@ -806,6 +840,9 @@ func aggregate(data interface{}, aggrItems []*dbflex.AggrItem, groups ...string)
 		if v1.Kind() == reflect.Struct {
 			// Then get the type of aggregated field
 			kind = v1.FieldByName(name).Kind()
+		} else if mv := v1.MapIndex(reflect.ValueOf(name)); mv.IsValid() && reflect.ValueOf(mv.Interface()).Kind() == reflect.Int {
+			// Integer column of the table schema
+			kind = reflect.Int
 		}
 
 		switch kind {
@@ -1040,6 +1077,13 @@ func byField(data interface{}, field string) error {
 
 	if f1.Type().Kind() == reflect.Interface {
 		f1 = reflect.ValueOf(f1.Interface())
+		// Null value of typed column, find the type from the other data
+		for i := 1; !f1.IsValid() && i < v.Len(); i++ {
+			f1 = reflect.ValueOf(reflect.Indirect(v.Index(i)).MapIndex(reflect.ValueOf(fieldName)).Interface())
+		}
+		if !f1.IsValid() {
+			return nil
+		}
 	}
 
 	var helper sort.Interface
@@ -1128,7 +1172,9 @@ func makeIntKeys(v reflect.Value, fieldName string, len int) []int64 {
 			fi = reflect.ValueOf(fi.Interface())
 		}
 
-		keys[i] = fi.Int()
+		if fi.IsValid() {
+			keys[i] = fi.Int()
+		}
 	}
 	return keys
 }
@@ -1164,7 +1210,9 @@ func makeStringKeys(v reflect.Value, fieldName string, len int) []string {
 			fi = reflect.ValueOf(fi.Interface())
 		}
 
-		keys[i] = fi.String()
+		if fi.IsValid() {
+			keys[i] = fi.String()
+		}
 	}
 	return keys
 }
@@ -1200,7 +1248,9 @@ func makeFloatKeys(v reflect.Value, fieldName string, len int) []float64 {
 			fi = reflect.ValueOf(fi.Interface())
 		}
 
-		keys[i] = fi.Float()
+		if fi.IsValid() {
+			keys[i] = fi.Float()
+		}
 	}
 	return keys
 }
@@ -1235,7 +1285,170 @@ func makeTimeKeys(v reflect.Value, fieldName string, len int) []time.Time {
 			fi = vi.MapIndex(reflect.ValueOf(fieldName))
 		}
 
-		keys[i] = fi.Interface().(time.Time)
+		if t, ok := fi.Interface().(time.Time); ok {
+			keys[i] = t
+		}
 	}
 	return keys
 }
+
+// filterValues return values of Contains, In and Nin filter as slice of string
+func filterValues(value interface{}) []string {
+	switch vs := value.(type) {
+	case []string:
+		return vs
+	case []interface{}:
+		res := make([]string, len(vs))
+		for i, v := range vs {
+			res[i] = fmt.Sprint(v)
+		}
+		return res
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// checkUniqueFields add field values of a record to the unique checker, values are matched with header case insensitively
+func checkUniqueFields(checker *filemeta.UniqueChecker, header []string, values []string) error {
+	return checker.Check(func(field string) (string, bool) {
+		for i, h := range header {
+			if strings.EqualFold(h, field) && i < len(values) {
+				return values[i], true
+			}
+		}
+		return "", false
+	})
+}
+
+// newUniqueChecker create unique checker of the table and add all existing data of the file to it.
+// File offset is moved back to the beginning of the file after it
+func newUniqueChecker(tableName string, meta *filemeta.Table, file *os.File, cfg *Config) (*filemeta.UniqueChecker, error) {
+	checker := filemeta.NewUniqueChecker(tableName, meta)
+	if checker == nil {
+		return nil, nil
+	}
+
+	file.Seek(0, 0)
+	defer file.Seek(0, 0)
+
+	reader := newRecordReader(file, cfg)
+	header, _, err := reader.Read()
+	if err == io.EOF {
+		return checker, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for {
+		values, _, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if err = checkUniqueFields(checker, header, values); err != nil {
+			return nil, err
+		}
+	}
+	return checker, nil
+}
+
+// insertResult return result of inserting data, data is a record or slice of records. Key of the record is taken from
+// the table keys, _id is used if table has no declared keys
+func insertResult(meta *filemeta.Table, data interface{}) *dbflex.ExecResult {
+	records := []interface{}{data}
+	if rv := reflect.ValueOf(data); rv.Kind() == reflect.Slice {
+		records = make([]interface{}, rv.Len())
+		for i := range records {
+			records[i] = rv.Index(i).Interface()
+		}
+	}
+
+	res := &dbflex.ExecResult{RowsAffected: int64(len(records)), InsertedIDs: make([]interface{}, len(records))}
+	for i, record := range records {
+		if m, err := objToM(record); err == nil {
+			res.InsertedIDs[i] = meta.KeyValue(m, "_id")
+		}
+	}
+	return res
+}
+
+// sameTexts return true if the updated record has the same field values as the old one, missing old field is empty
+func sameTexts(old, updated []string) bool {
+	for i, txt := range updated {
+		oldTxt := ""
+		if i < len(old) {
+			oldTxt = old[i]
+		}
+		if oldTxt != txt {
+			return false
+		}
+	}
+	return true
+}
+
+// updater return function that return the updated fields of a record and their new values for data of Update or Save command.
+// Data can be dbflex.UpdateDoc, or record whose fields are set to the matching columns. If fields are given, only they are set.
+// Array operators of the update document fail since text column can't hold array
+func updater(data interface{}, fields []string, sch *schema) (func(header, values []string) (toolkit.M, error), error) {
+	if doc, ok := dbflex.ToUpdateDoc(data); ok {
+		return func(header, values []string) (toolkit.M, error) {
+			record := toolkit.M{}
+			for i, h := range header {
+				var v interface{} = ""
+				if i < len(values) {
+					v = values[i]
+				}
+				if col := sch.column(h); col != nil {
+					if pv, err := sch.parse(col, v.(string)); err == nil {
+						v = pv
+					}
+				}
+				record[h] = v
+			}
+			if _, err := doc.Apply(record); err != nil {
+				return nil, err
+			}
+
+			// unset field is written as empty
+			m := toolkit.M{}
+			for _, f := range doc.Fields() {
+				for _, h := range header {
+					if strings.EqualFold(h, f) {
+						m[h] = record[h]
+						break
+					}
+				}
+			}
+			return m, nil
+		}, nil
+	}
+
+	m, err := objToM(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) > 0 {
+		picked := toolkit.M{}
+		for k, v := range m {
+			for _, f := range fields {
+				if strings.EqualFold(k, f) {
+					picked[k] = v
+					break
+				}
+			}
+		}
+		m = picked
+	}
+	return func([]string, []string) (toolkit.M, error) {
+		return m, nil
+	}, nil
+}
+
+// saveRecord return record of Save command data, it is the inserted record of update document if data is dbflex.UpdateDoc
+func saveRecord(data interface{}) (interface{}, error) {
+	if doc, ok := dbflex.ToUpdateDoc(data); ok {
+		return doc.Record()
+	}
+	return data, nil
+}
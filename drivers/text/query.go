@@ -1,12 +1,9 @@
 package text
 
 import (
-	"bufio"
 	"context"
-	"encoding/csv"
 	"io"
 	"os"
-	"path/filepath"
 	"reflect"
 	"strings"
 	"time"
@@ -14,6 +11,8 @@ import (
 	"github.com/theckman/go-flock"
 
 	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filecodec"
+	"git.kanosolution.net/kano/dbflex/drivers/filemeta"
 	"github.com/eaciit/toolkit"
 )
 
@@ -38,16 +37,21 @@ func (q *Query) BuildCommand() (interface{}, error) {
 
 func (q *Query) filePath() (string, error) {
 	conn := q.Connection().(*Connection)
-	filename := ""
 	tablename := q.Config(dbflex.ConfigKeyTableName, "").(string)
 
 	if tablename == "" {
 		return "", toolkit.Errorf("no tablename is specified")
 	}
 
-	filename = tablename + "." + conn.extension
-	filePath := filepath.Join(conn.dirPath, filename)
-	return filePath, nil
+	return conn.tablePath(tablename)
+}
+
+// saveKeys return key fields of Save command, they are keys of the command or keys of the table. _id is used if both are not set
+func (q *Query) saveKeys(meta *filemeta.Table) []string {
+	if keys, ok := q.Config(dbflex.ConfigKeyKeys, []string{}).([]string); ok && len(keys) > 0 {
+		return keys
+	}
+	return meta.KeyFields("_id")
 }
 
 // Cursor return cursor object for this query
@@ -75,14 +79,23 @@ func (q *Query) Cursor(toolkit.M) dbflex.ICursor {
 	}
 	c.extra = q.Config(dbflex.ConfigKeyGroupedQueryItems, dbflex.QueryItems{}).(dbflex.QueryItems)
 
+	// Typed columns of the table, nil if the table has no column definition
+	tableName := q.Config(dbflex.ConfigKeyTableName, "").(string)
+	tableMeta, err := filemeta.LoadTable(q.Connection().(*Connection).dirPath, tableName)
+	if err != nil {
+		c.SetError(err)
+		return c
+	}
+
 	c.filePath = filePath
-	c.openFile()
 	c.textObjectSetting = q.textObjectSetting
+	c.schema = newSchema(tableName, tableMeta, q.textObjectSetting)
+	c.openFile()
 	return c
 }
 
 // Execute the query with its configuration
-func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
+func (q *Query) Execute(parm toolkit.M) (res interface{}, err error) {
 	cfg := q.textObjectSetting
 	cmdType := q.Config(dbflex.ConfigKeyCommandType, "").(string)
 	where := q.Config(dbflex.ConfigKeyWhere, nil)
@@ -105,9 +118,16 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 	var file *os.File
 	//-- if save, insert, update and delete. create the file
 	if (cmdType == dbflex.QueryInsert || cmdType == dbflex.QuerySave || cmdType == dbflex.QueryUpdate || cmdType == dbflex.QueryDelete) && !fileExist {
-		file, err = os.Create(filePath)
+		if filecodec.ByFileName(filePath) != nil {
+			// Empty compressed file will be opened as existing file below
+			err = filecodec.WriteFile(filePath, nil)
+			fileExist = true
+		} else {
+			file, err = os.Create(filePath)
+		}
 		if err != nil {
-			return err, toolkit.Errorf("unable to create file %s. %s", filePath, err.Error())
+			return nil, dbflex.NewError(dbflex.ErrStorage, toolkit.Sprintf("unable to create file %s", filePath), err).
+				WithTable(q.Config(dbflex.ConfigKeyTableName, "").(string))
 		}
 	}
 
@@ -119,39 +139,71 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 	lockCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	fileLock := flock.NewFlock(filePath)
+	tableName := q.Config(dbflex.ConfigKeyTableName, "").(string)
+	lockPath := filemeta.LockPath(q.Connection().(*Connection).dirPath, tableName)
+	fileLock := flock.NewFlock(lockPath)
 	// Try to get exclusive lock every 10ms until time out above
 	_, err = fileLock.TryLockContext(lockCtx, 10*time.Millisecond)
 	if err != nil {
-		return err, toolkit.Errorf("unable to lock file %s. %s", filePath, err.Error())
+		q.Connection().(*Connection).Unlock()
+		return nil, dbflex.NewError(dbflex.ErrLockTimeout, toolkit.Sprintf("unable to lock file %s", lockPath), err).
+			WithTable(tableName)
+	}
+
+	defer func() {
+		fileLock.Unlock()
+		file.Close()
+		q.Connection().(*Connection).Unlock()
+	}()
+
+	// Compressed table is decompressed to a temporary plain file, and compressed back after the command succeed
+	if filecodec.ByFileName(filePath) != nil && fileExist {
+		var plainPath string
+		if plainPath, err = filecodec.Decompress(filePath); err != nil {
+			return nil, err
+		}
+		compressedPath := filePath
+		filePath = plainPath
+
+		defer func() {
+			if err == nil {
+				err = filecodec.Compress(plainPath, compressedPath)
+			}
+			os.Remove(plainPath)
+		}()
 	}
 
 	if fileExist {
 		file, err = os.OpenFile(filePath, os.O_APPEND|os.O_RDWR, os.ModeAppend)
 		if err != nil {
-			return err, toolkit.Errorf("unable to open file %s. %s", filePath, err.Error())
+			return nil, dbflex.NewError(dbflex.ErrStorage, toolkit.Sprintf("unable to open file %s", filePath), err).
+				WithTable(q.Config(dbflex.ConfigKeyTableName, "").(string))
 		}
 	}
 
-	defer func() {
-		fileLock.Unlock()
-		file.Close()
-		q.Connection().(*Connection).Unlock()
-	}()
+	// Keys, unique indexes and columns of the table, nil if table is not declared using EnsureTable
+	tableMeta, err := filemeta.LoadTable(q.Connection().(*Connection).dirPath, tableName)
+	if err != nil {
+		return nil, err
+	}
+	sch := newSchema(tableName, tableMeta, cfg)
+	cfg = sch.config(cfg)
 
 	// === Update block
-	update := func() (int, error) {
-		data, hasData := parm["data"]
-		if !hasData {
-			return 0, toolkit.Errorf("update fail, no data")
+	update := func(data interface{}, fields []string) (*dbflex.ExecResult, error) {
+		updated, err := updater(data, fields, sch)
+		if err != nil {
+			return nil, err
 		}
 
-		// Keep track of how many rows are updated
-		updatedCount := 0
+		// Keep track of how many rows are updated, and how many of them are changed
+		updatedCount, changedCount := 0, 0
 
 		// Since we cannot add / append text except at the end of the file
 		// We need to move updated data and non updated data to temporary file, and the replace existing file with the temporary file
 		tmpFile, err := func() (string, error) {
+			checker := filemeta.NewUniqueChecker(tableName, tableMeta)
+
 			var tempFile *os.File
 			// Randomize temporary file name
 			tempFileName := filePath + "_temp_" + toolkit.RandomString(32)
@@ -160,45 +212,64 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 			}
 			defer tempFile.Close()
 
-			reader := bufio.NewScanner(file)
+			reader := newRecordReader(file, cfg)
 
 			read := -1
 			header := []string{}
-			for reader.Scan() {
-				txt := reader.Text()
+			for {
+				oldData, quoted, err := reader.Read()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return tempFileName, toolkit.Errorf("unable to read file %s. %s", filePath, err.Error())
+				}
+
 				if read < 0 {
-					// If first line read it as header
-					header = strings.Split(txt, string(cfg.Delimeter))
-					tempFile.WriteString(txt + "\n")
+					// If first record read it as header, fixed width file doesn't have header line
+					header = oldData
+					if cfg.hasHeader() {
+						tempFile.WriteString(reader.Raw() + "\n")
+					}
 					read++
 					continue
 				}
 
 				// Check if the old data is match with give filter
-				ok, err := isIncluded(strings.Split(txt, string(cfg.Delimeter)), header, filter)
+				ok, err := isIncluded(oldData, header, filter, sch)
 				if err != nil {
-					return "", err
+					return tempFileName, err
 				}
 
 				if ok {
 					// If old data match with given filter
-					// Convert the updated fields and its value to M
-					m, err := objToM(data)
+					// Get the updated fields and its value as M
+					m, err := updated(header, oldData)
 					if err != nil {
-						return "", err
+						return tempFileName, err
 					}
 
-					// Split the old data text to array of string
-					oldData := strings.Split(txt, string(cfg.Delimeter))
-					// Create place holder for updated data
+					// Create place holder for updated data, both its values and its text to be written
+					updatedValues := []string{}
 					updatedData := []string{}
 					for i, h := range header {
 						match := false
 						for k, v := range m {
 							// Check old data header with updated fields name
 							if strings.ToLower(h) == strings.ToLower(k) {
+								// Make sure the new value match the column type
+								if col := sch.column(h); col != nil {
+									cv, err := sch.convert(col, v)
+									if err != nil && cfg.WriteMode == ModeStrict {
+										return tempFileName, err
+									} else if err == nil {
+										v = cv
+									}
+								}
+
 								// If match append the new value to updated data
-								updatedData = append(updatedData, interfaceToText(v, h, cfg))
+								txt, isString := valueToText(v, h, cfg)
+								updatedValues = append(updatedValues, txt)
+								updatedData = append(updatedData, encodeField(txt, isString, cfg))
 								match = true
 								break
 							}
@@ -207,17 +278,38 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 						// If not match then that means that field is left un updated
 						if !match {
 							// So append the old value to updated data
-							updatedData = append(updatedData, oldData[i])
+							old, oldQuoted := "", false
+							if i < len(oldData) {
+								old, oldQuoted = oldData[i], quoted[i]
+							}
+							updatedValues = append(updatedValues, old)
+							updatedData = append(updatedData, encodeField(old, oldQuoted, cfg))
 						}
 					}
 
+					// Make sure updated data doesn't violate key or unique index
+					if err := checkUniqueFields(checker, header, updatedValues); err != nil {
+						return tempFileName, err
+					}
+
 					// Write the updated data to temporary file
-					tempFile.WriteString(strings.Join(updatedData, string(cfg.Delimeter)) + "\n")
+					txt, err := joinFields(header, updatedData, cfg)
+					if err != nil {
+						return tempFileName, err
+					}
+					tempFile.WriteString(txt + "\n")
 					// Add the counter
 					updatedCount++
+					if !sameTexts(oldData, updatedValues) {
+						changedCount++
+					}
 				} else {
+					if err := checkUniqueFields(checker, header, oldData); err != nil {
+						return tempFileName, err
+					}
+
 					// If data is not match with given filter write the old one
-					tempFile.WriteString(txt + "\n")
+					tempFile.WriteString(reader.Raw() + "\n")
 				}
 			}
 			// Sync the file
@@ -227,11 +319,14 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 		}()
 
 		if err != nil {
-			return updatedCount, err
+			if tmpFile != "" {
+				os.Remove(tmpFile)
+			}
+			return nil, err
 		}
 
 		// Replace original file with the temporary file
-		fp, _ := q.filePath()
+		fp := filePath
 
 		// If there is no update has been made
 		if updatedCount == 0 {
@@ -242,39 +337,59 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 			os.Rename(tmpFile, fp)
 		}
 
-		return updatedCount, nil
+		return &dbflex.ExecResult{RowsMatched: int64(updatedCount), RowsAffected: int64(changedCount)}, nil
 	}
 	// === End of update block
 
 	// === Insert block
-	insert := func() error {
+	insert := func(data interface{}) (*dbflex.ExecResult, error) {
 		written := false
-		data, hasData := parm["data"]
-		if !hasData {
-			return toolkit.Errorf("insert fail, no data")
-		}
 
 		singleData := data
 		if reflect.TypeOf(data).Kind() == reflect.Slice {
 			vd := reflect.ValueOf(data)
 			if vd.Len() == 0 {
-				return nil
+				return new(dbflex.ExecResult), nil
 			}
 			singleData = vd.Index(0).Interface()
 		}
+		res := insertResult(tableMeta, data)
+
+		// Convert data to the column types, so it is validated and written in the same format
+		if sch != nil {
+			normalized, err := sch.normalizeAll(data, cfg.WriteMode)
+			if err != nil {
+				return nil, err
+			}
+			data, singleData = normalized, normalized
+			if ms, ok := normalized.([]toolkit.M); ok {
+				singleData = ms[0]
+			}
+		}
+
+		// Collect existing data, so new data can be checked against table key and unique indexes
+		checker, err := newUniqueChecker(tableName, tableMeta, file, cfg)
+		if err != nil {
+			return nil, err
+		}
 
-		reader := csv.NewReader(file)
-		reader.Comma = cfg.Delimeter
-		header, err := reader.Read()
+		headerReader := newRecordReader(file, cfg)
+		header, _, err := headerReader.Read()
 		if err == io.EOF {
 			// If end of file then the file is empty, and should add data header first
-			header = objHeader(singleData)
-			_, err = file.WriteString(strings.Join(header, string(cfg.Delimeter)) + "\n")
+			header = sch.header(objHeader(singleData), cfg.WriteMode)
+			headerText := encodeRecord(header, false, cfg)
+			if cfg.WriteBOM {
+				headerText = utf8BOM + headerText
+			}
+			_, err = file.WriteString(headerText + "\n")
 			if err != nil {
-				return toolkit.Errorf("unable to write to text file %s. %s", filePath, err.Error())
+				return nil, toolkit.Errorf("unable to write to text file %s. %s", filePath, err.Error())
 			}
-		} else if cfg.WriteMode == ModeLoose {
-			combinedHeader := combineHeader(header, objHeader(singleData))
+		} else if err != nil {
+			return nil, toolkit.Errorf("unable to read file %s. %s", filePath, err.Error())
+		} else if cfg.WriteMode == ModeLoose && cfg.hasHeader() {
+			combinedHeader := combineHeader(header, sch.header(objHeader(singleData), cfg.WriteMode))
 
 			if len(combinedHeader) > len(header) {
 				// Rewrite header
@@ -298,19 +413,29 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 					}
 					defer tempFile.Close()
 
-					reader := bufio.NewScanner(file)
+					reader := newRecordReader(file, cfg)
 					read := -1
 
-					for reader.Scan() {
+					for {
+						if _, _, err := reader.Read(); err == io.EOF {
+							break
+						} else if err != nil {
+							return tempFileName, toolkit.Errorf("unable to read file %s. %s", filePath, err.Error())
+						}
+
 						if read < 0 {
-							// Don't include first line of the file, use the new header instead
-							tempFile.WriteString(strings.Join(combinedHeader, string(cfg.Delimeter)) + "\n")
+							// Don't include first record of the file, use the new header instead
+							headerText := encodeRecord(combinedHeader, false, cfg)
+							if reader.HasBOM() {
+								headerText = utf8BOM + headerText
+							}
+							tempFile.WriteString(headerText + "\n")
 							read++
 							continue
 						}
 
 						// Write the existing data with the addition
-						tempFile.WriteString(reader.Text() + addition + "\n")
+						tempFile.WriteString(reader.Raw() + addition + "\n")
 					}
 
 					var textDatas []string
@@ -321,7 +446,7 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 						for i := 0; i < d.Len(); i++ {
 							txt, err := objToText(d.Index(i).Interface(), combinedHeader, cfg)
 							if err != nil {
-								return "", toolkit.Errorf("error serializing data into text. %s", err.Error())
+								return "", toolkit.Errorf("error serializing data into text. %w", err)
 							}
 
 							textDatas[i] = txt
@@ -330,12 +455,19 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 						// Convert new data to text
 						txt, err := objToText(data, combinedHeader, cfg)
 						if err != nil {
-							return "", toolkit.Errorf("error serializing data into text. %s", err.Error())
+							return "", toolkit.Errorf("error serializing data into text. %w", err)
 						}
 
 						textDatas = []string{txt}
 					}
 
+					for _, td := range textDatas {
+						values, _, _ := parseRecord(td, cfg, true)
+						if err := checkUniqueFields(checker, combinedHeader, values); err != nil {
+							return tempFileName, err
+						}
+					}
+
 					for _, td := range textDatas {
 						// Write it to the file
 						_, err = tempFile.WriteString(td + "\n")
@@ -357,11 +489,14 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 				}()
 
 				if err != nil {
-					return err
+					if tmpFile != "" {
+						os.Remove(tmpFile)
+					}
+					return nil, err
 				}
 
 				//-- delete original file and rename tmpfile to original file
-				fp, _ := q.filePath()
+				fp := filePath
 				os.Rename(tmpFile, fp)
 			}
 		}
@@ -377,7 +512,7 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 					// Convert new data to text
 					txt, err := objToText(d.Index(i).Interface(), header, cfg)
 					if err != nil {
-						return toolkit.Errorf("error serializing data into text. %s", err.Error())
+						return nil, toolkit.Errorf("error serializing data into text. %w", err)
 					}
 
 					textDatas[i] = txt
@@ -386,28 +521,35 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 				// Convert new data to text
 				txt, err := objToText(data, header, cfg)
 				if err != nil {
-					return toolkit.Errorf("error serializing data into text. %s", err.Error())
+					return nil, toolkit.Errorf("error serializing data into text. %w", err)
 				}
 
 				textDatas = []string{txt}
 			}
 
+			for _, td := range textDatas {
+				values, _, _ := parseRecord(td, cfg, true)
+				if err := checkUniqueFields(checker, header, values); err != nil {
+					return nil, err
+				}
+			}
+
 			for _, td := range textDatas {
 				// Write it to the file
 				_, err = file.WriteString(td + "\n")
 				if err != nil {
-					return toolkit.Errorf("unable to write to text file %s. %s", filePath, err.Error())
+					return nil, toolkit.Errorf("unable to write to text file %s. %s", filePath, err.Error())
 				}
 			}
 
 			// Sync the file
 			err = file.Sync()
 			if err != nil {
-				return toolkit.Errorf("unable to write to text file %s. %s", filePath, err.Error())
+				return nil, toolkit.Errorf("unable to write to text file %s. %s", filePath, err.Error())
 			}
 		}
 
-		return nil
+		return res, nil
 	}
 	// === End of insert block
 
@@ -422,46 +564,84 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 			return nil, toolkit.Errorf("update fail, no data")
 		}
 
+		// Update document is inserted as the record it produce
+		record, err := saveRecord(data)
+		if err != nil {
+			return nil, err
+		}
 		// Convert to flat M for easier access
-		mData, err := objToM(data)
+		mData, err := objToM(record)
 		if err != nil {
 			return nil, err
 		}
 
-		// BUG: if the ID is not _id this command will not work
-		// Check if _id is exist
-		if v, ok := mData["_id"]; ok {
-			// If exist, create a filter eq _id
-			filter = dbflex.Eq("_id", v)
+		// Create filter from keys of the command or the table, _id is used if both are not set.
+		// If any of the key is not exist, data will be inserted
+		keys := q.saveKeys(tableMeta)
+		keyFilters := []*dbflex.Filter{}
+		for _, k := range keys {
+			if v, ok := filemeta.Lookup(mData, k); ok {
+				keyFilters = append(keyFilters, dbflex.Eq(k, v))
+			}
+		}
+
+		if len(keyFilters) == len(keys) {
+			filter = keyFilters[0]
+			if len(keyFilters) > 1 {
+				filter = dbflex.And(keyFilters...)
+			}
 			// Run update command
-			count, err := update()
+			updated, err := update(data, nil)
 			if err != nil {
 				return nil, err
 			}
 
 			// If no update has beed made, that means the _id doesn't match any data
-			if count == 0 {
+			if updated.RowsMatched == 0 {
 				// Then insert it
 				// But first reset the file cursor
 				file.Seek(0, 0)
-				return nil, insert()
+				return insert(record)
 			}
-		} else {
-			// If not insert it
-			return nil, insert()
+			return updated, nil
 		}
+		// If not insert it
+		return insert(record)
 
 	case dbflex.QueryInsert:
-		return nil, insert()
+		data, hasData := parm["data"]
+		if !hasData {
+			return nil, toolkit.Errorf("insert fail, no data")
+		}
+		return insert(data)
 
 	case dbflex.QueryUpdate:
-		_, err = update()
-		return nil, err
+		data, hasData := parm["data"]
+		if !hasData {
+			return nil, toolkit.Errorf("update fail, no data")
+		}
+		// Only fields of the command are updated if they are given
+		return update(data, q.Config(dbflex.ConfigKeyFields, []string{}).([]string))
 
 	case dbflex.QueryDelete:
+		deleted := 0
 		// If there is no filter at all then it means delete all data
 		deleteAll := where == nil
 		if deleteAll {
+			// Count the records before they are removed, fixed width file doesn't have header line
+			reader := newRecordReader(file, cfg)
+			for {
+				if _, _, err := reader.Read(); err == io.EOF {
+					break
+				} else if err != nil {
+					return nil, toolkit.Errorf("unable to read file %s. %s", filePath, err.Error())
+				}
+				deleted++
+			}
+			if deleted > 0 {
+				deleted--
+			}
+
 			// Truncate the file
 			err = file.Truncate(0)
 			if err != nil {
@@ -483,30 +663,40 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 				}
 				defer tempFile.Close()
 
-				reader := bufio.NewScanner(file)
+				reader := newRecordReader(file, cfg)
 
 				read := -1
 				header := []string{}
-				for reader.Scan() {
-					txt := reader.Text()
+				for {
+					values, _, err := reader.Read()
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						return tempFileName, toolkit.Errorf("unable to read file %s. %s", filePath, err.Error())
+					}
+
 					if read < 0 {
-						// If it's the first line then read it as header
-						header = strings.Split(txt, string(cfg.Delimeter))
-						tempFile.WriteString(txt + "\n")
+						// If it's the first record then read it as header, fixed width file doesn't have header line
+						header = values
+						if cfg.hasHeader() {
+							tempFile.WriteString(reader.Raw() + "\n")
+						}
 						read++
 						continue
 					}
 
 					// Check if the data is match with the given filter
-					ok, err := isIncluded(strings.Split(txt, string(cfg.Delimeter)), header, filter)
+					ok, err := isIncluded(values, header, filter, sch)
 					if err != nil {
-						return "", err
+						return tempFileName, err
 					}
 
 					// If the data is not match with the given filter
 					// Then write it into the temporary file
 					if !ok {
-						tempFile.WriteString(txt + "\n")
+						tempFile.WriteString(reader.Raw() + "\n")
+					} else {
+						deleted++
 					}
 				}
 				// Sync the file
@@ -516,17 +706,19 @@ func (q *Query) Execute(parm toolkit.M) (interface{}, error) {
 			}()
 
 			if err != nil {
+				if tmpFile != "" {
+					os.Remove(tmpFile)
+				}
 				return nil, err
 			}
 
 			// Replace original file with the temporary file
-			fp, _ := q.filePath()
+			fp := filePath
 			os.Rename(tmpFile, fp)
 		}
+		return &dbflex.ExecResult{RowsMatched: int64(deleted), RowsAffected: int64(deleted)}, nil
 
 	default:
 		return nil, toolkit.Errorf("unknown command: %s", cmdType)
 	}
-
-	return nil, nil
 }
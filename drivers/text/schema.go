@@ -0,0 +1,380 @@
+package text
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filemeta"
+	"github.com/eaciit/toolkit"
+)
+
+// schema is typed columns of a table, it is used to parse values of the file, compare them on filter and validate written data.
+// Nil schema means the table has no column definition, all of its methods can be called on nil schema
+type schema struct {
+	table   string
+	columns []*filemeta.Column
+	cfg     *Config
+}
+
+// newSchema create schema from table metadata and typed columns of fixed width layout,
+// it return nil if table has no column definition
+func newSchema(table string, meta *filemeta.Table, cfg *Config) *schema {
+	columns := []*filemeta.Column{}
+	if meta != nil {
+		columns = append(columns, meta.Columns...)
+	}
+	for _, fc := range cfg.Layout {
+		if fc.Type != "" && meta.Column(fc.Name) == nil {
+			columns = append(columns, &filemeta.Column{Name: fc.Name, Type: fc.Type, Nullable: true})
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	s := new(schema)
+	s.table = table
+	s.columns = columns
+
+	// copy the config, so date format of columns doesn't change the connection config
+	c := *cfg
+	c.DateFormats = map[string]string{}
+	for k, v := range cfg.DateFormats {
+		c.DateFormats[k] = v
+	}
+	for _, col := range columns {
+		if col.DateFormat != "" {
+			c.DateFormats[col.Name] = col.DateFormat
+		}
+	}
+	s.cfg = &c
+	return s
+}
+
+// config return config with date format of the columns, or given config if schema is nil
+func (s *schema) config(cfg *Config) *Config {
+	if s == nil {
+		return cfg
+	}
+	return s.cfg
+}
+
+// column return definition of given column, it return nil if column is not defined
+func (s *schema) column(name string) *filemeta.Column {
+	if s == nil {
+		return nil
+	}
+
+	for _, col := range s.columns {
+		if strings.EqualFold(col.Name, name) {
+			return col
+		}
+	}
+	return nil
+}
+
+// names return name of all columns
+func (s *schema) names() []string {
+	if s == nil {
+		return nil
+	}
+
+	names := make([]string, len(s.columns))
+	for i, col := range s.columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// header return header of the file for given data header. In ModeStrict the header is the columns if schema is defined,
+// in ModeLoose fields of the data that are not defined are added after the columns
+func (s *schema) header(dataHeader []string, mode WriteMode) []string {
+	if s == nil {
+		return dataHeader
+	}
+	if mode == ModeStrict {
+		return s.names()
+	}
+	return combineHeader(s.names(), dataHeader)
+}
+
+func (s *schema) dateFormat(col *filemeta.Column) string {
+	if col.DateFormat != "" {
+		return col.DateFormat
+	}
+	return s.cfg.DateFormat(col.Name)
+}
+
+// parse convert text value of the file to the column type, empty text is replaced by the column default.
+// If there is no default, it is nil except for string column that is not nullable
+func (s *schema) parse(col *filemeta.Column, txt string) (interface{}, error) {
+	if txt == "" {
+		txt = col.Default
+	}
+	if txt == "" {
+		if col.Type == filemeta.ColumnString && !col.Nullable {
+			return "", nil
+		}
+		return nil, nil
+	}
+
+	switch col.Type {
+	case filemeta.ColumnInt:
+		if v, err := strconv.Atoi(txt); err == nil {
+			return v, nil
+		}
+		// integer could be written as decimal number, e.g. 10.000000
+		v, err := strconv.ParseFloat(txt, 64)
+		if err != nil || v != float64(int(v)) {
+			return nil, s.error(col, txt)
+		}
+		return int(v), nil
+
+	case filemeta.ColumnFloat:
+		v, err := strconv.ParseFloat(txt, 64)
+		if err != nil {
+			return nil, s.error(col, txt)
+		}
+		return v, nil
+
+	case filemeta.ColumnBool:
+		v, err := strconv.ParseBool(txt)
+		if err != nil {
+			return nil, s.error(col, txt)
+		}
+		return v, nil
+
+	case filemeta.ColumnDate:
+		format := s.dateFormat(col)
+		v := toolkit.String2Date(txt, format)
+		if toolkit.Date2String(v, format) != txt {
+			return nil, s.error(col, txt)
+		}
+		return v, nil
+	}
+
+	return txt, nil
+}
+
+// convert return value of the column type for written value, empty value is replaced by the column default.
+// It return dbflex.ErrConstraint error if value can't be converted or column is not nullable and has no default
+func (s *schema) convert(col *filemeta.Column, v interface{}) (interface{}, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if !rv.IsValid() || (rv.Kind() == reflect.String && rv.String() == "") {
+		if col.Default != "" {
+			return s.parse(col, col.Default)
+		}
+		if col.Nullable || col.Type == filemeta.ColumnString {
+			return s.parse(col, "")
+		}
+		return nil, dbflex.NewError(dbflex.ErrConstraint, toolkit.Sprintf("%s.%s is not nullable", s.table, col.Name), nil).
+			WithTable(s.table).WithField(col.Name)
+	}
+	v = rv.Interface()
+
+	switch col.Type {
+	case filemeta.ColumnString:
+		return fmt.Sprint(v), nil
+
+	case filemeta.ColumnDate:
+		if t, ok := v.(time.Time); ok {
+			return t, nil
+		}
+
+	case filemeta.ColumnBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+
+	case filemeta.ColumnInt, filemeta.ColumnFloat:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return s.parse(col, strconv.FormatInt(rv.Int(), 10))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return s.parse(col, strconv.FormatUint(rv.Uint(), 10))
+		case reflect.Float32, reflect.Float64:
+			return s.parse(col, strconv.FormatFloat(rv.Float(), 'f', -1, 64))
+		}
+	}
+
+	if rv.Kind() == reflect.String {
+		return s.parse(col, rv.String())
+	}
+	return nil, s.error(col, v)
+}
+
+// normalize convert all defined columns of a record to the column type. In ModeStrict value that can't be converted
+// is returned as error, in ModeLoose it is kept as it is
+func (s *schema) normalize(data interface{}, mode WriteMode) (toolkit.M, error) {
+	m, err := objToM(data)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return m, nil
+	}
+
+	for _, col := range s.columns {
+		name := col.Name
+		var value interface{}
+		for k, v := range m {
+			if strings.EqualFold(k, col.Name) {
+				name, value = k, v
+				break
+			}
+		}
+
+		converted, err := s.convert(col, value)
+		if err != nil {
+			if mode == ModeStrict {
+				return nil, err
+			}
+			converted = value
+		}
+		m[name] = converted
+	}
+	return m, nil
+}
+
+// normalizeAll normalize a record or slice of records
+func (s *schema) normalizeAll(data interface{}, mode WriteMode) (interface{}, error) {
+	if reflect.TypeOf(data).Kind() != reflect.Slice {
+		return s.normalize(data, mode)
+	}
+
+	rv := reflect.ValueOf(data)
+	res := make([]toolkit.M, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		m, err := s.normalize(rv.Index(i).Interface(), mode)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = m
+	}
+	return res, nil
+}
+
+// match check filter against text value of a typed column, numbers and dates are compared by their value instead of text.
+// It return false as the second value if the filter can't be checked by the column type, so it should be compared as text
+func (s *schema) match(txt string, f *dbflex.Filter) (bool, bool, error) {
+	col := s.column(f.Field)
+	if col == nil || col.Type == filemeta.ColumnString {
+		return false, false, nil
+	}
+
+	switch f.Op {
+	case dbflex.OpEq, dbflex.OpNe, dbflex.OpGt, dbflex.OpGte, dbflex.OpLt, dbflex.OpLte, dbflex.OpIn, dbflex.OpNin:
+	default:
+		return false, false, nil
+	}
+
+	value, err := s.parse(col, txt)
+	if err != nil {
+		return false, true, err
+	}
+
+	// compare return false if the values can't be compared, null is only equal to null
+	// and filter value that is not valid for the column never match
+	compare := func(fv interface{}) (int, bool) {
+		if value == nil || fv == nil {
+			return 0, value == nil && fv == nil
+		}
+		other, err := s.convert(col, fv)
+		if err != nil || other == nil {
+			return 0, false
+		}
+		return compareValue(value, other), true
+	}
+
+	switch f.Op {
+	case dbflex.OpIn, dbflex.OpNin:
+		found := false
+		for _, fv := range toInterfaceSlice(f.Value) {
+			if c, ok := compare(fv); ok && c == 0 {
+				found = true
+				break
+			}
+		}
+		return found == (f.Op == dbflex.OpIn), true, nil
+	}
+
+	c, ok := compare(f.Value)
+	if !ok {
+		if f.Op == dbflex.OpNe {
+			return value != nil || f.Value != nil, true, nil
+		}
+		return f.Op == dbflex.OpEq && value == nil && f.Value == nil, true, nil
+	}
+
+	switch f.Op {
+	case dbflex.OpEq:
+		return c == 0, true, nil
+	case dbflex.OpNe:
+		return c != 0, true, nil
+	case dbflex.OpGt:
+		return c > 0, true, nil
+	case dbflex.OpGte:
+		return c >= 0, true, nil
+	case dbflex.OpLt:
+		return c < 0, true, nil
+	}
+	return c <= 0, true, nil
+}
+
+func (s *schema) error(col *filemeta.Column, v interface{}) error {
+	return dbflex.NewError(dbflex.ErrConstraint, toolkit.Sprintf("%v is not a valid %s value for %s.%s", v, col.Type, s.table, col.Name), nil).
+		WithTable(s.table).WithField(col.Name)
+}
+
+// compareValue compare 2 values of the same column type, it return negative if a is less than b, 0 if equal and positive otherwise
+func compareValue(a, b interface{}) int {
+	switch av := a.(type) {
+	case int:
+		bv := b.(int)
+		if av < bv {
+			return -1
+		} else if av > bv {
+			return 1
+		}
+	case float64:
+		bv := b.(float64)
+		if av < bv {
+			return -1
+		} else if av > bv {
+			return 1
+		}
+	case bool:
+		bv := b.(bool)
+		if av != bv {
+			if bv {
+				return -1
+			}
+			return 1
+		}
+	case time.Time:
+		bv := b.(time.Time)
+		if av.Before(bv) {
+			return -1
+		} else if av.After(bv) {
+			return 1
+		}
+	}
+	return 0
+}
+
+func toInterfaceSlice(value interface{}) []interface{} {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice {
+		return []interface{}{value}
+	}
+
+	res := make([]interface{}, rv.Len())
+	for i := range res {
+		res[i] = rv.Index(i).Interface()
+	}
+	return res
+}
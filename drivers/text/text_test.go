@@ -1,10 +1,14 @@
 package text
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -12,6 +16,7 @@ import (
 	"time"
 
 	"git.kanosolution.net/kano/dbflex"
+	"git.kanosolution.net/kano/dbflex/drivers/filemeta"
 
 	"github.com/eaciit/toolkit"
 
@@ -638,6 +643,544 @@ func TestSaveCommand(t *testing.T) {
 		err = conn.Cursor(cmd, nil).Fetchs(&buffer, 0).Error()
 		So(err, ShouldBeNil)
 		So(buffer[0]["Value"], ShouldEqual, "Believe Us")
+
+		Convey("Save using given keys", func() {
+			keyTable := "employees-save-keys"
+			_, err := conn.Execute(dbflex.From(keyTable).Delete(), nil)
+			So(err, ShouldBeNil)
+
+			saveCmd := dbflex.From(keyTable).Save("Code")
+			_, err = conn.Execute(saveCmd, toolkit.M{}.Set("data", toolkit.M{}.Set("Code", "E1").Set("Name", "Bagus")))
+			So(err, ShouldBeNil)
+			out, err := conn.Execute(saveCmd, toolkit.M{}.Set("data", toolkit.M{}.Set("Code", "E1").Set("Name", "Cahyono")))
+			So(err, ShouldBeNil)
+			res, _ := dbflex.ToExecResult(out)
+			So(res.RowsMatched, ShouldEqual, 1)
+
+			buffer := []toolkit.M{}
+			err = conn.Cursor(dbflex.From(keyTable).Where(dbflex.Eq("Code", "E1")), nil).Fetchs(&buffer, 0).Error()
+			So(err, ShouldBeNil)
+			So(len(buffer), ShouldEqual, 1)
+			So(buffer[0]["Name"], ShouldEqual, "Cahyono")
+		})
+	})
+}
+
+func TestTypedError(t *testing.T) {
+	Convey("Typed error", t, func() {
+		tableName := "employees-error"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("text://localhost/%s?extension=csv&separator=comma", workpath), nil)
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "A")))
+		So(err, ShouldBeNil)
+
+		buffer := []toolkit.M{}
+		err = conn.Cursor(dbflex.From(tableName).Where(dbflex.Eq("NotAField", 1)), nil).Fetchs(&buffer, 0).Error()
+		So(errors.Is(err, dbflex.ErrUnknownField), ShouldBeTrue)
+
+		var dbErr *dbflex.Error
+		So(errors.As(err, &dbErr), ShouldBeTrue)
+		So(dbErr.Field, ShouldEqual, "NotAField")
+
+		err = conn.Cursor(dbflex.From(tableName).Where(dbflex.Eq("_id", "B")), nil).Fetch(&toolkit.M{}).Error()
+		So(errors.Is(err, dbflex.ErrNotFound), ShouldBeTrue)
+
+		_, err = conn.Execute(dbflex.From("missing-folder/"+tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "A")))
+		So(errors.Is(err, dbflex.ErrStorage), ShouldBeTrue)
+		So(errors.As(err, &dbErr), ShouldBeTrue)
+		So(dbErr.Table, ShouldEqual, "missing-folder/"+tableName)
+
+		_, err = dbflex.NewConnectionFromURI("nodriver://localhost/data", nil)
+		So(errors.Is(err, dbflex.ErrUnknownDriver), ShouldBeTrue)
+	})
+}
+
+func TestUniqueKey(t *testing.T) {
+	Convey("Unique key", t, func() {
+		type employee struct {
+			EmployeeID string `sql:"EmployeeID" key:"1"`
+			Email      string `sql:"Email" unique:"1"`
+			Grade      int    `sql:"Grade"`
+		}
+
+		tableName := "employees-unique"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("text://localhost/%s?extension=csv&separator=comma", workpath), nil)
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		So(conn.(*Connection).EnsureTable(tableName, nil, employee{}), ShouldBeNil)
+
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", employee{"E1", "e1@mail.com", 1}))
+		So(err, ShouldBeNil)
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", employee{"E1", "e2@mail.com", 1}))
+		So(errors.Is(err, dbflex.ErrDuplicateKey), ShouldBeTrue)
+
+		_, err = conn.Execute(dbflex.From(tableName).Save(), toolkit.M{}.Set("data", employee{"E1", "e1@mail.com", 5}))
+		So(err, ShouldBeNil)
+		_, err = conn.Execute(dbflex.From(tableName).Save(), toolkit.M{}.Set("data", employee{"E2", "e2@mail.com", 2}))
+		So(err, ShouldBeNil)
+
+		_, err = conn.Execute(dbflex.From(tableName).Update().Where(dbflex.Eq("EmployeeID", "E2")), toolkit.M{}.Set("data", toolkit.M{}.Set("Email", "e1@mail.com")))
+		So(errors.Is(err, dbflex.ErrDuplicateKey), ShouldBeTrue)
+
+		buffer := []toolkit.M{}
+		So(conn.Cursor(dbflex.From(tableName).Select(), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+		So(len(buffer), ShouldEqual, 2)
+	})
+}
+
+func TestCompressedTable(t *testing.T) {
+	Convey("Compressed table", t, func() {
+		tableName := "employees-gzip"
+		dirPath := filepath.Join(workpath, "dbflex-text-gzip")
+		So(os.MkdirAll(dirPath, 0755), ShouldBeNil)
+
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("text://localhost/%s?extension=csv&compression=gzip", dirPath), nil)
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		for i := 0; i < 3; i++ {
+			_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("ID", toolkit.Sprintf("E%d", i)).Set("Grade", i)))
+			So(err, ShouldBeNil)
+		}
+		So(conn.ObjectNames(dbflex.ObjTypeTable), ShouldContain, tableName)
+
+		_, err = conn.Execute(dbflex.From(tableName).Delete().Where(dbflex.Eq("ID", "E0")), nil)
+		So(err, ShouldBeNil)
+
+		buffer := []toolkit.M{}
+		So(conn.Cursor(dbflex.From(tableName).Select(), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+		So(len(buffer), ShouldEqual, 2)
+
+		bs, err := ioutil.ReadFile(filepath.Join(dirPath, tableName+".csv.gz"))
+		So(err, ShouldBeNil)
+		So(bs[:2], ShouldResemble, []byte{0x1f, 0x8b})
+	})
+}
+
+func TestCSVRecord(t *testing.T) {
+	Convey("Parse and write RFC 4180 record", t, func() {
+		long := strings.Repeat("x", 100*1024)
+		content := "\uFEFFID,Note,Grade\r\n" +
+			"E1,\"Jakarta, Indonesia\",1\r\n" +
+			"E2,\"He said \"\"hi\"\"\",2\r\n" +
+			"E3,\"first line\nsecond line\",3\r\n" +
+			"E4," + long + ",4\r\n"
+
+		reader := newRecordReader(strings.NewReader(content), cfg)
+		header, _, err := reader.Read()
+		So(err, ShouldBeNil)
+		So(header, ShouldResemble, []string{"ID", "Note", "Grade"})
+		So(reader.HasBOM(), ShouldBeTrue)
+
+		notes := []string{"Jakarta, Indonesia", "He said \"hi\"", "first line\nsecond line", long}
+		for _, note := range notes {
+			values, _, err := reader.Read()
+			So(err, ShouldBeNil)
+			So(len(values), ShouldEqual, 3)
+			So(values[1], ShouldEqual, note)
+		}
+
+		_, _, err = reader.Read()
+		So(err, ShouldEqual, io.EOF)
+
+		Convey("Unclosed quote return error with its line", func() {
+			reader := newRecordReader(strings.NewReader("ID,Note,Grade\nE1,ok,1\nE2,\"not closed,2\nE3,next,3\n"), cfg)
+			for i := 0; i < 2; i++ {
+				_, _, err := reader.Read()
+				So(err, ShouldBeNil)
+			}
+			_, _, err := reader.Read()
+			So(errors.Is(err, io.ErrUnexpectedEOF), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "line 3")
+		})
+
+		Convey("Encode field", func() {
+			minimal := NewConfig(',').SetQuoteMode(QuoteMinimal)
+			So(encodeField("plain", true, minimal), ShouldEqual, "plain")
+			So(encodeField("a,b", true, minimal), ShouldEqual, `"a,b"`)
+			So(encodeField(`say "hi"`, true, cfg), ShouldEqual, `"say ""hi"""`)
+			So(encodeField(`say "hi"`, true, NewConfig(',').SetEscape('\\')), ShouldEqual, `"say \"hi\""`)
+
+			values, _, _ := parseRecord(`"say \"hi\"",1`, NewConfig(',').SetEscape('\\'), false)
+			So(values, ShouldResemble, []string{`say "hi"`, "1"})
+		})
+	})
+
+	Convey("Insert and update data that need quoting", t, func() {
+		tableName := "employees-csv"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("text://localhost/%s?extension=csv", workpath),
+			toolkit.M{}.Set("text_obj_setting", cfg))
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.
+			Set("ID", "E1").Set("Note", "Jakarta, \"Indonesia\"\nSouth East Asia").Set("Grade", 1)))
+		So(err, ShouldBeNil)
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.
+			Set("ID", "E2").Set("Note", "Bandung").Set("Grade", 2)))
+		So(err, ShouldBeNil)
+
+		_, err = conn.Execute(dbflex.From(tableName).Update("Grade").Where(dbflex.Eq("ID", "E2")), toolkit.M{}.
+			Set("data", toolkit.M{}.Set("Grade", 3)))
+		So(err, ShouldBeNil)
+
+		buffer := []toolkit.M{}
+		So(conn.Cursor(dbflex.From(tableName).Select().Where(dbflex.Eq("ID", "E1")), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+		So(len(buffer), ShouldEqual, 1)
+		So(buffer[0].GetString("Note"), ShouldEqual, "Jakarta, \"Indonesia\"\nSouth East Asia")
+
+		So(conn.Cursor(dbflex.From(tableName).Select().Where(dbflex.Eq("ID", "E2")), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+		So(len(buffer), ShouldEqual, 1)
+		So(buffer[0].GetFloat64("Grade"), ShouldEqual, 3)
+		So(conn.Cursor(dbflex.From(tableName).Select(), nil).Count(), ShouldEqual, 2)
+	})
+}
+
+func TestTypedSchema(t *testing.T) {
+	Convey("Typed column schema", t, func() {
+		tableName := "employees-typed"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("text://localhost/%s?extension=csv", workpath), nil)
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		So(conn.(*Connection).SetSchema(tableName,
+			&filemeta.Column{Name: "ID", Type: filemeta.ColumnString},
+			&filemeta.Column{Name: "Grade", Type: filemeta.ColumnInt},
+			&filemeta.Column{Name: "Salary", Type: filemeta.ColumnFloat, Default: "100"},
+			&filemeta.Column{Name: "JoinDate", Type: filemeta.ColumnDate, DateFormat: "yyyy-MM-dd"},
+			&filemeta.Column{Name: "Manager", Type: filemeta.ColumnString, Nullable: true}), ShouldBeNil)
+
+		for i := 1; i <= 10; i++ {
+			_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.
+				Set("ID", toolkit.Sprintf("E%d", i)).
+				Set("Grade", i).
+				Set("JoinDate", toolkit.Sprintf("2020-01-%02d", i))))
+			So(err, ShouldBeNil)
+		}
+
+		Convey("Invalid value is rejected", func() {
+			_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.
+				Set("ID", "E11").Set("Grade", "eleven").Set("JoinDate", "2020-01-11")))
+			So(errors.Is(err, dbflex.ErrConstraint), ShouldBeTrue)
+
+			_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.
+				Set("ID", "E11").Set("Grade", 11)))
+			So(errors.Is(err, dbflex.ErrConstraint), ShouldBeTrue)
+		})
+
+		Convey("Values are parsed and compared by type", func() {
+			buffer := []toolkit.M{}
+			So(conn.Cursor(dbflex.From(tableName).Select().Where(dbflex.Gt("Grade", 8)), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(len(buffer), ShouldEqual, 2)
+			So(buffer[0]["Grade"], ShouldHaveSameTypeAs, 0)
+			So(buffer[0]["Salary"], ShouldEqual, 100)
+			So(buffer[0]["Manager"], ShouldBeNil)
+
+			date := time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)
+			So(conn.Cursor(dbflex.From(tableName).Select().Where(dbflex.Lte("JoinDate", date)), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(len(buffer), ShouldEqual, 3)
+
+			So(conn.Cursor(dbflex.From(tableName).Select().OrderBy("-Grade"), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+			So(buffer[0]["ID"], ShouldEqual, "E10")
+		})
+
+		Convey("Header follows the schema in loose mode", func() {
+			looseCfg := NewConfig(',')
+			looseCfg.WriteMode = ModeLoose
+			looseName := tableName + "-loose"
+			conn.(*Connection).SetSchema(looseName, &filemeta.Column{Name: "ID", Type: filemeta.ColumnString},
+				&filemeta.Column{Name: "Grade", Type: filemeta.ColumnInt, Nullable: true})
+
+			loose, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("text://localhost/%s?extension=csv", workpath),
+				toolkit.M{}.Set("text_obj_setting", looseCfg))
+			So(err, ShouldBeNil)
+			So(loose.Connect(), ShouldBeNil)
+
+			loose.Execute(dbflex.From(looseName).Delete(), nil)
+			_, err = loose.Execute(dbflex.From(looseName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("ID", "E1").Set("Dept", "IT")))
+			So(err, ShouldBeNil)
+
+			bs, err := ioutil.ReadFile(filepath.Join(workpath, looseName+".csv"))
+			So(err, ShouldBeNil)
+			So(strings.Split(string(bs), "\n")[0], ShouldEqual, "ID,Grade,Dept")
+		})
+	})
+}
+
+func TestFixedWidth(t *testing.T) {
+	Convey("Fixed width file", t, func() {
+		tableName := "employees-fixed"
+		fixedCfg := NewConfig(',').SetLayout(
+			&FixedColumn{Name: "ID", Start: 0, Length: 5},
+			&FixedColumn{Name: "Name", Start: 5, Length: 10},
+			&FixedColumn{Name: "Grade", Start: 15, Length: 3, Type: filemeta.ColumnInt, Pad: '0', Align: AlignRight},
+			&FixedColumn{Name: "Salary", Start: 18, Length: 8, Type: filemeta.ColumnFloat, Align: AlignRight})
+
+		content := "E1   Andi      001  1000.5\n" +
+			"E2   Budi      002  2000.0\n" +
+			"E3   Cici      002  3000.0\n"
+		So(ioutil.WriteFile(filepath.Join(workpath, tableName+".txt"), []byte(content), 0644), ShouldBeNil)
+
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("text://localhost/%s?extension=txt", workpath),
+			toolkit.M{}.Set("text_obj_setting", fixedCfg))
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+
+		buffer := []toolkit.M{}
+		So(conn.Cursor(dbflex.From(tableName).Select().Where(dbflex.Eq("Grade", 2)), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+		So(len(buffer), ShouldEqual, 2)
+		So(buffer[0]["Name"], ShouldEqual, "Budi")
+
+		So(conn.Cursor(dbflex.From(tableName).Select().Aggr(dbflex.Sum("Salary")).GroupBy("Grade"), nil).Fetchs(&buffer, 0).Error(), ShouldBeNil)
+		So(len(buffer), ShouldEqual, 2)
+
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.
+			Set("ID", "E4").Set("Name", "Dodi").Set("Grade", 12).Set("Salary", 4000)))
+		So(err, ShouldBeNil)
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.
+			Set("ID", "E5").Set("Name", "Eko").Set("Grade", 1).Set("Salary", 123456789)))
+		So(errors.Is(err, dbflex.ErrConstraint), ShouldBeTrue)
+		_, err = conn.Execute(dbflex.From(tableName).Update("Name").Where(dbflex.Eq("ID", "E1")), toolkit.M{}.
+			Set("data", toolkit.M{}.Set("Name", "Andika")))
+		So(err, ShouldBeNil)
+		_, err = conn.Execute(dbflex.From(tableName).Delete().Where(dbflex.Eq("ID", "E2")), nil)
+		So(err, ShouldBeNil)
+
+		_, err = conn.Execute(dbflex.From(tableName).Update("Name").Where(dbflex.Eq("ID", "E1")), toolkit.M{}.
+			Set("data", toolkit.M{}.Set("Name", "Andika Pratama")))
+		So(errors.Is(err, dbflex.ErrConstraint), ShouldBeTrue)
+
+		bs, err := ioutil.ReadFile(filepath.Join(workpath, tableName+".txt"))
+		So(err, ShouldBeNil)
+		So(strings.Split(strings.TrimSpace(string(bs)), "\n"), ShouldResemble, []string{
+			"E1   Andika    001  1000.5",
+			"E3   Cici      002  3000.0",
+			"E4   Dodi      012    4000",
+		})
+	})
+}
+
+func TestExport(t *testing.T) {
+	Convey("Export cursor", t, func() {
+		tableName := "employees-export"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("text://localhost/%s?extension=csv", workpath), nil)
+		So(err, ShouldBeNil)
+		So(conn.Connect(), ShouldBeNil)
+
+		conn.Execute(dbflex.From(tableName).Delete(), nil)
+		So(conn.(*Connection).SetSchema(tableName,
+			&filemeta.Column{Name: "ID", Type: filemeta.ColumnString},
+			&filemeta.Column{Name: "Name", Type: filemeta.ColumnString},
+			&filemeta.Column{Name: "Salary", Type: filemeta.ColumnFloat},
+			&filemeta.Column{Name: "JoinDate", Type: filemeta.ColumnDate, DateFormat: "yyyy-MM-dd"}), ShouldBeNil)
+
+		names := []string{"Andi", "Budi, Jr", "Cici | Dodi", "Eko"}
+		for i, name := range names {
+			_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", toolkit.M{}.
+				Set("ID", toolkit.Sprintf("E%d", i+1)).
+				Set("Name", name).
+				Set("Salary", 1000.0/float64(i+3)).
+				Set("JoinDate", toolkit.Sprintf("2020-01-%02d", i+1))))
+			So(err, ShouldBeNil)
+		}
+
+		export := func(format dbflex.ExportFormat, opts *dbflex.ExportOptions, cmd dbflex.ICommand) string {
+			buf := new(strings.Builder)
+			c := conn.Cursor(cmd, nil)
+			defer c.Close()
+			So(dbflex.Export(c, buf, format, opts), ShouldBeNil)
+			return buf.String()
+		}
+		opts := &dbflex.ExportOptions{
+			Headers:     map[string]string{"JoinDate": "Join Date"},
+			DateFormats: map[string]string{"": "dd/MM/yyyy"},
+			Precisions:  map[string]int{"Salary": 2},
+			BatchSize:   3,
+		}
+		cmd := dbflex.From(tableName).Select("Name", "JoinDate", "Salary")
+
+		So(export(dbflex.ExportCSV, opts, cmd), ShouldEqual, "Name,Join Date,Salary\n"+
+			"Andi,01/01/2020,333.33\n"+
+			"\"Budi, Jr\",02/01/2020,250.00\n"+
+			"Cici | Dodi,03/01/2020,200.00\n"+
+			"Eko,04/01/2020,166.67\n")
+
+		So(export(dbflex.ExportTSV, nil, cmd.Take(1)), ShouldStartWith, "Name\tJoinDate\tSalary\nAndi\t2020-01-01T")
+
+		So(export(dbflex.ExportJSONL, opts, cmd.Where(dbflex.Eq("ID", "E1"))), ShouldEqual,
+			`{"Name":"Andi","Join Date":"01/01/2020","Salary":333.33}`+"\n")
+
+		So(export(dbflex.ExportJSON, opts, dbflex.From(tableName).Select("ID").Where(dbflex.Eq("ID", "E9"))), ShouldEqual, "[]\n")
+
+		So(export(dbflex.ExportMarkdown, opts, dbflex.From(tableName).Select("ID", "Name").Skip(1).Take(2)), ShouldEqual,
+			"| ID | Name |\n| --- | --- |\n| E2 | Budi, Jr |\n| E3 | Cici \\| Dodi |\n")
+
+		Convey("Use config of the text driver", func() {
+			semicolon := NewConfig(';').SetUseSign(true).SetSign('\'').SetQuoteMode(QuoteMinimal)
+			opts.Encoder = semicolon
+			So(export(dbflex.ExportCSV, opts, dbflex.From(tableName).Select("ID", "Name").OrderBy("-ID")), ShouldEqual,
+				"ID;Name\nE4;Eko\nE3;Cici | Dodi\nE2;Budi, Jr\nE1;Andi\n")
+		})
+
+		Convey("Fetch by default batch size", func() {
+			batchTable := tableName + "-batch"
+			conn.Execute(dbflex.From(batchTable).Delete(), nil)
+			datas := make([]toolkit.M, dbflex.DefaultExportBatchSize*2+1)
+			for i := range datas {
+				datas[i] = toolkit.M{}.Set("ID", toolkit.Sprintf("E%04d", i))
+			}
+			_, err = conn.Execute(dbflex.From(batchTable).Insert(), toolkit.M{}.Set("data", datas))
+			So(err, ShouldBeNil)
+
+			res := export(dbflex.ExportCSV, nil, dbflex.From(batchTable).Select("ID"))
+			rows := strings.Split(strings.TrimSpace(res), "\n")
+			So(len(rows), ShouldEqual, len(datas)+1)
+			So(rows[len(datas)], ShouldEqual, toolkit.Sprintf("E%04d", len(datas)-1))
+		})
+	})
+}
+
+func TestExecResult(t *testing.T) {
+	Convey("Execute result", t, func() {
+		tableName := "employees-result"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("text://localhost/%s?extension=csv&separator=comma", workpath), nil)
+		So(err, ShouldBeNil)
+
+		err = conn.Connect()
+		So(err, ShouldBeNil)
+
+		_, err = conn.Execute(dbflex.From(tableName).Delete(), nil)
+		So(err, ShouldBeNil)
+
+		out, err := conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", []toolkit.M{
+			{"_id": "A", "Grade": "1"}, {"_id": "B", "Grade": "1"}, {"_id": "C", "Grade": "2"},
+		}))
+		So(err, ShouldBeNil)
+		res, ok := dbflex.ToExecResult(out)
+		So(ok, ShouldBeTrue)
+		So(res.RowsAffected, ShouldEqual, 3)
+		So(res.InsertedIDs, ShouldResemble, []interface{}{"A", "B", "C"})
+
+		out, err = conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("Grade", "1")).Update("Grade"),
+			toolkit.M{}.Set("data", toolkit.M{}.Set("Grade", "2")))
+		So(err, ShouldBeNil)
+		res, _ = dbflex.ToExecResult(out)
+		So(res.RowsMatched, ShouldEqual, 2)
+		So(res.RowsAffected, ShouldEqual, 2)
+
+		Convey("Update that match nothing or change nothing", func() {
+			out, err := conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("_id", "X")).Update("Grade"),
+				toolkit.M{}.Set("data", toolkit.M{}.Set("Grade", "3")))
+			So(err, ShouldBeNil)
+			res, _ := dbflex.ToExecResult(out)
+			So(res.RowsMatched, ShouldEqual, 0)
+
+			out, err = conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("_id", "A")).Update("Grade"),
+				toolkit.M{}.Set("data", toolkit.M{}.Set("Grade", "2")))
+			So(err, ShouldBeNil)
+			res, _ = dbflex.ToExecResult(out)
+			So(res.RowsMatched, ShouldEqual, 1)
+			So(res.RowsAffected, ShouldEqual, 0)
+		})
+
+		Convey("Save and delete", func() {
+			out, err := conn.Execute(dbflex.From(tableName).Save(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "A").Set("Grade", "3")))
+			So(err, ShouldBeNil)
+			res, _ := dbflex.ToExecResult(out)
+			So(res.RowsMatched, ShouldEqual, 1)
+			So(len(res.InsertedIDs), ShouldEqual, 0)
+
+			out, err = conn.Execute(dbflex.From(tableName).Save(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "D").Set("Grade", "3")))
+			So(err, ShouldBeNil)
+			res, _ = dbflex.ToExecResult(out)
+			So(res.RowsMatched, ShouldEqual, 0)
+			So(res.InsertedIDs, ShouldResemble, []interface{}{"D"})
+
+			out, err = conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("Grade", "2")).Delete(), nil)
+			So(err, ShouldBeNil)
+			res, _ = dbflex.ToExecResult(out)
+			So(res.RowsAffected, ShouldEqual, 2)
+
+			out, err = conn.Execute(dbflex.From(tableName).Delete(), nil)
+			So(err, ShouldBeNil)
+			res, _ = dbflex.ToExecResult(out)
+			So(res.RowsAffected, ShouldEqual, 2)
+		})
+	})
+}
+
+func TestUpdateDoc(t *testing.T) {
+	Convey("Update document", t, func() {
+		tableName := "employees-updatedoc"
+		conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("text://localhost/%s?extension=csv&separator=comma", workpath), nil)
+		So(err, ShouldBeNil)
+
+		err = conn.Connect()
+		So(err, ShouldBeNil)
+
+		_, err = conn.Execute(dbflex.From(tableName).Delete(), nil)
+		So(err, ShouldBeNil)
+		_, err = conn.Execute(dbflex.From(tableName).Insert(), toolkit.M{}.Set("data", []toolkit.M{
+			{"_id": "A", "Name": "Bagus", "Grade": 1, "Score": 70},
+			{"_id": "B", "Name": "Cahyono", "Grade": 2, "Score": 80},
+		}))
+		So(err, ShouldBeNil)
+
+		read := func(id string) toolkit.M {
+			buffer := []toolkit.M{}
+			err := conn.Cursor(dbflex.From(tableName).Where(dbflex.Eq("_id", id)), nil).Fetchs(&buffer, 0).Error()
+			So(err, ShouldBeNil)
+			So(len(buffer), ShouldEqual, 1)
+			return buffer[0]
+		}
+
+		Convey("Apply operators", func() {
+			doc := dbflex.NewUpdate().Inc("Grade", 2).Mul("Score", 2).Max("Name", "Dody")
+			out, err := conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("_id", "A")).Update(), toolkit.M{}.Set("data", doc))
+			So(err, ShouldBeNil)
+			res, _ := dbflex.ToExecResult(out)
+			So(res.RowsAffected, ShouldEqual, 1)
+
+			m := read("A")
+			So(m.GetInt("Grade"), ShouldEqual, 3)
+			So(m.GetInt("Score"), ShouldEqual, 140)
+			So(m.GetString("Name"), ShouldEqual, "Dody")
+
+			_, err = conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("_id", "B")).Update(),
+				toolkit.M{}.Set("data", dbflex.NewUpdate().Min("Score", 90).Unset("Name")))
+			So(err, ShouldBeNil)
+			m = read("B")
+			So(m.GetInt("Score"), ShouldEqual, 80)
+			So(m.GetString("Name"), ShouldEqual, "")
+		})
+
+		Convey("Only named fields are updated", func() {
+			_, err := conn.Execute(dbflex.From(tableName).Where(dbflex.Eq("_id", "A")).Update("Grade"),
+				toolkit.M{}.Set("data", toolkit.M{}.Set("Name", "Dody").Set("Grade", 5)))
+			So(err, ShouldBeNil)
+			m := read("A")
+			So(m.GetInt("Grade"), ShouldEqual, 5)
+			So(m.GetString("Name"), ShouldEqual, "Bagus")
+		})
+
+		Convey("Save update document", func() {
+			doc := dbflex.NewUpdate().Set("_id", "C").Set("Name", "Eko").Set("Score", 60).Inc("Grade", 1)
+			_, err := conn.Execute(dbflex.From(tableName).Save(), toolkit.M{}.Set("data", doc))
+			So(err, ShouldBeNil)
+			So(read("C").GetInt("Grade"), ShouldEqual, 1)
+
+			_, err = conn.Execute(dbflex.From(tableName).Save(), toolkit.M{}.Set("data", doc))
+			So(err, ShouldBeNil)
+			So(read("C").GetInt("Grade"), ShouldEqual, 2)
+		})
 	})
 }
 
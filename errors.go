@@ -0,0 +1,91 @@
+package dbflex
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNotFound is returned when document/data/row not found, it is the same error as EOF
+	ErrNotFound = EOF
+	// ErrDuplicateKey is returned when inserted or updated data violate a key or unique index
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrFKViolation is returned when referenced data of a foreign key is not exist
+	ErrFKViolation = errors.New("foreign key violation")
+	// ErrFKNotEmpty is returned when deleted data is still referenced by other table
+	ErrFKNotEmpty = errors.New("foreign key is not empty")
+	// ErrLockTimeout is returned when a lock can't be obtained before timeout
+	ErrLockTimeout = errors.New("lock timeout")
+	// ErrUnknownField is returned when a field is not exist in the table
+	ErrUnknownField = errors.New("unknown field")
+	// ErrUnknownDriver is returned when a driver is not registered
+	ErrUnknownDriver = errors.New("unknown driver")
+	// ErrVersionConflict is returned when updated data has been changed by others since it is read
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrValidation is returned when data fail validation rules of its model before it is written
+	ErrValidation = errors.New("validation failed")
+	// ErrStorage is returned when storage of a table, e.g. file of file driver, can't be created, read or written
+	ErrStorage = errors.New("storage error")
+	// ErrConstraint is returned when data violate a constraint. ErrDuplicateKey, ErrFKViolation and ErrFKNotEmpty are also ErrConstraint
+	ErrConstraint = errors.New("constraint violation")
+)
+
+// Error is dbflex error that has a kind (one of the Err sentinel), table and field context, and the cause.
+// It can be checked using errors.Is against its kind and errors.As to get the context
+type Error struct {
+	Kind  error
+	Table string
+	Field string
+	Msg   string
+	Err   error
+}
+
+// NewError create new Error of given kind, message and cause. Message and cause are optional
+func NewError(kind error, msg string, cause error) *Error {
+	return &Error{Kind: kind, Msg: msg, Err: cause}
+}
+
+// WithTable set table of the error
+func (e *Error) WithTable(name string) *Error {
+	e.Table = name
+	return e
+}
+
+// WithField set field of the error
+func (e *Error) WithField(name string) *Error {
+	e.Field = name
+	return e
+}
+
+// Error return error message
+func (e *Error) Error() string {
+	msg := e.Msg
+	if msg == "" && e.Kind != nil {
+		msg = e.Kind.Error()
+	}
+	if e.Err != nil {
+		if msg == "" {
+			return e.Err.Error()
+		}
+		return msg + ". " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap return the cause
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is return true if target is the kind of the error
+func (e *Error) Is(target error) bool {
+	if target == nil {
+		return false
+	}
+	if target == e.Kind {
+		return true
+	}
+	if target == ErrConstraint {
+		return e.Kind == ErrDuplicateKey || e.Kind == ErrFKViolation || e.Kind == ErrFKNotEmpty
+	}
+	return false
+}
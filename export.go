@@ -0,0 +1,346 @@
+package dbflex
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eaciit/toolkit"
+)
+
+// DefaultExportBatchSize is number of records fetched from the cursor each time by Export if BatchSize is not set
+const DefaultExportBatchSize = 500
+
+// ExportFormat is output format of Export
+type ExportFormat string
+
+const (
+	// ExportCSV is comma separated values, IRecordEncoder can be used to change the delimiter and quoting
+	ExportCSV ExportFormat = "csv"
+	// ExportTSV is tab separated values
+	ExportTSV ExportFormat = "tsv"
+	// ExportJSON is JSON array of objects
+	ExportJSON ExportFormat = "json"
+	// ExportJSONL is JSON Lines, one object per line
+	ExportJSONL ExportFormat = "jsonl"
+	// ExportMarkdown is Markdown table
+	ExportMarkdown ExportFormat = "markdown"
+)
+
+// IRecordEncoder encode text values of a record into a line without line terminator, it is used by CSV and TSV export.
+// Config of the text driver implements it, so exported file use the same delimiter and quoting as the driver
+type IRecordEncoder interface {
+	EncodeRecord(values []string) string
+}
+
+// ExportOptions is optional configuration of Export
+type ExportOptions struct {
+	// Fields is exported columns and their order. Default is fields of Select command,
+	// or all fields of the first record sorted by name if Select has no field
+	Fields []string
+
+	// Headers rename column header, key is field name
+	Headers map[string]string
+
+	// DateFormats is toolkit date format of time value per field, empty string key is the default for all fields.
+	// Time value is written using RFC 3339 if it has no date format
+	DateFormats map[string]string
+
+	// Precisions is number of decimal of float value per field, empty string key is the default for all fields.
+	// Float value is written as it is if it has no precision
+	Precisions map[string]int
+
+	// Formatters format value of a field, it override date format and precision
+	Formatters map[string]func(interface{}) string
+
+	// Encoder encode records of CSV and TSV export, default is RFC 4180 encoding with comma or tab delimiter
+	Encoder IRecordEncoder
+
+	// BatchSize is number of records fetched from the cursor each time, default is DefaultExportBatchSize
+	BatchSize int
+}
+
+// Export write all records of the cursor to w in given format. Cursor is not closed after export.
+// Each call write a single table, writing several cursors into one multi-sheet output is not supported
+func Export(cursor ICursor, w io.Writer, format ExportFormat, opts ...*ExportOptions) error {
+	o := new(ExportOptions)
+	if len(opts) > 0 && opts[0] != nil {
+		o = opts[0]
+	}
+	batchSize := o.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultExportBatchSize
+	}
+
+	ew, err := newExportWriter(w, format, o)
+	if err != nil {
+		return err
+	}
+
+	fields := o.Fields
+	if len(fields) == 0 {
+		cursor.ConfigRef(ConfigKeyFields, []string{}, &fields)
+	}
+
+	headerWritten := false
+	writeHeader := func() error {
+		headerWritten = true
+		headers := make([]string, len(fields))
+		for i, f := range fields {
+			headers[i] = f
+			if h, ok := o.Headers[f]; ok {
+				headers[i] = h
+			}
+		}
+		return ew.header(headers)
+	}
+
+	for {
+		buffer := []toolkit.M{}
+		if err := cursor.Fetchs(&buffer, batchSize).Error(); err != nil && !errors.Is(err, EOF) {
+			return toolkit.Errorf("unable to fetch data. %w", err)
+		}
+
+		for _, m := range buffer {
+			if len(fields) == 0 {
+				fields = m.Keys()
+				sort.Strings(fields)
+			}
+			if !headerWritten {
+				if err := writeHeader(); err != nil {
+					return err
+				}
+			}
+
+			values := make([]interface{}, len(fields))
+			texts := make([]string, len(fields))
+			for i, f := range fields {
+				values[i], texts[i] = o.format(f, lookupField(m, f))
+			}
+			if err := ew.record(values, texts); err != nil {
+				return err
+			}
+		}
+
+		if len(buffer) < batchSize {
+			break
+		}
+	}
+
+	// JSON array is always written, even if it is empty
+	if !headerWritten && (len(fields) > 0 || format == ExportJSON) {
+		if err := writeHeader(); err != nil {
+			return err
+		}
+	}
+	return ew.close()
+}
+
+func lookupField(m toolkit.M, field string) interface{} {
+	if v, ok := m[field]; ok {
+		return v
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, field) {
+			return v
+		}
+	}
+	return nil
+}
+
+// format return exported value and text of a field value
+func (o *ExportOptions) format(field string, v interface{}) (interface{}, string) {
+	if fn, ok := o.Formatters[field]; ok {
+		txt := fn(v)
+		return txt, txt
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if !rv.IsValid() {
+		return nil, ""
+	}
+	v = rv.Interface()
+
+	if t, ok := v.(time.Time); ok {
+		txt := t.Format(time.RFC3339)
+		if f, ok := o.option(o.DateFormats, field); ok {
+			txt = toolkit.Date2String(t, f)
+		}
+		return txt, txt
+	}
+
+	if rv.Kind() == reflect.Float32 || rv.Kind() == reflect.Float64 {
+		precision := -1
+		if p, ok := o.Precisions[field]; ok {
+			precision = p
+		} else if p, ok := o.Precisions[""]; ok {
+			precision = p
+		}
+		txt := strconv.FormatFloat(rv.Float(), 'f', precision, 64)
+		return json.Number(txt), txt
+	}
+
+	return v, fmt.Sprint(v)
+}
+
+func (o *ExportOptions) option(m map[string]string, field string) (string, bool) {
+	if v, ok := m[field]; ok {
+		return v, true
+	}
+	v, ok := m[""]
+	return v, ok
+}
+
+type exportWriter interface {
+	header(headers []string) error
+	record(values []interface{}, texts []string) error
+	close() error
+}
+
+func newExportWriter(w io.Writer, format ExportFormat, o *ExportOptions) (exportWriter, error) {
+	switch format {
+	case ExportCSV, ExportTSV:
+		ew := &delimitedExportWriter{w: w, encoder: o.Encoder}
+		if ew.encoder == nil {
+			ew.csv = csv.NewWriter(w)
+			if format == ExportTSV {
+				ew.csv.Comma = '\t'
+			}
+		}
+		return ew, nil
+	case ExportJSON, ExportJSONL:
+		return &jsonExportWriter{w: w, lines: format == ExportJSONL}, nil
+	case ExportMarkdown:
+		return &markdownExportWriter{w: w}, nil
+	}
+	return nil, toolkit.Errorf("unknown export format %s", format)
+}
+
+type delimitedExportWriter struct {
+	w       io.Writer
+	csv     *csv.Writer
+	encoder IRecordEncoder
+}
+
+func (ew *delimitedExportWriter) header(headers []string) error {
+	return ew.record(nil, headers)
+}
+
+func (ew *delimitedExportWriter) record(values []interface{}, texts []string) error {
+	if ew.encoder != nil {
+		_, err := io.WriteString(ew.w, ew.encoder.EncodeRecord(texts)+"\n")
+		return err
+	}
+	return ew.csv.Write(texts)
+}
+
+func (ew *delimitedExportWriter) close() error {
+	if ew.csv != nil {
+		ew.csv.Flush()
+		return ew.csv.Error()
+	}
+	return nil
+}
+
+type jsonExportWriter struct {
+	w       io.Writer
+	lines   bool
+	headers []string
+	count   int
+}
+
+func (ew *jsonExportWriter) header(headers []string) error {
+	ew.headers = headers
+	if !ew.lines {
+		_, err := io.WriteString(ew.w, "[")
+		return err
+	}
+	return nil
+}
+
+func (ew *jsonExportWriter) record(values []interface{}, texts []string) error {
+	// object is written manually to keep the order of the fields
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, h := range ew.headers {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		key, _ := json.Marshal(h)
+		value, err := json.Marshal(values[i])
+		if err != nil {
+			return toolkit.Errorf("unable to encode %s. %w", h, err)
+		}
+		sb.Write(key)
+		sb.WriteString(":")
+		sb.Write(value)
+	}
+	sb.WriteString("}")
+
+	prefix := ""
+	if !ew.lines {
+		prefix = "\n"
+		if ew.count > 0 {
+			prefix = ",\n"
+		}
+	}
+	ew.count++
+
+	line := prefix + sb.String()
+	if ew.lines {
+		line += "\n"
+	}
+	_, err := io.WriteString(ew.w, line)
+	return err
+}
+
+func (ew *jsonExportWriter) close() error {
+	if ew.lines {
+		return nil
+	}
+
+	end := "]\n"
+	if ew.count > 0 {
+		end = "\n]\n"
+	}
+	_, err := io.WriteString(ew.w, end)
+	return err
+}
+
+type markdownExportWriter struct {
+	w io.Writer
+}
+
+func (ew *markdownExportWriter) header(headers []string) error {
+	if err := ew.record(nil, headers); err != nil {
+		return err
+	}
+
+	separators := make([]string, len(headers))
+	for i := range separators {
+		separators[i] = "---"
+	}
+	return ew.record(nil, separators)
+}
+
+func (ew *markdownExportWriter) record(values []interface{}, texts []string) error {
+	cells := make([]string, len(texts))
+	for i, txt := range texts {
+		txt = strings.Replace(txt, "|", "\\|", -1)
+		txt = strings.Replace(txt, "\r\n", "<br>", -1)
+		cells[i] = strings.Replace(txt, "\n", "<br>", -1)
+	}
+	_, err := io.WriteString(ew.w, "| "+strings.Join(cells, " | ")+" |\n")
+	return err
+}
+
+func (ew *markdownExportWriter) close() error {
+	return nil
+}
@@ -4,7 +4,7 @@ go 1.15
 
 require (
 	github.com/ariefdarmawan/flexmgo v0.1.15
-	github.com/ariefdarmawan/reflector v0.0.0-20210429160254-3690a39ca6e7 // indirect
+	github.com/ariefdarmawan/reflector v0.0.0-20210429160254-3690a39ca6e7
 	github.com/eaciit/toolkit v0.0.0-20210610161449-593d5fadf78e
 	github.com/gofrs/flock v0.8.1 // indirect
 	github.com/smartystreets/goconvey v1.6.4
@@ -0,0 +1,102 @@
+package dbflex
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eaciit/toolkit"
+)
+
+// InterceptAction is enumeration of connection action that can be intercepted
+type InterceptAction string
+
+const (
+	// InterceptPrepare is action of preparing a command into query
+	InterceptPrepare InterceptAction = "prepare"
+	// InterceptExecute is action of executing a command
+	InterceptExecute InterceptAction = "execute"
+	// InterceptCursor is action of opening a cursor from a command
+	InterceptCursor InterceptAction = "cursor"
+)
+
+// InterceptInfo holds information of a command that is being processed by a connection.
+// Interceptor can modify its fields before calling next handler, and read the result after it
+type InterceptInfo struct {
+	Action     InterceptAction
+	Driver     string
+	Connection IConnection
+
+	// Command is the dbflex command, change it before next is called on prepare to alter the built query
+	Command ICommand
+	// NativeCommand is the driver command returned by BuildCommand, available after query is prepared
+	NativeCommand interface{}
+	Query         IQuery
+	Parm          toolkit.M
+
+	// Result is the result of Execute
+	Result interface{}
+	// Cursor is the cursor returned on Cursor
+	Cursor ICursor
+
+	StartTime time.Time
+	Duration  time.Duration
+}
+
+// InterceptHandler process an InterceptInfo, it is the next handler on the interceptor chain
+type InterceptHandler func(info *InterceptInfo) error
+
+// Interceptor wraps Prepare, Execute and Cursor of a connection.
+// It should call next to continue the process, returning without calling next will short-circuit the process
+// (Result or Cursor on info should be set accordingly) and returning an error will reject it
+type Interceptor func(info *InterceptInfo, next InterceptHandler) error
+
+var (
+	driverInterceptors   = map[string][]Interceptor{}
+	driverInterceptorsMu sync.RWMutex
+)
+
+// RegisterInterceptor register interceptors for all connection of given driver
+func RegisterInterceptor(driver string, fns ...Interceptor) {
+	driverInterceptorsMu.Lock()
+	defer driverInterceptorsMu.Unlock()
+	driverInterceptors[driver] = append(driverInterceptors[driver], fns...)
+}
+
+// ResetInterceptors remove all interceptors registered for given driver
+func ResetInterceptors(driver string) {
+	driverInterceptorsMu.Lock()
+	defer driverInterceptorsMu.Unlock()
+	delete(driverInterceptors, driver)
+}
+
+// DriverInterceptors return interceptors registered for given driver
+func DriverInterceptors(driver string) []Interceptor {
+	driverInterceptorsMu.RLock()
+	defer driverInterceptorsMu.RUnlock()
+	return driverInterceptors[driver]
+}
+
+// runInterceptors run given info through the interceptor chain and call fn as the last handler
+func runInterceptors(interceptors []Interceptor, info *InterceptInfo, fn InterceptHandler) error {
+	info.StartTime = time.Now()
+	last := func(info *InterceptInfo) error {
+		err := fn(info)
+		info.Duration = time.Since(info.StartTime)
+		return err
+	}
+
+	if len(interceptors) == 0 {
+		return last(info)
+	}
+
+	var chain func(idx int) InterceptHandler
+	chain = func(idx int) InterceptHandler {
+		if idx == len(interceptors) {
+			return last
+		}
+		return func(info *InterceptInfo) error {
+			return interceptors[idx](info, chain(idx+1))
+		}
+	}
+	return chain(0)(info)
+}
@@ -0,0 +1,115 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/eaciit/toolkit"
+)
+
+type actorKey struct{}
+
+// WithActor return context that hold actor of the writes, it is set to created by and updated by fields of the model
+func WithActor(ctx context.Context, actor interface{}) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext return actor of the context, it return nil if context has no actor
+func ActorFromContext(ctx context.Context) interface{} {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Value(actorKey{})
+}
+
+// stamp set created, updated, created by and updated by fields of dm. Created fields are only set on insert if they are empty,
+// actor fields are not changed if actor is nil
+func stamp(conn dbflex.IConnection, dm DataModel, actor interface{}, insert bool) error {
+	meta := GetMeta(conn, dm)
+	v := reflect.Indirect(reflect.ValueOf(dm))
+	now := time.Now()
+	for _, f := range meta.Fields {
+		fv := v.FieldByIndex(f.Index)
+		var value interface{}
+		switch {
+		case f.Created && insert && fv.IsZero(), f.Updated:
+			value = now
+		case f.CreatedBy && insert && fv.IsZero() && actor != nil, f.UpdatedBy && actor != nil:
+			value = actor
+		default:
+			continue
+		}
+		if e := setFieldValue(fv, value); e != nil {
+			return fmt.Errorf("field %s: %w", f.Name, e)
+		}
+	}
+
+	// version of new data is started from 1
+	if insert && meta.Version != nil {
+		if fv := v.FieldByIndex(meta.Version.Index); fv.IsZero() {
+			return setVersion(fv, 1)
+		}
+	}
+	return nil
+}
+
+// checkVersion make sure version of dm is the same as the stored data and increase it. It return filter of the update
+// that include the current version and function to put back the version if the update fail
+func checkVersion(conn dbflex.IConnection, dm DataModel, filter *dbflex.Filter) (*dbflex.Filter, func(), error) {
+	meta := GetMeta(conn, dm)
+	if meta.Version == nil {
+		return filter, func() {}, nil
+	}
+
+	fv := reflect.Indirect(reflect.ValueOf(dm)).FieldByIndex(meta.Version.Index)
+	current := fv.Interface()
+	filter = andFilter(filter, dbflex.Eq(meta.Version.DbName, current))
+
+	// check it before the update, since driver that doesn't return dbflex.ExecResult can't report that update matched nothing
+	stored := toolkit.M{}
+	cmd := dbflex.From(dm.TableName()).Select().Where(filter).Take(1)
+	if e := conn.Cursor(cmd, nil).Fetch(&stored).Close(); e != nil {
+		return filter, nil, versionConflict(dm, meta, current)
+	}
+
+	version, _ := versionValue(fv)
+	if e := setVersion(fv, version+1); e != nil {
+		return filter, nil, e
+	}
+	return filter, func() {
+		fv.Set(reflect.ValueOf(current))
+	}, nil
+}
+
+func versionConflict(dm DataModel, meta *ModelMeta, version interface{}) error {
+	return dbflex.NewError(dbflex.ErrVersionConflict, fmt.Sprintf("data has been changed or deleted since version %v", version), nil).
+		WithTable(dm.TableName()).WithField(meta.Version.DbName)
+}
+
+func versionValue(fv reflect.Value) (int64, error) {
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(fv.Uint()), nil
+	}
+	return 0, fmt.Errorf("version field should be integer, got %s", fv.Type())
+}
+
+func setVersion(fv reflect.Value, version int64) error {
+	if _, e := versionValue(fv); e != nil {
+		return e
+	}
+	if fv.Kind() >= reflect.Uint && fv.Kind() <= reflect.Uint64 {
+		fv.SetUint(uint64(version))
+	} else {
+		fv.SetInt(version)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package orm
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"strings"
@@ -17,6 +18,7 @@ type DataModel interface {
 	SetObjectID(...interface{}) DataModel
 	FK() []*FKConfig
 	ReverseFK() []*ReverseFKConfig
+	Relations() []*Relation
 
 	GetFilterID(dbflex.IConnection, ...interface{}) *dbflex.Filter
 	GetWhereFilter(toolkit.M) *dbflex.Filter
@@ -29,18 +31,9 @@ type DataModel interface {
 	This() DataModel
 }
 
-type MetaField struct {
-	Name   string
-	DbName string
-	Type   reflect.Type
-}
-
 // DataModelBase is a base struct for easier implementation of DataModel interface
 type DataModelBase struct {
 	self DataModel
-
-	hasBeenCalc bool
-	meta        map[string]MetaField
 }
 
 // FK return FKConfig for data model
@@ -53,59 +46,17 @@ func (dm *DataModelBase) ReverseFK() []*ReverseFKConfig {
 	return []*ReverseFKConfig{}
 }
 
+// Relations return relations of data model
+func (dm *DataModelBase) Relations() []*Relation {
+	return []*Relation{}
+}
+
 // NewDataModel abstraction to create new data model object
 func NewDataModel(m DataModel) DataModel {
 	m.SetThis(m)
 	return m
 }
 
-func getFieldInfo(d *DataModelBase, fieldNameTag string) {
-	if !d.hasBeenCalc {
-		v := reflect.ValueOf(d.This())
-		if v.Kind() == reflect.Ptr {
-			v = v.Elem()
-		}
-
-		t := v.Type()
-		fieldNum := t.NumField()
-		fields := make(map[string]MetaField, fieldNum)
-		for i := 0; i < fieldNum; i++ {
-			f := t.Field(i)
-			tag := f.Tag
-			dbname := strings.ToLower(f.Name)
-			nameTag := tag.Get(fieldNameTag)
-			if nameTag != "" {
-				dbname = nameTag
-			}
-			fields[f.Name] = MetaField{
-				Name:   f.Name,
-				DbName: dbname,
-				Type:   f.Type,
-			}
-		}
-		d.meta = fields
-		d.hasBeenCalc = true
-	}
-}
-
-func getFieldDbName(d *DataModelBase, name, fieldNameTag string) string {
-	getFieldInfo(d, fieldNameTag)
-	mf, ok := d.meta[name]
-	if !ok {
-		return ""
-	}
-	return mf.DbName
-}
-
-func getFieldType(d *DataModelBase, name, fieldNameTag string) reflect.Type {
-	getFieldInfo(d, fieldNameTag)
-	mf, ok := d.meta[name]
-	if !ok {
-		return reflect.TypeOf("")
-	}
-	return mf.Type
-}
-
 // SetThis to create circle loop to refer to datamodel
 func (d *DataModelBase) SetThis(m DataModel) {
 	d.self = m
@@ -146,59 +97,34 @@ func (d *DataModelBase) GetFilterID(conn dbflex.IConnection, ids ...interface{})
 	return dbflex.And(filters...)
 }
 
-// Get where filter from any field that not blank
-func (d *DataModelBase) GetWhereFilter(toolkit.M) *dbflex.Filter {
-	panic("GetWhereFilter not implemented")
+// GetWhereFilter return And filter of Eq from fields that are not blank and values of parm, parm can also hold WhereContains
+// and WhereFieldTag options. Time field of parm can be pair of from and to time to filter it using Range.
+// Db name of the fields is using json tag, the default field name tag of the connection, use WhereFilter to use tag of a connection
+func (d *DataModelBase) GetWhereFilter(parm toolkit.M) *dbflex.Filter {
+	return whereFilter(nil, d.This(), parm)
 }
 
-// GetID to get ID
+// GetID to get ID, it return db name and value of the fields that has key tag of the connection
 func (d *DataModelBase) GetID(conn dbflex.IConnection) ([]string, []interface{}) {
-	fieldtag := ""
-	keynametag := ""
-	if conn != nil {
-		fieldtag = conn.FieldNameTag()
-		keynametag = conn.KeyNameTag()
+	meta := GetMeta(conn, d.This())
+	if len(meta.Keys) == 0 {
+		panic("GetID can't be applied for " + meta.Type.Name() + ", please check your object definition.")
 	}
 
-	if keynametag == "" {
-		keynametag = "key"
-	}
-
-	v := reflect.ValueOf(d.This()).Elem()
-	t := v.Type()
-
-	ids := []string{}
-	values := []interface{}{}
-	fieldNum := t.NumField()
-	for idx := 0; idx < fieldNum; idx++ {
-		tf := t.Field(idx)
-		fn := strings.Split(tf.Tag.Get(fieldtag), ",")[0]
-		if fn == "" {
-			fn = tf.Name
-		}
-		iskey := tf.Tag.Get(keynametag)
-
-		//fmt.Println(t.Name, "|", tf.Name, "|", fn, "|", iskey)
-		if iskey != "" {
-			ids = append(ids, fn)
-			values = append(values, v.Field(idx).Interface())
-		}
-	}
-
-	if len(ids) == 0 {
-		panic("GetID can't be applied for " + t.Name() + ", please check your object definition.")
-	}
-
-	return ids, values
+	return meta.KeyNames(), meta.Values(d.This(), meta.Keys...)
 }
 
-// SetID is not used yet
+// SetID set the fields that has key tag in order with given values, value is converted to the field type.
+// Value that can't be converted is not set, use orm.SetID to get the error and to use key tag of a connection
 func (d *DataModelBase) SetID(keys ...interface{}) {
-	//-- do nothing
-	panic("SetID is not yet implemented for this DataModel")
+	meta := GetMetaWithTag(d.This(), defaultFieldTag, defaultKeyTag)
+	if len(meta.Keys) == 0 {
+		panic("SetID can't be applied for " + meta.Type.Name() + ", please check your object definition.")
+	}
+	setKeys(meta, d.This(), keys)
 }
 
-// SetObjectID is not used yet
+// SetObjectID set ID of the data model and return it
 func (d *DataModelBase) SetObjectID(keys ...interface{}) DataModel {
 	d.This().SetID(keys...)
 	return d.This()
@@ -230,34 +156,57 @@ func (d *DataModelBase) PostDelete(conn dbflex.IConnection) error {
 
 // Get a single data from given connection and model
 // For single data you can make filter directly inside the model itself,
-// the downside of this feature is currently you cannot use dbflex.And, Or, Range on the same field
-func Get(conn dbflex.IConnection, model DataModel) error {
+// the downside of this feature is currently you cannot use dbflex.And, Or, Range on the same field.
+// Soft deleted data is not read unless WithDeleted or OnlyDeleted option is used
+func Get(conn dbflex.IConnection, model DataModel, opts ...ReadOption) error {
 	model.SetThis(model)
+	if e := checkMeta(conn, model); e != nil {
+		return e
+	}
 	tablename := model.TableName()
-	where := generateFilterFromDataModel(conn, model)
+	o := newReadOptions(opts)
+	where := andFilter(generateFilterFromDataModel(conn, model), deletedFilter(conn, model, o.deleted))
+	if where == nil {
+		where = new(dbflex.Filter)
+	}
 	cursor := conn.Cursor(dbflex.From(tablename).Select().Where(where), toolkit.M{})
 	defer cursor.Close()
 	e := cursor.Fetch(model).Error()
 	//fmt.Println(tablename, "where:", toolkit.JsonString(where), "model:", toolkit.JsonString(model))
-	return e
+	if e != nil {
+		return e
+	}
+	return afterRead(conn, model, model, o)
 	//return fmt.Errorf("asdada")
 }
 
-// GetWhere get a single datamodel
-func GetWhere(conn dbflex.IConnection, model DataModel, where *dbflex.Filter) error {
+// GetWhere get a single datamodel, soft deleted data is not read unless WithDeleted or OnlyDeleted option is used
+func GetWhere(conn dbflex.IConnection, model DataModel, where *dbflex.Filter, opts ...ReadOption) error {
 	model.SetThis(model)
+	if e := checkMeta(conn, model); e != nil {
+		return e
+	}
 	tablename := model.TableName()
+	o := newReadOptions(opts)
 	cmd := dbflex.From(tablename).Select().Take(1)
-	if where != nil {
+	if where = andFilter(where, deletedFilter(conn, model, o.deleted)); where != nil {
 		cmd.Where(where)
 	}
-	return conn.Cursor(cmd, nil).Fetch(model).Close()
+	if e := conn.Cursor(cmd, nil).Fetch(model).Close(); e != nil {
+		return e
+	}
+	return afterRead(conn, model, model, o)
 }
 
-// Gets multiple data from given connection, model, buffer, and query param
-func Gets(conn dbflex.IConnection, model DataModel, buffer interface{}, qp *dbflex.QueryParam) error {
+// Gets multiple data from given connection, model, buffer, and query param.
+// Soft deleted data is not read unless WithDeleted or OnlyDeleted option is used
+func Gets(conn dbflex.IConnection, model DataModel, buffer interface{}, qp *dbflex.QueryParam, opts ...ReadOption) error {
 	model.SetThis(model)
+	if e := checkMeta(conn, model); e != nil {
+		return e
+	}
 	tablename := model.TableName()
+	o := newReadOptions(opts)
 
 	if qp == nil {
 		qp = dbflex.NewQueryParam()
@@ -270,8 +219,8 @@ func Gets(conn dbflex.IConnection, model DataModel, buffer interface{}, qp *dbfl
 		cmd = cmd.Select(qp.Select...)
 	}
 	if qp != nil {
-		if qp.Where != nil {
-			cmd.Where(qp.Where)
+		if where := andFilter(qp.Where, deletedFilter(conn, model, o.deleted)); where != nil {
+			cmd.Where(where)
 		}
 
 		if len(qp.Sort) > 0 {
@@ -289,41 +238,136 @@ func Gets(conn dbflex.IConnection, model DataModel, buffer interface{}, qp *dbfl
 
 	cursor := conn.Cursor(cmd, nil)
 	defer cursor.Close()
-	return cursor.Fetchs(buffer, 0).Error()
+	if e := cursor.Fetchs(buffer, 0).Error(); e != nil {
+		return e
+	}
+	return afterRead(conn, model, buffer, o)
+}
+
+// afterRead apply read options to the data that has been read, data is the model or buffer of the model
+func afterRead(conn dbflex.IConnection, model DataModel, data interface{}, o *readOptions) error {
+	if len(o.preloads) > 0 {
+		if e := preload(conn, GetMeta(conn, model), reflect.ValueOf(data), o.preloads); e != nil {
+			return fmt.Errorf("dbflex %s.Preload %w", model.TableName(), e)
+		}
+	}
+	return nil
+}
+
+// Insert new data from given connection and data model.
+// The model is validated using its validation tags and Validate method, it fail with *ValidationError if it is not valid
+func Insert(conn dbflex.IConnection, dm DataModel, opts ...WriteOption) error {
+	return InsertContext(context.Background(), conn, dm, opts...)
 }
 
-// Insert new data from given connection and data model
-func Insert(conn dbflex.IConnection, dm DataModel) error {
+// InsertContext insert new data like Insert, actor of the context is set to created by and updated by fields
+func InsertContext(ctx context.Context, conn dbflex.IConnection, dm DataModel, opts ...WriteOption) error {
 	dm.SetThis(dm)
 	tablename := dm.TableName()
 
-	err := dm.PreSave(conn)
+	err := checkMeta(conn, dm)
+	if err != nil {
+		return err
+	}
+
+	err = dm.PreSave(conn)
+	if err != nil {
+		return fmt.Errorf("dbflex %s.PreSave %w", tablename, err)
+	}
+
+	// audit fields are stamped first, so they can be validated
+	err = stamp(conn, dm, ActorFromContext(ctx), true)
+	if err != nil {
+		return fmt.Errorf("dbflex %s.Stamp %w", tablename, err)
+	}
+
+	err = validate(conn, dm)
 	if err != nil {
-		return toolkit.Error(fmt.Sprint("dbflex ", tablename+".PreSave ", err.Error()))
+		return fmt.Errorf("dbflex %s.Validate %w", tablename, err)
 	}
 
 	err = checkFK(conn, dm)
 	if err != nil {
-		return toolkit.Error(fmt.Sprint("dbflex ", tablename+".FK ", err.Error()))
+		return fmt.Errorf("dbflex %s.FK %w", tablename, err)
 	}
 
-	_, err = conn.Execute(
+	out, err := conn.Execute(
 		dbflex.From(tablename).Insert(),
-		toolkit.M{}.Set("data", dm))
+		toolkit.M{}.Set("data", writeData(conn, dm, false)))
 
 	if err == nil {
+		newWriteOptions(opts).setResult(out)
 		err = dm.PostSave(conn)
 		if err != nil {
-			return toolkit.Error(fmt.Sprint("dbflex ", tablename+".PostSave ", err.Error()))
+			return fmt.Errorf("dbflex %s.PostSave %w", tablename, err)
 		}
 	}
 	return err
 }
 
-// Save will check if given DataModel filter is exist in the table or not
-// If exist then update the data if not insert new data
-func Save(conn dbflex.IConnection, dm DataModel) error {
+// Save insert the data or update it if data with the same keys is exist, using Save command of the driver in one round trip.
+// If the model has version, created or created by field, or OnUpdate action of the reverse FKs, the stored data is read first
+// to decide between Update and Insert since they can't be written by upsert
+func Save(conn dbflex.IConnection, dm DataModel, opts ...WriteOption) error {
+	return SaveContext(context.Background(), conn, dm, opts...)
+}
+
+// SaveContext save data like Save, actor of the context is set to created by and updated by fields
+func SaveContext(ctx context.Context, conn dbflex.IConnection, dm DataModel, opts ...WriteOption) error {
 	dm.SetThis(dm)
+	tablename := dm.TableName()
+	o := newWriteOptions(opts)
+	meta := GetMeta(conn, dm)
+	if err := checkMeta(conn, dm); err != nil {
+		return err
+	}
+
+	if meta.Version != nil || meta.hasInsertOnly() || hasOnUpdate(dm) {
+		return readAndSave(ctx, conn, dm, o)
+	}
+
+	err := dm.PreSave(conn)
+	if err != nil {
+		return fmt.Errorf("dbflex %s.PreSave %w", tablename, err)
+	}
+
+	err = stamp(conn, dm, ActorFromContext(ctx), true)
+	if err != nil {
+		return fmt.Errorf("dbflex %s.Stamp %w", tablename, err)
+	}
+
+	err = validate(conn, dm)
+	if err != nil {
+		return fmt.Errorf("dbflex %s.Validate %w", tablename, err)
+	}
+
+	err = checkFK(conn, dm)
+	if err != nil {
+		return fmt.Errorf("dbflex %s.FK %w", tablename, err)
+	}
+
+	out, err := conn.Execute(dbflex.From(tablename).Save(meta.KeyNames()...),
+		toolkit.M{}.Set("data", writeData(conn, dm, false)))
+	if err != nil {
+		return err
+	}
+	o.setResult(out)
+
+	err = dm.PostSave(conn)
+	if err != nil {
+		return fmt.Errorf("dbflex %s.PostSave %w", tablename, err)
+	}
+
+	err = updateReverseFK(conn, dm)
+	if err != nil {
+		return fmt.Errorf("dbflex %s.UpdReverseFK %w", tablename, err)
+	}
+
+	return nil
+}
+
+// readAndSave read the stored data of dm, then update it if it is exist or insert it if not
+func readAndSave(ctx context.Context, conn dbflex.IConnection, dm DataModel, o *writeOptions) error {
 	tablename := dm.TableName()
 	filter := generateFilterFromDataModel(conn, dm)
 
@@ -331,102 +375,234 @@ func Save(conn dbflex.IConnection, dm DataModel) error {
 	cursor := conn.Cursor(dbflex.From(tablename).Select().Where(filter), nil)
 	errexist := cursor.Fetch(&dmexist).Close()
 
+	if errexist == nil {
+		return inTx(conn, func() error {
+			out, err := updateModel(conn, dm, dmexist, newFKVisit(ctx), true)
+			if err == nil {
+				o.setResult(out)
+			}
+			return err
+		})
+	}
+
 	err := dm.PreSave(conn)
 	if err != nil {
-		return toolkit.Error(fmt.Sprint("dbflex ", tablename+".PreSave ", err.Error()))
+		return fmt.Errorf("dbflex %s.PreSave %w", tablename, err)
+	}
+
+	err = stamp(conn, dm, ActorFromContext(ctx), true)
+	if err != nil {
+		return fmt.Errorf("dbflex %s.Stamp %w", tablename, err)
+	}
+
+	err = validate(conn, dm)
+	if err != nil {
+		return fmt.Errorf("dbflex %s.Validate %w", tablename, err)
 	}
 
 	err = checkFK(conn, dm)
 	if err != nil {
-		return toolkit.Error(fmt.Sprint("dbflex ", tablename+".FK ", err.Error()))
+		return fmt.Errorf("dbflex %s.FK %w", tablename, err)
 	}
 
-	if errexist == nil {
-		_, err = conn.Execute(dbflex.From(tablename).Where(filter).Update(),
-			toolkit.M{}.Set("data", dm).Set("singleupdate", true))
-	} else {
-		_, err = conn.Execute(dbflex.From(tablename).Insert(),
-			toolkit.M{}.Set("data", dm))
+	out, err := conn.Execute(dbflex.From(tablename).Insert(),
+		toolkit.M{}.Set("data", writeData(conn, dm, false)))
+	if err != nil {
+		return err
 	}
+	o.setResult(out)
 
-	if err == nil {
-		err = dm.PostSave(conn)
-		if err != nil {
-			return toolkit.Error(fmt.Sprint("dbflex ", tablename+".PostSave ", err.Error()))
-		}
+	err = dm.PostSave(conn)
+	if err != nil {
+		return fmt.Errorf("dbflex %s.PostSave %w", tablename, err)
 	}
 
 	err = updateReverseFK(conn, dm)
 	if err != nil {
-		return toolkit.Error(fmt.Sprint("dbflex ", tablename+".UpdReverseFK ", err.Error()))
+		return fmt.Errorf("dbflex %s.UpdReverseFK %w", tablename, err)
 	}
 
-	return err
+	return nil
 }
 
 // Update  data from given connection and DataModel,
-// filter is generated from given DataModel
-func Update(conn dbflex.IConnection, dm DataModel) error {
+// filter is generated from given DataModel.
+// If the model has version field, update fail with dbflex.ErrVersionConflict when the stored data has different version.
+// OnUpdate action of the reverse FKs is applied in a transaction if the connection support it
+func Update(conn dbflex.IConnection, dm DataModel, opts ...WriteOption) error {
+	return UpdateContext(context.Background(), conn, dm, opts...)
+}
+
+// UpdateContext update data like Update, actor of the context is set to updated by field
+func UpdateContext(ctx context.Context, conn dbflex.IConnection, dm DataModel, opts ...WriteOption) error {
 	dm.SetThis(dm)
+	return inTx(conn, func() error {
+		out, err := updateModel(conn, dm, nil, newFKVisit(ctx), true)
+		if err == nil {
+			newWriteOptions(opts).setResult(out)
+		}
+		return err
+	})
+}
+
+// UpdateFields update only given fields of dm, fields can be struct field name or db name. Updated, updated by and version fields
+// of the model are also written. Only validation rules of given fields are checked, filter is generated from dm and OnUpdate action
+// of the reverse FKs is applied like Update
+func UpdateFields(conn dbflex.IConnection, dm DataModel, fields ...string) error {
+	dm.SetThis(dm)
+	if len(fields) == 0 {
+		return fmt.Errorf("dbflex %s.UpdateFields no field is given", dm.TableName())
+	}
+	return inTx(conn, func() error {
+		_, err := updateModel(conn, dm, nil, newFKVisit(context.Background()), true, fields...)
+		return err
+	})
+}
+
+// updateModel update dm and apply OnUpdate action of its reverse FKs, it return result of the update command. Old record is read
+// if it is nil and the reverse FKs has OnUpdate action, model is only validated and FK is only checked if checkRef is true. All writable fields are updated
+// if fields are not given
+func updateModel(conn dbflex.IConnection, dm DataModel, old toolkit.M, visited *fkVisit, checkRef bool, fields ...string) (interface{}, error) {
 	tablename := dm.TableName()
+	if !visited.visit(conn, dm, "update") {
+		return nil, nil
+	}
+	if err := checkMeta(conn, dm); err != nil {
+		return nil, err
+	}
 	filter := generateFilterFromDataModel(conn, dm)
 
-	err := dm.PreSave(conn)
+	updatedFields, err := GetMeta(conn, dm).updateFields(fields)
 	if err != nil {
-		return toolkit.Error(fmt.Sprint("dbflex ", tablename+".PreSave ", err.Error()))
+		return nil, fmt.Errorf("dbflex %s.UpdateFields %w", tablename, err)
 	}
 
-	err = checkFK(conn, dm)
+	err = dm.PreSave(conn)
 	if err != nil {
-		return toolkit.Error(fmt.Sprint("dbflex ", tablename+".FK ", err.Error()))
+		return nil, fmt.Errorf("dbflex %s.PreSave %w", tablename, err)
 	}
 
-	_, err = conn.Execute(
-		dbflex.From(tablename).Where(filter).Update(),
-		toolkit.M{}.Set("data", dm).Set("singleupdate", true))
+	err = stamp(conn, dm, visited.actor, false)
+	if err != nil {
+		return nil, fmt.Errorf("dbflex %s.Stamp %w", tablename, err)
+	}
 
-	if err == nil {
-		err = dm.PostSave(conn)
+	if checkRef {
+		err = validate(conn, dm, fields...)
 		if err != nil {
-			return toolkit.Error(fmt.Sprint("dbflex ", tablename+".PostSave ", err.Error()))
+			return nil, fmt.Errorf("dbflex %s.Validate %w", tablename, err)
+		}
+
+		err = checkFK(conn, dm)
+		if err != nil {
+			return nil, fmt.Errorf("dbflex %s.FK %w", tablename, err)
+		}
+	}
+
+	filter, undoVersion, err := checkVersion(conn, dm, filter)
+	if err != nil {
+		return nil, fmt.Errorf("dbflex %s.Version %w", tablename, err)
+	}
+
+	if old == nil && hasOnUpdate(dm) {
+		old = toolkit.M{}
+		if err = conn.Cursor(dbflex.From(tablename).Select().Where(filter), nil).Fetch(&old).Close(); err != nil {
+			old = nil
+		}
+	}
+	err = applyUpdateFK(conn, dm, old, visited)
+	if err != nil {
+		return nil, fmt.Errorf("dbflex %s.UpdateFK %w", tablename, err)
+	}
+
+	out, err := conn.Execute(
+		dbflex.From(tablename).Where(filter).Update(updatedFields...),
+		toolkit.M{}.Set("data", writeData(conn, dm, true)).Set("singleupdate", true))
+	if err != nil {
+		undoVersion()
+		return nil, err
+	}
+	// data could be changed by others after the version is checked
+	if res, ok := dbflex.ToExecResult(out); ok && res.RowsMatched == 0 {
+		if meta := GetMeta(conn, dm); meta.Version != nil {
+			undoVersion()
+			return nil, fmt.Errorf("dbflex %s.Version %w", tablename, versionConflict(dm, meta, meta.Values(dm, meta.Version)[0]))
 		}
 	}
 
+	err = dm.PostSave(conn)
+	if err != nil {
+		return nil, fmt.Errorf("dbflex %s.PostSave %w", tablename, err)
+	}
+
 	err = updateReverseFK(conn, dm)
 	if err != nil {
-		return toolkit.Error(fmt.Sprint("dbflex ", tablename+".UpdReverseFK ", err.Error()))
+		return nil, fmt.Errorf("dbflex %s.UpdReverseFK %w", tablename, err)
 	}
 
-	return err
+	return out, nil
 }
 
 // Delete data from given connection and DataModel,
-// filter is generated from given DataModel
-func Delete(conn dbflex.IConnection, dm DataModel) error {
+// filter is generated from given DataModel.
+// If the model has soft delete field, the field is set to current time instead of deleting the data, use HardDelete to delete it permanently.
+// OnDelete action of the reverse FKs is applied in a transaction if the connection support it
+func Delete(conn dbflex.IConnection, dm DataModel, opts ...WriteOption) error {
 	dm.SetThis(dm)
+	return inTx(conn, func() error {
+		out, err := deleteModel(conn, dm, newFKVisit(context.Background()))
+		if err == nil {
+			newWriteOptions(opts).setResult(out)
+		}
+		return err
+	})
+}
+
+// deleteModel delete or soft delete dm and apply OnDelete action of its reverse FKs, it return result of the delete command.
+// dm that is already deleted by the actions is skipped
+func deleteModel(conn dbflex.IConnection, dm DataModel, visited *fkVisit) (interface{}, error) {
 	tablename := dm.TableName()
+	if !visited.visit(conn, dm, "delete") {
+		return nil, nil
+	}
+	if err := checkMeta(conn, dm); err != nil {
+		return nil, err
+	}
 	filter := generateFilterFromDataModel(conn, dm)
 
 	err := dm.PreDelete(conn)
 	if err != nil {
-		return toolkit.Error(fmt.Sprint("dbflex ", tablename+".PreDelete ", err.Error()))
+		return nil, fmt.Errorf("dbflex %s.PreDelete %w", tablename, err)
 	}
 
-	err = checkEmptyFK(conn, dm)
+	err = checkEmptyFK(conn, dm, visited)
 	if err != nil {
-		return toolkit.Error(fmt.Sprint("dbflex ", tablename+".PreDelete ", err.Error()))
+		return nil, fmt.Errorf("dbflex %s.PreDelete %w", tablename, err)
 	}
 
-	_, err = conn.Execute(dbflex.From(tablename).Where(filter).Delete(), nil)
+	var out interface{}
+	if meta := GetMeta(conn, dm); meta.SoftDelete != nil && !visited.hardDelete {
+		out, err = softDelete(conn, dm, meta, filter)
+	} else {
+		out, err = conn.Execute(dbflex.From(tablename).Where(filter).Delete(), nil)
+	}
 
 	if err == nil {
 		err = dm.PostDelete(conn)
 		if err != nil {
-			return toolkit.Error(fmt.Sprint("dbflex ", tablename+".PostDelete ", err.Error()))
+			return nil, fmt.Errorf("dbflex %s.PostDelete %w", tablename, err)
 		}
 	}
 
-	return err
+	return out, err
+}
+
+// checkMeta return error of the model definition, model that has error can't be read or written
+func checkMeta(conn dbflex.IConnection, dm DataModel) error {
+	if err := GetMeta(conn, dm).Err(); err != nil {
+		return fmt.Errorf("dbflex %s.Meta %w", dm.TableName(), err)
+	}
+	return nil
 }
 
 func generateFilterFromDataModel(conn dbflex.IConnection, dm DataModel) *dbflex.Filter {
@@ -435,64 +611,31 @@ func generateFilterFromDataModel(conn dbflex.IConnection, dm DataModel) *dbflex.
 		return new(dbflex.Filter)
 	}
 
-	fieldNameTag := conn.FieldNameTag()
-	useTag := fieldNameTag != ""
-
-	if useTag {
-		vt := reflect.Indirect(reflect.ValueOf(dm)).Type()
-
-		eqs := []*dbflex.Filter{}
-		for idx, field := range fields {
-			if f, ok := vt.FieldByName(field); ok {
-				if tag, ok := f.Tag.Lookup(fieldNameTag); ok {
-					eqs = append(eqs, dbflex.Eq(strings.Split(tag, ",")[0], values[idx]))
-				} else {
-					eqs = append(eqs, dbflex.Eq(field, values[idx]))
-				}
-			} else {
-				eqs = append(eqs, dbflex.Eq(field, values[idx]))
-			}
-		}
-
-		if len(eqs) == 1 {
-			return eqs[0]
-		}
-
-		return dbflex.And(eqs...)
-	}
-
-	if len(fields) == 1 {
-		return dbflex.Eq(fields[0], values[0])
-	}
-
+	// GetID of a model could return struct field name instead of db name
+	meta := GetMeta(conn, dm)
 	eqs := []*dbflex.Filter{}
 	for idx, field := range fields {
+		if f := meta.Field(field); f != nil {
+			field = f.DbName
+		}
 		eqs = append(eqs, dbflex.Eq(field, values[idx]))
 	}
+
+	if len(eqs) == 1 {
+		return eqs[0]
+	}
 	return dbflex.And(eqs...)
 }
 
+// GetFieldName return db name of a field of obj using given tag, field name tag of the connection is used if tag is empty.
+// It return empty string if field is not exist
 func GetFieldName(obj interface{}, name, tag string, conn dbflex.IConnection) string {
-	if tag == "" {
-		tag = conn.FieldNameTag()
-	}
-
-	v := reflect.ValueOf(obj)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
-	t := v.Type()
-
-	if f, ok := t.FieldByName(name); !ok {
-		return ""
-	} else {
+	keyTag := "key"
+	if conn != nil {
+		keyTag = conn.KeyNameTag()
 		if tag == "" {
-			return f.Name
-		}
-		if tagName := f.Tag.Get(tag); tagName == "" {
-			return f.Name
-		} else {
-			return tagName
+			tag = conn.FieldNameTag()
 		}
 	}
+	return GetMetaWithTag(obj, tag, keyTag).DbName(name)
 }
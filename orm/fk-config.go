@@ -1,8 +1,10 @@
 package orm
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"reflect"
 
 	"git.kanosolution.net/kano/dbflex"
 	"github.com/eaciit/toolkit"
@@ -17,12 +19,65 @@ type FKConfig struct {
 	Map              toolkit.M
 }
 
+// FKAction is action to the child records of a reverse FK when the parent record is deleted or its FieldID is changed
+type FKAction string
+
+const (
+	// FKRestrict fail the delete or update if the parent still has child records, it is the default of OnDelete
+	FKRestrict FKAction = "restrict"
+	// FKCascade delete the child records on delete, or change their RefField to the new value on update
+	FKCascade FKAction = "cascade"
+	// FKSetNull set RefField of the child records to null
+	FKSetNull FKAction = "set_null"
+	// FKSetDefault set RefField of the child records to Default of the reverse FK
+	FKSetDefault FKAction = "set_default"
+	// FKNoAction leave the child records as they are, it is the default of OnUpdate
+	FKNoAction FKAction = "no_action"
+)
+
 type ReverseFKConfig struct {
 	FieldID      string
 	RefTableName string
 	RefField     string
-	AutoDelete   bool
-	Map          toolkit.M
+	// AutoDelete is the same as OnDelete FKCascade
+	AutoDelete bool
+	Map        toolkit.M
+
+	// OnDelete is action to the child records when the parent is deleted, default is FKRestrict or FKCascade if AutoDelete is true
+	OnDelete FKAction
+	// OnUpdate is action to the child records when FieldID of the parent is changed, default is FKNoAction
+	OnUpdate FKAction
+	// Default is value of RefField for FKSetDefault
+	Default interface{}
+	// Model is model of the child records. If it is set, child records are deleted or updated one by one using their DataModel,
+	// so their hooks and reverse FK are applied too. Otherwise they are changed using one command.
+	// Soft deleted child records of a Model are ignored
+	Model DataModel
+}
+
+func (fk *ReverseFKConfig) onDelete() FKAction {
+	if fk.OnDelete != "" {
+		return fk.OnDelete
+	}
+	if fk.AutoDelete {
+		return FKCascade
+	}
+	return FKRestrict
+}
+
+// notDeleted return filter that exclude soft deleted child records, it return nil if Model is not set or has no soft delete field
+func (fk *ReverseFKConfig) notDeleted(conn dbflex.IConnection) *dbflex.Filter {
+	if fk.Model == nil {
+		return nil
+	}
+	return deletedFilter(conn, fk.Model, excludeDeleted)
+}
+
+func (fk *ReverseFKConfig) onUpdate() FKAction {
+	if fk.OnUpdate != "" {
+		return fk.OnUpdate
+	}
+	return FKNoAction
 }
 
 func checkFK(conn dbflex.IConnection, dm DataModel) error {
@@ -39,7 +94,7 @@ func updateReverseFK(conn dbflex.IConnection, dm DataModel) error {
 	fks := dm.ReverseFK()
 	mSource, e := toolkit.ToM(dm.This())
 	if e != nil {
-		return fmt.Errorf("reverseFKErr: %s, %s", dm.TableName(), e.Error())
+		return fmt.Errorf("reverseFKErr: %s, %w", dm.TableName(), e)
 	}
 
 	for _, fk := range fks {
@@ -59,20 +114,230 @@ func updateReverseFK(conn dbflex.IConnection, dm DataModel) error {
 			}
 			cmd := dbflex.From(fk.RefTableName).Where(dbflex.Eq(fk.RefField, val)).Update(fields...)
 			if _, e := conn.Execute(cmd, toolkit.M{}.Set("data", mUpdate)); e != nil {
-				return fmt.Errorf("fkErr: %s, %s", fk.RefTableName, e.Error())
+				return fmt.Errorf("fkErr: %s, %w", fk.RefTableName, e)
 			}
 		}
 	}
 	return nil
 }
 
-func checkEmptyFK(conn dbflex.IConnection, dm DataModel) error {
+// checkEmptyFK apply OnDelete action of the reverse FKs before dm is deleted
+func checkEmptyFK(conn dbflex.IConnection, dm DataModel, visited *fkVisit) error {
 	fks := dm.ReverseFK()
+	if len(fks) == 0 {
+		return nil
+	}
+
+	mSource, e := toolkit.ToM(dm.This())
+	if e != nil {
+		return fmt.Errorf("fkErr: %s, %w", dm.TableName(), e)
+	}
 
 	for _, fk := range fks {
-		if e := ensureEmptyFK(conn, dm, fk.FieldID, fk.RefTableName, fk.RefField, fk.AutoDelete); e != nil {
+		keyValue := mSource.Get(fk.FieldID)
+		if isEmptyFKValue(keyValue) {
+			continue
+		}
+
+		var e error
+		switch fk.onDelete() {
+		case FKCascade:
+			e = deleteChildren(conn, fk, keyValue, visited)
+		case FKSetNull:
+			e = setChildrenRef(conn, fk, keyValue, nil, visited)
+		case FKSetDefault:
+			e = setChildrenRef(conn, fk, keyValue, fk.Default, visited)
+		case FKNoAction:
+		default:
+			e = ensureEmptyFK(conn, dm, fk.FieldID, fk.RefTableName, fk.RefField, false, fk.notDeleted(conn))
+		}
+		if e != nil {
 			return e
 		}
 	}
 	return nil
 }
+
+// hasOnUpdate return true if any reverse FK of dm has OnUpdate action
+func hasOnUpdate(dm DataModel) bool {
+	for _, fk := range dm.ReverseFK() {
+		if fk.onUpdate() != FKNoAction {
+			return true
+		}
+	}
+	return false
+}
+
+// applyUpdateFK apply OnUpdate action of the reverse FKs whose FieldID is changed from the old record
+func applyUpdateFK(conn dbflex.IConnection, dm DataModel, old toolkit.M, visited *fkVisit) error {
+	if old == nil {
+		return nil
+	}
+
+	mSource, e := toolkit.ToM(dm.This())
+	if e != nil {
+		return fmt.Errorf("fkErr: %s, %w", dm.TableName(), e)
+	}
+
+	for _, fk := range dm.ReverseFK() {
+		oldValue, newValue := old.Get(fk.FieldID), mSource.Get(fk.FieldID)
+		if isEmptyFKValue(oldValue) || fmt.Sprint(oldValue) == fmt.Sprint(newValue) {
+			continue
+		}
+
+		var e error
+		switch fk.onUpdate() {
+		case FKRestrict:
+			e = ensureEmptyFK(conn, toolkit.M{}.Set(fk.FieldID, oldValue), fk.FieldID, fk.RefTableName, fk.RefField, false,
+				fk.notDeleted(conn))
+		case FKCascade:
+			e = setChildrenRef(conn, fk, oldValue, newValue, visited)
+		case FKSetNull:
+			e = setChildrenRef(conn, fk, oldValue, nil, visited)
+		case FKSetDefault:
+			e = setChildrenRef(conn, fk, oldValue, fk.Default, visited)
+		}
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// deleteChildren delete child records of the reverse FK
+func deleteChildren(conn dbflex.IConnection, fk *ReverseFKConfig, keyValue interface{}, visited *fkVisit) error {
+	if fk.Model == nil {
+		cmdDel := dbflex.From(fk.RefTableName).Where(dbflex.Eq(fk.RefField, keyValue)).Delete()
+		if _, e := conn.Execute(cmdDel, nil); e != nil {
+			return fmt.Errorf("fkAutoDeleteErr: %s, %w", fk.RefTableName, e)
+		}
+		return nil
+	}
+
+	children, e := readChildren(conn, fk, keyValue)
+	if e != nil {
+		return e
+	}
+	for _, child := range children {
+		if _, e = deleteModel(conn, child, visited); e != nil {
+			return fmt.Errorf("fkAutoDeleteErr: %s, %w", fk.RefTableName, e)
+		}
+	}
+	return nil
+}
+
+// setChildrenRef change RefField of child records of the reverse FK from keyValue to value
+func setChildrenRef(conn dbflex.IConnection, fk *ReverseFKConfig, keyValue, value interface{}, visited *fkVisit) error {
+	if fk.Model == nil {
+		cmd := dbflex.From(fk.RefTableName).Where(dbflex.Eq(fk.RefField, keyValue)).Update(fk.RefField)
+		if _, e := conn.Execute(cmd, toolkit.M{}.Set("data", toolkit.M{}.Set(fk.RefField, value))); e != nil {
+			return fmt.Errorf("fkUpdateErr: %s, %w", fk.RefTableName, e)
+		}
+		return nil
+	}
+
+	children, e := readChildren(conn, fk, keyValue)
+	if e != nil {
+		return e
+	}
+	for _, child := range children {
+		meta := GetMeta(conn, child)
+		f := meta.lookup(fk.RefField)
+		if f == nil {
+			return fmt.Errorf("fkUpdateErr: %s, field %s is not exist", fk.RefTableName, fk.RefField)
+		}
+		if e = setFieldValue(reflect.Indirect(reflect.ValueOf(child)).FieldByIndex(f.Index), value); e != nil {
+			return fmt.Errorf("fkUpdateErr: %s, %w", fk.RefTableName, e)
+		}
+		if _, e = updateModel(conn, child, nil, visited, false); e != nil {
+			return fmt.Errorf("fkUpdateErr: %s, %w", fk.RefTableName, e)
+		}
+	}
+	return nil
+}
+
+// readChildren read child records of the reverse FK as its Model
+func readChildren(conn dbflex.IConnection, fk *ReverseFKConfig, keyValue interface{}) ([]DataModel, error) {
+	t := reflect.Indirect(reflect.ValueOf(fk.Model)).Type()
+	buffer := reflect.New(reflect.SliceOf(reflect.PtrTo(t)))
+	where := andFilter(dbflex.Eq(fk.RefField, keyValue), fk.notDeleted(conn))
+	cmd := dbflex.From(fk.RefTableName).Where(where).Select()
+	if e := conn.Cursor(cmd, nil).Fetchs(buffer.Interface(), 0).Close(); e != nil {
+		return nil, fmt.Errorf("fkErr: %s, %w", fk.RefTableName, e)
+	}
+
+	rv := buffer.Elem()
+	children := make([]DataModel, rv.Len())
+	for i := range children {
+		children[i] = rv.Index(i).Interface().(DataModel)
+		children[i].SetThis(children[i])
+	}
+	return children, nil
+}
+
+// setFieldValue set field with value, nil value set the field to its zero value
+func setFieldValue(field reflect.Value, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	v := reflect.ValueOf(value)
+	if field.Kind() == reflect.Ptr && v.Type().ConvertibleTo(field.Type().Elem()) {
+		ptr := reflect.New(field.Type().Elem())
+		ptr.Elem().Set(v.Convert(field.Type().Elem()))
+		field.Set(ptr)
+		return nil
+	}
+	if !v.Type().ConvertibleTo(field.Type()) {
+		return fmt.Errorf("%v can't be assigned to %s", value, field.Type())
+	}
+	field.Set(v.Convert(field.Type()))
+	return nil
+}
+
+func isEmptyFKValue(v interface{}) bool {
+	return v == nil || fmt.Sprint(v) == ""
+}
+
+// fkVisit is records that are already deleted or updated by FK actions, it is used to stop cycle of the actions
+type fkVisit struct {
+	visited map[string]bool
+	// hardDelete delete records permanently even if they have soft delete field
+	hardDelete bool
+	// actor is set to updated by field of the updated records
+	actor interface{}
+}
+
+func newFKVisit(ctx context.Context) *fkVisit {
+	return &fkVisit{visited: map[string]bool{}, actor: ActorFromContext(ctx)}
+}
+
+// visit mark dm as visited, it return false if dm is already visited
+func (v *fkVisit) visit(conn dbflex.IConnection, dm DataModel, action string) bool {
+	_, values := dm.GetID(conn)
+	key := action + ":" + dm.TableName() + ":" + fmt.Sprint(values...)
+	if v.visited[key] {
+		return false
+	}
+	v.visited[key] = true
+	return true
+}
+
+// inTx run fn in a transaction if the connection support it and it is not yet in a transaction
+func inTx(conn dbflex.IConnection, fn func() error) error {
+	if !conn.SupportTx() || conn.IsTx() {
+		return fn()
+	}
+
+	if e := conn.BeginTx(); e != nil {
+		return fmt.Errorf("dbflex BeginTx %w", e)
+	}
+	if e := fn(); e != nil {
+		if eRollback := conn.RollBack(); eRollback != nil {
+			return fmt.Errorf("%w, rollback fail: %s", e, eRollback.Error())
+		}
+		return e
+	}
+	return conn.Commit()
+}
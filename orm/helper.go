@@ -1,7 +1,7 @@
 package orm
 
 import (
-	"errors"
+	"fmt"
 
 	"git.kanosolution.net/kano/dbflex"
 	"github.com/ariefdarmawan/reflector"
@@ -12,7 +12,7 @@ func ensureFK(hub dbflex.IConnection, dm DataModel, fieldID, sourceTableName, so
 	rf := reflector.From(dm)
 	keyValue, e := rf.Get(fieldID)
 	if e != nil {
-		return errors.New("fkErr: " + sourceTableName + ", " + e.Error())
+		return fmt.Errorf("fkErr: %s, %w", sourceTableName, e)
 	}
 
 	if keyValue != "" {
@@ -26,10 +26,11 @@ func ensureFK(hub dbflex.IConnection, dm DataModel, fieldID, sourceTableName, so
 					mRef.Set(def, keyValue)
 				}
 				if _, eSave := hub.Execute(cmdSave, toolkit.M{}.Set("data", mRef)); eSave != nil {
-					return errors.New("fkErr: " + sourceTableName + ", " + eSave.Error())
+					return fmt.Errorf("fkErr: %s, %w", sourceTableName, eSave)
 				}
 			} else {
-				return errors.New("missingFK: " + sourceTableName)
+				return dbflex.NewError(dbflex.ErrFKViolation, "missingFK: "+sourceTableName, nil).
+					WithTable(sourceTableName).WithField(sourceField)
 			}
 		} else {
 			if refMap != nil {
@@ -44,24 +45,26 @@ func ensureFK(hub dbflex.IConnection, dm DataModel, fieldID, sourceTableName, so
 	return nil
 }
 
-func ensureEmptyFK(hub dbflex.IConnection, dm DataModel, fieldID, refTableName, refField string, autoDel bool) error {
+func ensureEmptyFK(hub dbflex.IConnection, dm interface{}, fieldID, refTableName, refField string, autoDel bool, conds ...*dbflex.Filter) error {
 	sourceM, e := toolkit.ToM(dm)
 	if e != nil {
-		return errors.New("fkErr: " + refTableName)
+		return fmt.Errorf("fkErr: %s, %w", refTableName, e)
 	}
 	keyValue := sourceM.GetString(fieldID)
 
 	if keyValue != "" {
-		cmd := dbflex.From(refTableName).Where(dbflex.Eq(refField, keyValue)).Select().Take(1)
+		where := andFilter(append([]*dbflex.Filter{dbflex.Eq(refField, keyValue)}, conds...)...)
+		cmd := dbflex.From(refTableName).Where(where).Select().Take(1)
 		refM := toolkit.M{}
 		if e = hub.Cursor(cmd, nil).Fetch(&refM).Error(); e == nil {
 			if !autoDel {
-				return errors.New("fkIsNotEmpty: " + refTableName)
+				return dbflex.NewError(dbflex.ErrFKNotEmpty, "fkIsNotEmpty: "+refTableName, nil).
+					WithTable(refTableName).WithField(refField)
 			}
 
 			cmdDel := dbflex.From(refTableName).Where(dbflex.Eq(refField, keyValue)).Delete()
 			if _, e := hub.Execute(cmdDel, nil); e != nil {
-				return errors.New("fkAutoDeleteErr: " + refTableName + ", " + e.Error())
+				return fmt.Errorf("fkAutoDeleteErr: %s, %w", refTableName, e)
 			}
 		}
 	}
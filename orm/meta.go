@@ -0,0 +1,415 @@
+package orm
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/eaciit/toolkit"
+)
+
+// MetaField is metadata of a model field
+type MetaField struct {
+	// Name is name of the struct field
+	Name string
+	// DbName is name of the field in the table, it is taken from the field name tag of the connection
+	DbName string
+	Type   reflect.Type
+	// Index is index sequence of the field for reflect.Value.FieldByIndex, field of embedded struct has more than one index
+	Index []int
+	Tag   reflect.StructTag
+
+	// Key is true if the field has key tag of the connection
+	Key bool
+	// OmitEmpty is true if the field name tag has omitempty option
+	OmitEmpty bool
+	// ReadOnly field is read from the table but never written, it is set using tag readonly:"1"
+	ReadOnly bool
+	// Computed field is calculated by the model and not stored in the table, it is set using tag computed:"1"
+	Computed bool
+	// Relation is true if the field hold related records of a relation, it is not stored in the table
+	Relation bool
+	// SoftDelete field hold deletion time of a soft deleted record, it is set using tag softdelete:"1" and should be *time.Time.
+	// Model that has soft delete field of other type has error and it can't be read or written
+	SoftDelete bool
+
+	// Created field is set to the insert time, it is set using tag created:"1"
+	Created bool
+	// Updated field is set to the insert and update time, it is set using tag updated:"1"
+	Updated bool
+	// CreatedBy field is set to the actor of the insert, it is set using tag createdby:"1"
+	CreatedBy bool
+	// UpdatedBy field is set to the actor of the insert and update, it is set using tag updatedby:"1"
+	UpdatedBy bool
+	// Version field is increased on each update and used for optimistic locking, it is set using tag version:"1" and should be integer
+	Version bool
+
+	// rules are validation rules of the field that are declared by tags
+	rules []*fieldRule
+}
+
+// Writable return true if the field should be written to the table
+func (f *MetaField) Writable() bool {
+	return !f.ReadOnly && !f.Computed && !f.Relation
+}
+
+// MetaIndex is index of a model. Fields that has the same unique tag value is one unique index,
+// field that has index tag is a non unique index
+type MetaIndex struct {
+	Name   string
+	Fields []string
+	Unique bool
+}
+
+// ModelMeta is metadata of a model type, it is parsed once for each model type and tags and shared by all orm functions
+type ModelMeta struct {
+	Type         reflect.Type
+	TableName    string
+	FieldNameTag string
+	KeyNameTag   string
+
+	Fields    []*MetaField
+	Keys      []*MetaField
+	Indexes   []*MetaIndex
+	FK        []*FKConfig
+	ReverseFK []*ReverseFKConfig
+	Relations []*Relation
+	// SoftDelete is the soft delete field, it is nil if the model is deleted permanently
+	SoftDelete *MetaField
+	// Version is the version field, it is nil if the model has no optimistic locking
+	Version *MetaField
+
+	byName   map[string]*MetaField
+	byDbName map[string]*MetaField
+	err      error
+}
+
+// Err return error of the model definition, e.g. soft delete field that is not *time.Time
+func (m *ModelMeta) Err() error {
+	if m == nil {
+		return nil
+	}
+	return m.err
+}
+
+// Field return metadata of given struct field name, it return nil if field is not exist
+func (m *ModelMeta) Field(name string) *MetaField {
+	if m == nil {
+		return nil
+	}
+	return m.byName[name]
+}
+
+// FieldByDbName return metadata of field that has given db name, it return nil if field is not exist
+func (m *ModelMeta) FieldByDbName(name string) *MetaField {
+	if m == nil {
+		return nil
+	}
+	if f, ok := m.byDbName[name]; ok {
+		return f
+	}
+	for dbName, f := range m.byDbName {
+		if strings.EqualFold(dbName, name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// DbName return db name of given struct field name, it return empty string if field is not exist
+func (m *ModelMeta) DbName(name string) string {
+	if f := m.Field(name); f != nil {
+		return f.DbName
+	}
+	return ""
+}
+
+// KeyNames return db name of the key fields
+func (m *ModelMeta) KeyNames() []string {
+	names := make([]string, len(m.Keys))
+	for i, f := range m.Keys {
+		names[i] = f.DbName
+	}
+	return names
+}
+
+// HasReadOnly return true if the model has field that is not writable
+func (m *ModelMeta) HasReadOnly() bool {
+	for _, f := range m.Fields {
+		if !f.Writable() {
+			return true
+		}
+	}
+	return false
+}
+
+// hasInsertOnly return true if the model has field that is only written on insert
+func (m *ModelMeta) hasInsertOnly() bool {
+	for _, f := range m.Fields {
+		if f.Created || f.CreatedBy {
+			return true
+		}
+	}
+	return false
+}
+
+// updateFields return db names of given fields to be updated with the updated, updated by and version fields of the model.
+// It return nil if no field is given, field should be writable
+func (m *ModelMeta) updateFields(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	res := []string{}
+	seen := map[string]bool{}
+	add := func(f *MetaField) {
+		if !seen[f.DbName] {
+			seen[f.DbName] = true
+			res = append(res, f.DbName)
+		}
+	}
+	for _, name := range names {
+		f := m.lookup(name)
+		if f == nil {
+			return nil, fmt.Errorf("field %s is not exist", name)
+		}
+		if !f.Writable() || f.Created || f.CreatedBy {
+			return nil, fmt.Errorf("field %s is not writable on update", name)
+		}
+		add(f)
+	}
+	for _, f := range m.Fields {
+		if f.Updated || f.UpdatedBy || f.Version {
+			add(f)
+		}
+	}
+	return res, nil
+}
+
+// Values return value of given fields of obj, obj should be the model type or pointer to it
+func (m *ModelMeta) Values(obj interface{}, fields ...*MetaField) []interface{} {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	values := make([]interface{}, len(fields))
+	for i, f := range fields {
+		values[i] = v.FieldByIndex(f.Index).Interface()
+	}
+	return values
+}
+
+// writeData return data of the model to be written. If the model has read only or computed field, data is M of the writable fields,
+// otherwise it is the model itself. Created and created by fields are not written on update
+func writeData(conn dbflex.IConnection, dm DataModel, update bool) interface{} {
+	meta := GetMeta(conn, dm)
+	if meta == nil || (!meta.HasReadOnly() && !(update && meta.hasInsertOnly())) {
+		return dm
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(dm))
+	m := toolkit.M{}
+	for _, f := range meta.Fields {
+		fv := v.FieldByIndex(f.Index)
+		if !f.Writable() || (update && (f.Created || f.CreatedBy)) || (f.OmitEmpty && fv.IsZero()) {
+			continue
+		}
+		m.Set(f.DbName, fv.Interface())
+	}
+	return m
+}
+
+type registryKey struct {
+	t        reflect.Type
+	fieldTag string
+	keyTag   string
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[registryKey]*ModelMeta{}
+)
+
+// GetMeta return metadata of the model using field name and key tag of the connection, connection can be nil to use
+// struct field name as db name. Model can be struct, pointer to struct or slice of them. It return nil if model is not a struct
+func GetMeta(conn dbflex.IConnection, model interface{}) *ModelMeta {
+	fieldTag, keyTag := "", "key"
+	if conn != nil {
+		fieldTag = conn.FieldNameTag()
+		keyTag = conn.KeyNameTag()
+	}
+	return GetMetaWithTag(model, fieldTag, keyTag)
+}
+
+// GetMetaWithTag return metadata of the model using given field name and key tag
+func GetMetaWithTag(model interface{}, fieldTag, keyTag string) *ModelMeta {
+	t, ok := model.(reflect.Type)
+	if !ok {
+		if model == nil {
+			return nil
+		}
+		t = reflect.TypeOf(model)
+	}
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	key := registryKey{t, fieldTag, keyTag}
+	registryLock.RLock()
+	meta, ok := registry[key]
+	registryLock.RUnlock()
+	if ok {
+		return meta
+	}
+
+	// parse it outside the lock, since model methods could use the registry too
+	meta = parseMeta(t, fieldTag, keyTag)
+
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if existing, ok := registry[key]; ok {
+		return existing
+	}
+	registry[key] = meta
+	return meta
+}
+
+// Models return metadata of all models that have been parsed, sorted by table name
+func Models() []*ModelMeta {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	res := make([]*ModelMeta, 0, len(registry))
+	for _, meta := range registry {
+		res = append(res, meta)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].TableName == res[j].TableName {
+			return res[i].Type.String() < res[j].Type.String()
+		}
+		return res[i].TableName < res[j].TableName
+	})
+	return res
+}
+
+func parseMeta(t reflect.Type, fieldTag, keyTag string) *ModelMeta {
+	meta := new(ModelMeta)
+	meta.Type = t
+	meta.FieldNameTag = fieldTag
+	meta.KeyNameTag = keyTag
+	meta.byName = map[string]*MetaField{}
+	meta.byDbName = map[string]*MetaField{}
+
+	meta.Fields = parseFields(t, nil, fieldTag, keyTag)
+	unique := map[string]*MetaIndex{}
+	for _, f := range meta.Fields {
+		meta.byName[f.Name] = f
+		meta.byDbName[f.DbName] = f
+		if f.Key {
+			meta.Keys = append(meta.Keys, f)
+		}
+		if f.SoftDelete && meta.SoftDelete == nil {
+			if f.Type != reflect.PtrTo(timeType) {
+				// zero time of non pointer field is written as a value, so not deleted record can't be filtered using null
+				meta.err = fmt.Errorf("softdelete field %s.%s should be *time.Time, please check your object definition", t.Name(), f.Name)
+			} else {
+				meta.SoftDelete = f
+			}
+		}
+		if f.Version && meta.Version == nil {
+			meta.Version = f
+		}
+
+		if name := f.Tag.Get("unique"); name != "" {
+			if isTrue(name) {
+				name = f.DbName
+			}
+			idx, ok := unique[name]
+			if !ok {
+				idx = &MetaIndex{Name: name, Unique: true}
+				unique[name] = idx
+				meta.Indexes = append(meta.Indexes, idx)
+			}
+			idx.Fields = append(idx.Fields, f.DbName)
+		}
+		if idx := f.Tag.Get("index"); idx != "" && idx != "-" {
+			meta.Indexes = append(meta.Indexes, &MetaIndex{Name: f.DbName, Fields: []string{f.DbName}})
+		}
+	}
+
+	if dm, ok := reflect.New(t).Interface().(DataModel); ok {
+		dm.SetThis(dm)
+		meta.TableName = dm.TableName()
+		meta.FK = dm.FK()
+		meta.ReverseFK = dm.ReverseFK()
+		meta.Relations = dm.Relations()
+		for _, r := range meta.Relations {
+			if f := meta.lookup(r.Field); f != nil {
+				f.Relation = true
+			}
+		}
+	}
+	return meta
+}
+
+// parseFields return exported fields of struct type, fields of embedded struct that has no name tag are promoted
+func parseFields(t reflect.Type, parent []int, fieldTag, keyTag string) []*MetaField {
+	fields := []*MetaField{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		index := append(append([]int{}, parent...), i)
+
+		tagParts := []string{""}
+		if fieldTag != "" {
+			tagParts = strings.Split(sf.Tag.Get(fieldTag), ",")
+		}
+		if tagParts[0] == "-" {
+			continue
+		}
+
+		if sf.Anonymous && tagParts[0] == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if sf.Type.Kind() != reflect.Ptr {
+					fields = append(fields, parseFields(ft, index, fieldTag, keyTag)...)
+				}
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		f := &MetaField{Name: sf.Name, DbName: sf.Name, Type: sf.Type, Index: index, Tag: sf.Tag}
+		if tagParts[0] != "" {
+			f.DbName = tagParts[0]
+		}
+		for _, opt := range tagParts[1:] {
+			if opt == "omitempty" {
+				f.OmitEmpty = true
+			}
+		}
+		f.Key = keyTag != "" && sf.Tag.Get(keyTag) != ""
+		f.ReadOnly = isTrue(sf.Tag.Get("readonly"))
+		f.Computed = isTrue(sf.Tag.Get("computed"))
+		f.SoftDelete = isTrue(sf.Tag.Get("softdelete"))
+		f.Created = isTrue(sf.Tag.Get("created"))
+		f.Updated = isTrue(sf.Tag.Get("updated"))
+		f.CreatedBy = isTrue(sf.Tag.Get("createdby"))
+		f.UpdatedBy = isTrue(sf.Tag.Get("updatedby"))
+		f.Version = isTrue(sf.Tag.Get("version"))
+		f.rules = parseRules(sf)
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func isTrue(tag string) bool {
+	return tag == "1" || strings.EqualFold(tag, "true")
+}
@@ -0,0 +1,738 @@
+package orm_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+	_ "git.kanosolution.net/kano/dbflex/drivers/json"
+	"git.kanosolution.net/kano/dbflex/orm"
+	"github.com/eaciit/toolkit"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var workpath = filepath.Join(os.TempDir(), "dbflex-orm")
+
+// connect return json connection of the orm test folder with the given tables emptied
+func connect(tables ...string) dbflex.IConnection {
+	So(os.MkdirAll(workpath, 0755), ShouldBeNil)
+	conn, err := dbflex.NewConnectionFromURI(toolkit.Sprintf("json://localhost/%s?extension=json", workpath), toolkit.M{})
+	So(err, ShouldBeNil)
+	So(conn.Connect(), ShouldBeNil)
+	for _, table := range tables {
+		conn.Execute(dbflex.From(table).Delete(), nil)
+	}
+	return conn
+}
+
+type metaEmployee struct {
+	orm.DataModelBase `json:"-"`
+	ID                string  `json:"_id" key:"1"`
+	Email             string  `json:"email" unique:"1"`
+	Dept              string  `json:"dept,omitempty" index:"1"`
+	Bonus             float64 `json:"bonus" readonly:"1"`
+	Label             string  `json:"-"`
+	note              string
+}
+
+func (e *metaEmployee) TableName() string {
+	return "orm-meta-employees"
+}
+
+func TestModelMeta(t *testing.T) {
+	Convey("Model metadata", t, func() {
+		conn := connect()
+		defer conn.Close()
+
+		meta := orm.GetMeta(conn, new(metaEmployee))
+		So(meta, ShouldNotBeNil)
+		So(meta.TableName, ShouldEqual, "orm-meta-employees")
+		So(meta.KeyNames(), ShouldResemble, []string{"_id"})
+		So(len(meta.Fields), ShouldEqual, 4)
+		So(meta.DbName("Email"), ShouldEqual, "email")
+		So(meta.FieldByDbName("DEPT").Name, ShouldEqual, "Dept")
+		So(meta.Field("Dept").OmitEmpty, ShouldBeTrue)
+		So(meta.Field("Bonus").Writable(), ShouldBeFalse)
+		So(meta.Field("Label"), ShouldBeNil)
+		So(meta.Field("note"), ShouldBeNil)
+		So(len(meta.Indexes), ShouldEqual, 2)
+		So(meta.Indexes[0].Unique, ShouldBeTrue)
+		So(meta.Indexes[1].Fields, ShouldResemble, []string{"dept"})
+
+		Convey("Model type is parsed once for each tag", func() {
+			metas := make([]*orm.ModelMeta, 10)
+			wg := sync.WaitGroup{}
+			for i := range metas {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					metas[i] = orm.GetMeta(conn, []metaEmployee{})
+				}(i)
+			}
+			wg.Wait()
+			for _, m := range metas {
+				So(m, ShouldEqual, meta)
+			}
+
+			So(orm.GetMeta(nil, metaEmployee{}), ShouldNotEqual, meta)
+			So(orm.GetMeta(nil, metaEmployee{}).DbName("Email"), ShouldEqual, "Email")
+			So(orm.Models(), ShouldContain, meta)
+		})
+	})
+}
+
+type preloadDept struct {
+	orm.DataModelBase `json:"-"`
+	ID                string        `json:"_id" key:"1"`
+	Name              string        `json:"name"`
+	Employees         []*preloadEmp `json:"employees,omitempty"`
+}
+
+func (d *preloadDept) TableName() string {
+	return "orm-preload-depts"
+}
+
+func (d *preloadDept) Relations() []*orm.Relation {
+	return []*orm.Relation{
+		{Field: "Employees", Type: orm.HasMany, Model: new(preloadEmp), ForeignField: "DeptID"},
+	}
+}
+
+type preloadEmp struct {
+	orm.DataModelBase `json:"-"`
+	ID                string           `json:"_id" key:"1"`
+	DeptID            string           `json:"deptid"`
+	Dept              *preloadDept     `json:"dept,omitempty"`
+	Projects          []preloadProject `json:"projects,omitempty"`
+}
+
+func (e *preloadEmp) TableName() string {
+	return "orm-preload-emps"
+}
+
+func (e *preloadEmp) Relations() []*orm.Relation {
+	return []*orm.Relation{
+		{Field: "Dept", Type: orm.BelongsTo, Model: new(preloadDept), LocalField: "DeptID"},
+		{Field: "Projects", Type: orm.ManyToMany, Model: new(preloadProject),
+			JoinTable: "orm-preload-empprojects", JoinLocalField: "empid", JoinForeignField: "projectid"},
+	}
+}
+
+type preloadProject struct {
+	orm.DataModelBase `json:"-"`
+	ID                string `json:"_id" key:"1"`
+	Name              string `json:"name"`
+}
+
+func (p *preloadProject) TableName() string {
+	return "orm-preload-projects"
+}
+
+func TestPreload(t *testing.T) {
+	Convey("Preload relations", t, func() {
+		conn := connect("orm-preload-depts", "orm-preload-emps", "orm-preload-projects", "orm-preload-empprojects")
+		defer conn.Close()
+
+		records := []orm.DataModel{
+			&preloadDept{ID: "IT", Name: "Information Technology"},
+			&preloadDept{ID: "HR", Name: "Human Resource"},
+			&preloadEmp{ID: "E1", DeptID: "IT"},
+			&preloadEmp{ID: "E2", DeptID: "IT"},
+			&preloadEmp{ID: "E3", DeptID: "HR"},
+			&preloadProject{ID: "P1", Name: "Payroll"},
+			&preloadProject{ID: "P2", Name: "Portal"},
+		}
+		for _, r := range records {
+			So(orm.Insert(conn, r), ShouldBeNil)
+		}
+		for _, pair := range [][]string{{"E1", "P1"}, {"E1", "P2"}, {"E3", "P1"}} {
+			_, err := conn.Execute(dbflex.From("orm-preload-empprojects").Insert(),
+				toolkit.M{}.Set("data", toolkit.M{}.Set("_id", pair[0]+pair[1]).Set("empid", pair[0]).Set("projectid", pair[1])))
+			So(err, ShouldBeNil)
+		}
+
+		Convey("Has many and nested many to many", func() {
+			depts := []preloadDept{}
+			So(orm.Gets(conn, new(preloadDept), &depts, nil, orm.Preload("Employees.Projects")), ShouldBeNil)
+			So(len(depts), ShouldEqual, 2)
+
+			projects := map[string]int{}
+			for _, d := range depts {
+				for _, e := range d.Employees {
+					So(e.DeptID, ShouldEqual, d.ID)
+					projects[e.ID] = len(e.Projects)
+				}
+			}
+			So(projects, ShouldResemble, map[string]int{"E1": 2, "E2": 0, "E3": 1})
+		})
+
+		Convey("Belongs to", func() {
+			emp := &preloadEmp{ID: "E3"}
+			So(orm.Get(conn, emp, orm.Preload("Dept")), ShouldBeNil)
+			So(emp.Dept, ShouldNotBeNil)
+			So(emp.Dept.Name, ShouldEqual, "Human Resource")
+
+			So(orm.Get(conn, emp, orm.Preload("Unknown")), ShouldNotBeNil)
+		})
+	})
+}
+
+// deleted is table and ID of records deleted through their model, in order
+var deleted []string
+
+type cascadeDept struct {
+	orm.DataModelBase `json:"-"`
+	ID                string `json:"_id" key:"1"`
+}
+
+func (d *cascadeDept) TableName() string {
+	return "orm-cascade-depts"
+}
+
+func (d *cascadeDept) ReverseFK() []*orm.ReverseFKConfig {
+	return []*orm.ReverseFKConfig{
+		{FieldID: "_id", RefTableName: "orm-cascade-emps", RefField: "deptid", OnDelete: orm.FKCascade, Model: new(cascadeEmp)},
+	}
+}
+
+type cascadeEmp struct {
+	orm.DataModelBase `json:"-"`
+	ID                string `json:"_id" key:"1"`
+	DeptID            string `json:"deptid"`
+}
+
+func (e *cascadeEmp) TableName() string {
+	return "orm-cascade-emps"
+}
+
+func (e *cascadeEmp) FK() []*orm.FKConfig {
+	return []*orm.FKConfig{{FieldID: "DeptID", RefTableName: "orm-cascade-depts", RefField: "_id"}}
+}
+
+func (e *cascadeEmp) ReverseFK() []*orm.ReverseFKConfig {
+	return []*orm.ReverseFKConfig{
+		{FieldID: "_id", RefTableName: "orm-cascade-tasks", RefField: "empid", OnDelete: orm.FKSetNull},
+		{FieldID: "_id", RefTableName: "orm-cascade-leaves", RefField: "empid"},
+	}
+}
+
+func (e *cascadeEmp) PostDelete(conn dbflex.IConnection) error {
+	deleted = append(deleted, e.TableName()+":"+e.ID)
+	return nil
+}
+
+type cascadeNode struct {
+	orm.DataModelBase `json:"-"`
+	ID                string `json:"_id" key:"1"`
+	Parent            string `json:"parent"`
+}
+
+func (n *cascadeNode) TableName() string {
+	return "orm-cascade-nodes"
+}
+
+func (n *cascadeNode) ReverseFK() []*orm.ReverseFKConfig {
+	return []*orm.ReverseFKConfig{
+		{FieldID: "_id", RefTableName: "orm-cascade-nodes", RefField: "parent", OnDelete: orm.FKCascade, Model: new(cascadeNode)},
+	}
+}
+
+func (n *cascadeNode) PostDelete(conn dbflex.IConnection) error {
+	deleted = append(deleted, n.TableName()+":"+n.ID)
+	return nil
+}
+
+func TestCascade(t *testing.T) {
+	Convey("FK actions", t, func() {
+		conn := connect("orm-cascade-depts", "orm-cascade-emps", "orm-cascade-tasks", "orm-cascade-leaves", "orm-cascade-nodes")
+		defer conn.Close()
+		deleted = []string{}
+
+		So(orm.Insert(conn, &cascadeDept{ID: "IT"}), ShouldBeNil)
+		So(orm.Insert(conn, &cascadeEmp{ID: "E1", DeptID: "IT"}), ShouldBeNil)
+		So(orm.Insert(conn, &cascadeEmp{ID: "E2", DeptID: "IT"}), ShouldBeNil)
+		_, err := conn.Execute(dbflex.From("orm-cascade-tasks").Insert(),
+			toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "T1").Set("empid", "E1")))
+		So(err, ShouldBeNil)
+
+		err = orm.Insert(conn, &cascadeEmp{ID: "E3", DeptID: "HR"})
+		So(errors.Is(err, dbflex.ErrFKViolation), ShouldBeTrue)
+
+		Convey("Delete cascade through the child models", func() {
+			So(orm.Delete(conn, &cascadeDept{ID: "IT"}), ShouldBeNil)
+			So(deleted, ShouldResemble, []string{"orm-cascade-emps:E1", "orm-cascade-emps:E2"})
+
+			emps := []cascadeEmp{}
+			So(orm.Gets(conn, new(cascadeEmp), &emps, nil), ShouldBeNil)
+			So(len(emps), ShouldEqual, 0)
+
+			task := toolkit.M{}
+			So(conn.Cursor(dbflex.From("orm-cascade-tasks").Select(), nil).Fetch(&task).Close(), ShouldBeNil)
+			So(task.Get("empid"), ShouldBeNil)
+		})
+
+		Convey("Restrict fail the whole delete", func() {
+			_, err := conn.Execute(dbflex.From("orm-cascade-leaves").Insert(),
+				toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "L1").Set("empid", "E2")))
+			So(err, ShouldBeNil)
+
+			err = orm.Delete(conn, &cascadeDept{ID: "IT"})
+			So(errors.Is(err, dbflex.ErrFKNotEmpty), ShouldBeTrue)
+			var dbErr *dbflex.Error
+			So(errors.As(err, &dbErr), ShouldBeTrue)
+			So(dbErr.Table, ShouldEqual, "orm-cascade-leaves")
+		})
+
+		Convey("Cycle of cascade delete each record once", func() {
+			So(orm.Insert(conn, &cascadeNode{ID: "N1", Parent: "N2"}), ShouldBeNil)
+			So(orm.Insert(conn, &cascadeNode{ID: "N2", Parent: "N1"}), ShouldBeNil)
+
+			So(orm.Delete(conn, &cascadeNode{ID: "N1"}), ShouldBeNil)
+			// children are deleted before their parent, N1 is already visited when it is the child of N2
+			So(deleted, ShouldResemble, []string{"orm-cascade-nodes:N2", "orm-cascade-nodes:N1"})
+			nodes := []cascadeNode{}
+			So(orm.Gets(conn, new(cascadeNode), &nodes, nil), ShouldBeNil)
+			So(len(nodes), ShouldEqual, 0)
+		})
+	})
+}
+
+type softEmp struct {
+	orm.DataModelBase `json:"-"`
+	ID                string     `json:"_id" key:"1"`
+	Name              string     `json:"name"`
+	DeletedAt         *time.Time `json:"deletedAt,omitempty" softdelete:"1"`
+}
+
+func (e *softEmp) TableName() string {
+	return "orm-soft-emps"
+}
+
+type softEmpValue struct {
+	orm.DataModelBase `json:"-"`
+	ID                string    `json:"_id" key:"1"`
+	DeletedAt         time.Time `json:"deletedAt" softdelete:"1"`
+}
+
+func TestSoftDelete(t *testing.T) {
+	Convey("Soft delete", t, func() {
+		conn := connect("orm-soft-emps")
+		defer conn.Close()
+
+		So(orm.Insert(conn, &softEmp{ID: "E1", Name: "Ann"}), ShouldBeNil)
+		So(orm.Insert(conn, &softEmp{ID: "E2", Name: "Bob"}), ShouldBeNil)
+		// record written before the model has soft delete field has no such field
+		_, err := conn.Execute(dbflex.From("orm-soft-emps").Insert(), toolkit.M{}.Set("data", toolkit.M{}.Set("_id", "E3").Set("name", "Cid")))
+		So(err, ShouldBeNil)
+
+		count := func(opts ...orm.ReadOption) int {
+			buffer := []softEmp{}
+			So(orm.Gets(conn, new(softEmp), &buffer, nil, opts...), ShouldBeNil)
+			return len(buffer)
+		}
+		So(count(), ShouldEqual, 3)
+		So(count(orm.OnlyDeleted()), ShouldEqual, 0)
+
+		So(orm.Delete(conn, &softEmp{ID: "E1"}), ShouldBeNil)
+		So(count(), ShouldEqual, 2)
+		So(count(orm.OnlyDeleted()), ShouldEqual, 1)
+		So(count(orm.WithDeleted()), ShouldEqual, 3)
+
+		So(orm.Get(conn, &softEmp{ID: "E1"}), ShouldNotBeNil)
+		emp := &softEmp{ID: "E1"}
+		So(orm.Get(conn, emp, orm.WithDeleted()), ShouldBeNil)
+		So(emp.DeletedAt, ShouldNotBeNil)
+		So(emp.Name, ShouldEqual, "Ann")
+
+		Convey("Restore", func() {
+			So(orm.Restore(conn, emp), ShouldBeNil)
+			So(emp.DeletedAt, ShouldBeNil)
+			So(count(), ShouldEqual, 3)
+			So(orm.Get(conn, &softEmp{ID: "E1"}), ShouldBeNil)
+		})
+
+		Convey("Hard delete", func() {
+			So(orm.HardDelete(conn, &softEmp{ID: "E2"}), ShouldBeNil)
+			So(orm.HardDelete(conn, &softEmp{ID: "E1"}), ShouldBeNil)
+			So(count(orm.WithDeleted()), ShouldEqual, 1)
+		})
+
+		Convey("Soft delete field should be pointer to time", func() {
+			So(orm.GetMeta(conn, new(softEmpValue)).Err(), ShouldNotBeNil)
+			So(orm.Insert(conn, &softEmpValue{ID: "E4"}), ShouldNotBeNil)
+			So(orm.Update(conn, &softEmpValue{ID: "E1"}), ShouldNotBeNil)
+			So(orm.Delete(conn, &softEmpValue{ID: "E1"}), ShouldNotBeNil)
+			So(orm.Get(conn, &softEmpValue{ID: "E1"}), ShouldNotBeNil)
+			So(count(orm.WithDeleted()), ShouldEqual, 3)
+		})
+	})
+}
+
+type auditDoc struct {
+	orm.DataModelBase `json:"-"`
+	ID                string    `json:"_id" key:"1"`
+	Title             string    `json:"title"`
+	Version           int       `json:"version" version:"1"`
+	Created           time.Time `json:"created" created:"1"`
+	Updated           time.Time `json:"updated" updated:"1"`
+	CreatedBy         string    `json:"createdby" createdby:"1"`
+	UpdatedBy         string    `json:"updatedby" updatedby:"1"`
+}
+
+func (d *auditDoc) TableName() string {
+	return "orm-audit-docs"
+}
+
+type requiredAuditDoc struct {
+	orm.DataModelBase `json:"-"`
+	ID                string    `json:"_id" key:"1"`
+	Version           int       `json:"version" version:"1" required:"1"`
+	Created           time.Time `json:"created" created:"1" required:"1"`
+	Updated           time.Time `json:"updated" updated:"1" required:"1"`
+	CreatedBy         string    `json:"createdby" createdby:"1" required:"1"`
+}
+
+func (d *requiredAuditDoc) TableName() string {
+	return "orm-audit-docs"
+}
+
+func TestAuditAndVersion(t *testing.T) {
+	Convey("Audit fields and optimistic locking", t, func() {
+		conn := connect("orm-audit-docs")
+		defer conn.Close()
+
+		doc := &auditDoc{ID: "D1", Title: "Draft"}
+		So(orm.InsertContext(orm.WithActor(nil, "ann"), conn, doc), ShouldBeNil)
+		So(doc.Version, ShouldEqual, 1)
+		So(doc.Created.IsZero(), ShouldBeFalse)
+		So(doc.CreatedBy, ShouldEqual, "ann")
+		So(doc.UpdatedBy, ShouldEqual, "ann")
+
+		stale := &auditDoc{ID: "D1"}
+		So(orm.Get(conn, stale), ShouldBeNil)
+		So(stale.Version, ShouldEqual, 1)
+
+		doc.Title = "Posted"
+		doc.CreatedBy = "bob"
+		So(orm.UpdateContext(orm.WithActor(nil, "bob"), conn, doc), ShouldBeNil)
+		So(doc.Version, ShouldEqual, 2)
+
+		stored := &auditDoc{ID: "D1"}
+		So(orm.Get(conn, stored), ShouldBeNil)
+		So(stored.Title, ShouldEqual, "Posted")
+		So(stored.Version, ShouldEqual, 2)
+		So(stored.CreatedBy, ShouldEqual, "ann")
+		So(stored.UpdatedBy, ShouldEqual, "bob")
+
+		Convey("Update of stale version fail", func() {
+			stale.Title = "Stale"
+			err := orm.Update(conn, stale)
+			So(errors.Is(err, dbflex.ErrVersionConflict), ShouldBeTrue)
+			So(stale.Version, ShouldEqual, 1)
+		})
+
+		Convey("Update that match nothing after the version is checked fail", func() {
+			changed := false
+			conn.AddInterceptor(func(info *dbflex.InterceptInfo, next dbflex.InterceptHandler) error {
+				if _, isUpdate := info.Command.Items()[dbflex.QueryUpdate]; isUpdate && info.Action == dbflex.InterceptExecute && !changed {
+					// simulate update of others between the version check and the update
+					changed = true
+					_, err := conn.Execute(dbflex.From("orm-audit-docs").Where(dbflex.Eq("_id", "D1")).Update("version"),
+						toolkit.M{}.Set("data", toolkit.M{}.Set("version", 3)))
+					So(err, ShouldBeNil)
+				}
+				return next(info)
+			})
+
+			doc.Title = "Concurrent"
+			res := new(dbflex.ExecResult)
+			err := orm.Update(conn, doc, orm.WithResult(res))
+			So(changed, ShouldBeTrue)
+			So(errors.Is(err, dbflex.ErrVersionConflict), ShouldBeTrue)
+			So(doc.Version, ShouldEqual, 2)
+		})
+
+		Convey("Audit fields are stamped before they are validated", func() {
+			req := &requiredAuditDoc{ID: "D2"}
+			So(orm.InsertContext(orm.WithActor(nil, "ann"), conn, req), ShouldBeNil)
+			So(req.Version, ShouldEqual, 1)
+			So(orm.SaveContext(orm.WithActor(nil, "ann"), conn, &requiredAuditDoc{ID: "D3"}), ShouldBeNil)
+			So(orm.Update(conn, req), ShouldBeNil)
+			So(req.Version, ShouldEqual, 2)
+
+			err := orm.Insert(conn, &requiredAuditDoc{ID: "D4"})
+			So(errors.Is(err, dbflex.ErrValidation), ShouldBeTrue)
+			So(err.Error(), ShouldEndWith, "createdby is required")
+		})
+	})
+}
+
+type resultEmp struct {
+	orm.DataModelBase `json:"-"`
+	ID                string `json:"_id" key:"1"`
+	Name              string `json:"name"`
+}
+
+func (e *resultEmp) TableName() string {
+	return "orm-result-emps"
+}
+
+func TestWithResult(t *testing.T) {
+	Convey("Result of the write command", t, func() {
+		conn := connect("orm-result-emps")
+		defer conn.Close()
+
+		res := dbflex.ExecResult{}
+		So(orm.Insert(conn, &resultEmp{ID: "E1", Name: "Ann"}, orm.WithResult(&res)), ShouldBeNil)
+		So(res.RowsAffected, ShouldEqual, 1)
+		So(res.InsertedIDs, ShouldResemble, []interface{}{"E1"})
+
+		res = dbflex.ExecResult{}
+		So(orm.Update(conn, &resultEmp{ID: "E1", Name: "Bob"}, orm.WithResult(&res)), ShouldBeNil)
+		So(res.RowsMatched, ShouldEqual, 1)
+		So(res.RowsAffected, ShouldEqual, 1)
+
+		res = dbflex.ExecResult{}
+		So(orm.Update(conn, &resultEmp{ID: "E2", Name: "Bob"}, orm.WithResult(&res)), ShouldBeNil)
+		So(res.RowsMatched, ShouldEqual, 0)
+
+		res = dbflex.ExecResult{}
+		So(orm.Delete(conn, &resultEmp{ID: "E1"}, orm.WithResult(&res)), ShouldBeNil)
+		So(res.RowsAffected, ShouldEqual, 1)
+
+		Convey("Write without result option", func() {
+			So(orm.Insert(conn, &resultEmp{ID: "E3", Name: "Cid"}), ShouldBeNil)
+		})
+	})
+}
+
+func TestSave(t *testing.T) {
+	Convey("Save insert new data then update it", t, func() {
+		conn := connect("orm-result-emps", "orm-audit-docs")
+		defer conn.Close()
+
+		emp := &resultEmp{ID: "E1", Name: "Ann"}
+		So(orm.Save(conn, emp), ShouldBeNil)
+		emp.Name = "Bob"
+		So(orm.Save(conn, emp), ShouldBeNil)
+
+		emps := []resultEmp{}
+		So(orm.Gets(conn, new(resultEmp), &emps, nil), ShouldBeNil)
+		So(len(emps), ShouldEqual, 1)
+		So(emps[0].Name, ShouldEqual, "Bob")
+
+		Convey("Save of model with version read the stored data", func() {
+			doc := &auditDoc{ID: "D1", Title: "Draft"}
+			So(orm.SaveContext(orm.WithActor(nil, "ann"), conn, doc), ShouldBeNil)
+			So(doc.Version, ShouldEqual, 1)
+
+			doc.Title = "Posted"
+			So(orm.SaveContext(orm.WithActor(nil, "bob"), conn, doc), ShouldBeNil)
+			So(doc.Version, ShouldEqual, 2)
+
+			docs := []auditDoc{}
+			So(orm.Gets(conn, new(auditDoc), &docs, nil), ShouldBeNil)
+			So(len(docs), ShouldEqual, 1)
+			So(docs[0].Title, ShouldEqual, "Posted")
+			So(docs[0].CreatedBy, ShouldEqual, "ann")
+			So(docs[0].UpdatedBy, ShouldEqual, "bob")
+		})
+	})
+}
+
+type fieldsEmp struct {
+	orm.DataModelBase `json:"-"`
+	ID                string    `json:"_id" key:"1"`
+	Name              string    `json:"name"`
+	Grade             int       `json:"grade"`
+	Bonus             float64   `json:"bonus" readonly:"1"`
+	Updated           time.Time `json:"updated" updated:"1"`
+}
+
+func (e *fieldsEmp) TableName() string {
+	return "orm-fields-emps"
+}
+
+func TestUpdateFields(t *testing.T) {
+	Convey("Update only given fields", t, func() {
+		conn := connect("orm-fields-emps")
+		defer conn.Close()
+
+		emp := &fieldsEmp{ID: "E1", Name: "Ann", Grade: 1}
+		So(orm.Insert(conn, emp), ShouldBeNil)
+		inserted := emp.Updated
+
+		emp.Name = "Bob"
+		emp.Grade = 2
+		So(orm.UpdateFields(conn, emp, "Name"), ShouldBeNil)
+
+		stored := &fieldsEmp{ID: "E1"}
+		So(orm.Get(conn, stored), ShouldBeNil)
+		So(stored.Name, ShouldEqual, "Bob")
+		So(stored.Grade, ShouldEqual, 1)
+		So(stored.Updated.Before(inserted), ShouldBeFalse)
+
+		Convey("Field can be given by its db name", func() {
+			So(orm.UpdateFields(conn, emp, "grade"), ShouldBeNil)
+			So(orm.Get(conn, stored), ShouldBeNil)
+			So(stored.Grade, ShouldEqual, 2)
+		})
+
+		Convey("Unknown, read only or no field fail", func() {
+			So(orm.UpdateFields(conn, emp, "Unknown"), ShouldNotBeNil)
+			So(orm.UpdateFields(conn, emp, "Bonus"), ShouldNotBeNil)
+			So(orm.UpdateFields(conn, emp), ShouldNotBeNil)
+		})
+	})
+}
+
+type validEmp struct {
+	orm.DataModelBase `json:"-"`
+	ID                string `json:"_id" key:"1" required:"1" length:"2"`
+	Name              string `json:"name" required:"1" length:",10"`
+	Email             string `json:"email" email:"1"`
+	Grade             int    `json:"grade" min:"1" max:"5"`
+	Status            string `json:"status" enum:"draft,posted"`
+}
+
+func (e *validEmp) TableName() string {
+	return "orm-valid-emps"
+}
+
+func (e *validEmp) Validate() error {
+	if e.Status == "posted" && e.Grade < 3 {
+		return errors.New("posted employee should have grade 3 or more")
+	}
+	return nil
+}
+
+func fieldRules(err error) []string {
+	verr := new(orm.ValidationError)
+	So(errors.As(err, &verr), ShouldBeTrue)
+	rules := make([]string, len(verr.Fields))
+	for i, f := range verr.Fields {
+		rules[i] = f.Field + ":" + f.Rule
+	}
+	return rules
+}
+
+func TestValidation(t *testing.T) {
+	Convey("Model is validated before it is written", t, func() {
+		conn := connect("orm-valid-emps")
+		defer conn.Close()
+
+		err := orm.Insert(conn, &validEmp{ID: "E", Email: "ann", Grade: 9, Status: "sent"})
+		So(errors.Is(err, dbflex.ErrValidation), ShouldBeTrue)
+		So(fieldRules(err), ShouldResemble, []string{"_id:length", "name:required", "email:email", "grade:max", "status:enum"})
+
+		emp := &validEmp{ID: "E1", Name: "Ann", Email: "ann@example.com", Grade: 1, Status: "posted"}
+		err = orm.Insert(conn, emp)
+		So(errors.Is(err, dbflex.ErrValidation), ShouldBeTrue)
+		So(fieldRules(err), ShouldResemble, []string{":validate"})
+
+		emp.Status = "draft"
+		So(orm.Insert(conn, emp), ShouldBeNil)
+
+		Convey("Update fields only check the given fields", func() {
+			emp.Name = "Ann Marie Walker"
+			emp.Grade = 0
+			err := orm.UpdateFields(conn, emp, "Grade")
+			So(errors.Is(err, dbflex.ErrValidation), ShouldBeTrue)
+			So(fieldRules(err), ShouldResemble, []string{"grade:min"})
+
+			emp.Grade = 2
+			So(orm.UpdateFields(conn, emp, "Grade"), ShouldBeNil)
+			err = orm.Update(conn, emp)
+			So(fieldRules(err), ShouldResemble, []string{"name:length"})
+		})
+	})
+}
+
+type whereEmp struct {
+	orm.DataModelBase `json:"-"`
+	ID                int       `json:"_id" key:"1"`
+	Name              string    `json:"name"`
+	Grade             int       `json:"grade"`
+	Bonus             float64   `json:"bonus" readonly:"1"`
+	Total             float64   `json:"total" computed:"1"`
+	Joined            time.Time `json:"joined"`
+	Code              string    `json:"code" pk:"1"`
+}
+
+func (e *whereEmp) TableName() string {
+	return "orm-where-emps"
+}
+
+func TestWhereFilter(t *testing.T) {
+	Convey("Where filter of the model", t, func() {
+		conn := connect()
+		defer conn.Close()
+
+		emp := &whereEmp{Name: "Ann", Total: 20}
+		So(orm.WhereFilter(conn, emp, toolkit.M{}.Set("grade", "3").Set("Bonus", 10).Set("total", 30)), ShouldResemble,
+			dbflex.And(dbflex.Eq("name", "Ann"), dbflex.Eq("grade", 3), dbflex.Eq("bonus", float64(10))))
+
+		Convey("GetWhereFilter use json tag", func() {
+			emp.SetThis(emp)
+			So(emp.GetWhereFilter(toolkit.M{}.Set("Grade", float64(2))), ShouldResemble,
+				dbflex.And(dbflex.Eq("name", "Ann"), dbflex.Eq("grade", 2)))
+			So(emp.GetWhereFilter(toolkit.M{}.Set(orm.WhereFieldTag, "")), ShouldResemble,
+				dbflex.And(dbflex.Eq("Name", "Ann")))
+		})
+
+		Convey("Contains and range of time", func() {
+			from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			to := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+			filter := orm.WhereFilter(conn, emp, toolkit.M{}.
+				Set(orm.WhereContains, []string{"name"}).
+				Set("Joined", []interface{}{from.Format(time.RFC3339), to}))
+			So(filter, ShouldResemble, dbflex.And(dbflex.Contains("name", "Ann"), dbflex.Range("joined", from, to)))
+
+			filter = orm.WhereFilter(conn, emp, toolkit.M{}.Set("Name", "").Set("Joined", []time.Time{{}, to}))
+			So(filter, ShouldResemble, dbflex.And(dbflex.Lte("joined", to)))
+		})
+
+		Convey("Number that has fraction is not truncated", func() {
+			filter := orm.WhereFilter(conn, emp, toolkit.M{}.Set("Grade", 2.5))
+			So(filter, ShouldResemble, dbflex.And(dbflex.Eq("name", "Ann"), dbflex.Eq("grade", 2.5)))
+		})
+	})
+}
+
+func TestSetID(t *testing.T) {
+	Convey("SetID convert value to the key type", t, func() {
+		emp := new(whereEmp)
+		emp.SetThis(emp)
+
+		emp.SetID("5")
+		So(emp.ID, ShouldEqual, 5)
+		emp.SetID(float64(7))
+		So(emp.ID, ShouldEqual, 7)
+		emp.SetID(nil)
+		So(emp.ID, ShouldEqual, 0)
+
+		So(func() { emp.SetID("seven") }, ShouldNotPanic)
+		So(emp.ID, ShouldEqual, 0)
+
+		So(orm.SetID(nil, emp, 7.5), ShouldNotBeNil)
+		So(orm.SetID(nil, emp, "7.5"), ShouldNotBeNil)
+		So(orm.SetID(nil, emp, "seven"), ShouldNotBeNil)
+		So(orm.SetID(nil, emp, "8"), ShouldBeNil)
+		So(emp.ID, ShouldEqual, 8)
+
+		Convey("Use key tag of the connection", func() {
+			conn := connect()
+			defer conn.Close()
+			conn.SetKeyNameTag("pk")
+
+			So(orm.SetID(conn, emp, 9), ShouldBeNil)
+			So(emp.Code, ShouldEqual, "9")
+			So(emp.ID, ShouldEqual, 8)
+			So(orm.SetID(conn, new(resultEmp), 9), ShouldNotBeNil)
+		})
+	})
+}
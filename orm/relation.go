@@ -0,0 +1,319 @@
+package orm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/eaciit/toolkit"
+)
+
+// RelationType is type of relation between 2 models
+type RelationType string
+
+const (
+	// BelongsTo is relation where this model has field that refer to key of the related model
+	BelongsTo RelationType = "belongs_to"
+	// HasOne is relation where the related model has field that refer to key of this model, only one record is loaded
+	HasOne RelationType = "has_one"
+	// HasMany is relation where the related model has field that refer to key of this model
+	HasMany RelationType = "has_many"
+	// ManyToMany is relation through a join table that has fields refer to key of both models
+	ManyToMany RelationType = "many_to_many"
+)
+
+// Relation is relation of a model to another model, related records are loaded into Field when the relation is preloaded.
+// Field names of the relation can be struct field name or db name
+type Relation struct {
+	// Name is used by Preload, default is Field
+	Name string
+	// Field is struct field that hold the related records. It is struct or pointer for BelongsTo and HasOne, slice for HasMany and ManyToMany
+	Field string
+	Type  RelationType
+	// Model is the related model
+	Model DataModel
+
+	// LocalField is field of this model. It is the reference field for BelongsTo and the key for others, default is the first key
+	LocalField string
+	// ForeignField is field of the related model. It is the key for BelongsTo and ManyToMany, default is the first key,
+	// and the reference field for HasOne and HasMany
+	ForeignField string
+
+	// JoinTable is table that join both models of ManyToMany relation
+	JoinTable string
+	// JoinLocalField is field of the join table that refer to LocalField
+	JoinLocalField string
+	// JoinForeignField is field of the join table that refer to ForeignField
+	JoinForeignField string
+}
+
+func (r *Relation) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Field
+}
+
+// Relation return relation of the model with given name, it return nil if relation is not exist
+func (m *ModelMeta) Relation(name string) *Relation {
+	if m == nil {
+		return nil
+	}
+	for _, r := range m.Relations {
+		if r.name() == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// lookup return field that has given struct field name or db name
+func (m *ModelMeta) lookup(name string) *MetaField {
+	if f := m.Field(name); f != nil {
+		return f
+	}
+	return m.FieldByDbName(name)
+}
+
+// firstKey return the first key field, it return nil if model has no key
+func (m *ModelMeta) firstKey() *MetaField {
+	if len(m.Keys) == 0 {
+		return nil
+	}
+	return m.Keys[0]
+}
+
+// ReadOption is optional configuration of orm read functions
+type ReadOption func(*readOptions)
+
+type readOptions struct {
+	preloads []string
+	deleted  deletedMode
+}
+
+func newReadOptions(opts []ReadOption) *readOptions {
+	o := new(readOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Preload load related records of given relations after the data is read. Nested relation is separated by dot, e.g. "Orders.Items",
+// and it also load its parent relation. Each relation of each level is loaded using one query
+func Preload(relations ...string) ReadOption {
+	return func(o *readOptions) {
+		o.preloads = append(o.preloads, relations...)
+	}
+}
+
+// preload load relations into records, records can be a model, pointer to model or slice of them
+func preload(conn dbflex.IConnection, meta *ModelMeta, records reflect.Value, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	items := structValues(records)
+	if len(items) == 0 {
+		return nil
+	}
+	if items[0].Type() != meta.Type {
+		return fmt.Errorf("preload require buffer of %s, got %s", meta.Type.Name(), items[0].Type().Name())
+	}
+
+	// group nested paths by their first relation, keeping the order
+	names := []string{}
+	children := map[string][]string{}
+	for _, p := range paths {
+		parts := strings.SplitN(p, ".", 2)
+		if _, ok := children[parts[0]]; !ok {
+			names = append(names, parts[0])
+			children[parts[0]] = []string{}
+		}
+		if len(parts) > 1 {
+			children[parts[0]] = append(children[parts[0]], parts[1])
+		}
+	}
+
+	for _, name := range names {
+		rel := meta.Relation(name)
+		if rel == nil {
+			return fmt.Errorf("relation %s is not exist in %s", name, meta.Type.Name())
+		}
+		if err := loadRelation(conn, meta, rel, items, children[name]); err != nil {
+			return fmt.Errorf("preload %s.%s: %w", meta.Type.Name(), name, err)
+		}
+	}
+	return nil
+}
+
+func loadRelation(conn dbflex.IConnection, meta *ModelMeta, rel *Relation, items []reflect.Value, paths []string) error {
+	if rel.Model == nil {
+		return fmt.Errorf("model of the relation is not set")
+	}
+	relMeta := GetMeta(conn, rel.Model)
+	target := meta.lookup(rel.Field)
+	if target == nil {
+		return fmt.Errorf("field %s is not exist", rel.Field)
+	}
+
+	local, foreign := meta.firstKey(), relMeta.firstKey()
+	switch rel.Type {
+	case BelongsTo:
+		local = nil
+	case HasOne, HasMany:
+		foreign = nil
+	}
+	if rel.LocalField != "" {
+		local = meta.lookup(rel.LocalField)
+	}
+	if rel.ForeignField != "" {
+		foreign = relMeta.lookup(rel.ForeignField)
+	}
+	if local == nil || foreign == nil {
+		return fmt.Errorf("local and foreign field of the relation should be set")
+	}
+
+	// values of local field, and related value of each of them for many to many relation
+	localValues := distinctValues(items, local)
+	if len(localValues) == 0 {
+		return nil
+	}
+	joined := map[string][]string{}
+	foreignValues := localValues
+	if rel.Type == ManyToMany {
+		rows := []toolkit.M{}
+		cmd := dbflex.From(rel.JoinTable).Select().Where(dbflex.In(rel.JoinLocalField, localValues...))
+		if err := conn.Cursor(cmd, nil).Fetchs(&rows, 0).Close(); err != nil {
+			return fmt.Errorf("unable to read %s. %w", rel.JoinTable, err)
+		}
+
+		foreignValues = []interface{}{}
+		seen := map[string]bool{}
+		for _, row := range rows {
+			lk, fv := valueKey(row.Get(rel.JoinLocalField)), row.Get(rel.JoinForeignField)
+			fk := valueKey(fv)
+			joined[lk] = append(joined[lk], fk)
+			if !seen[fk] {
+				seen[fk] = true
+				foreignValues = append(foreignValues, fv)
+			}
+		}
+		if len(foreignValues) == 0 {
+			return nil
+		}
+	}
+
+	// read related records and load their relations
+	buffer := reflect.New(reflect.SliceOf(relMeta.Type))
+	cmd := dbflex.From(relMeta.TableName).Select().Where(dbflex.In(foreign.DbName, foreignValues...))
+	if err := conn.Cursor(cmd, nil).Fetchs(buffer.Interface(), 0).Close(); err != nil {
+		return fmt.Errorf("unable to read %s. %w", relMeta.TableName, err)
+	}
+	related := buffer.Elem()
+	if err := preload(conn, relMeta, related, paths); err != nil {
+		return err
+	}
+
+	byForeign := map[string][]reflect.Value{}
+	for i := 0; i < related.Len(); i++ {
+		rv := related.Index(i)
+		k := valueKey(rv.FieldByIndex(foreign.Index).Interface())
+		byForeign[k] = append(byForeign[k], rv)
+	}
+
+	for _, item := range items {
+		lk := valueKey(item.FieldByIndex(local.Index).Interface())
+		matches := byForeign[lk]
+		if rel.Type == ManyToMany {
+			matches = []reflect.Value{}
+			for _, fk := range joined[lk] {
+				matches = append(matches, byForeign[fk]...)
+			}
+		}
+		if err := setRelated(item.FieldByIndex(target.Index), matches); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setRelated set field with the related records, field can be slice, struct or pointer of the related model
+func setRelated(field reflect.Value, matches []reflect.Value) error {
+	switch field.Kind() {
+	case reflect.Slice:
+		res := reflect.MakeSlice(field.Type(), 0, len(matches))
+		for _, m := range matches {
+			if field.Type().Elem().Kind() == reflect.Ptr {
+				m = m.Addr()
+			}
+			res = reflect.Append(res, m)
+		}
+		field.Set(res)
+
+	case reflect.Ptr:
+		if len(matches) == 0 {
+			field.Set(reflect.Zero(field.Type()))
+		} else {
+			field.Set(matches[0].Addr())
+		}
+
+	case reflect.Struct:
+		if len(matches) == 0 {
+			field.Set(reflect.Zero(field.Type()))
+		} else {
+			field.Set(matches[0])
+		}
+
+	default:
+		return fmt.Errorf("field of relation should be slice, struct or pointer, got %s", field.Type())
+	}
+	return nil
+}
+
+// structValues return addressable struct values of a model, pointer to model or slice of them
+func structValues(v reflect.Value) []reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Struct {
+		return []reflect.Value{v}
+	}
+	if v.Kind() != reflect.Slice {
+		return nil
+	}
+
+	res := []reflect.Value{}
+	for i := 0; i < v.Len(); i++ {
+		res = append(res, structValues(v.Index(i).Addr())...)
+	}
+	return res
+}
+
+// distinctValues return distinct non zero values of a field
+func distinctValues(items []reflect.Value, f *MetaField) []interface{} {
+	res := []interface{}{}
+	seen := map[string]bool{}
+	for _, item := range items {
+		fv := item.FieldByIndex(f.Index)
+		if fv.IsZero() {
+			continue
+		}
+		k := valueKey(fv.Interface())
+		if !seen[k] {
+			seen[k] = true
+			res = append(res, fv.Interface())
+		}
+	}
+	return res
+}
+
+// valueKey return text of a value to match values from different sources, e.g. int field and float64 value of decoded JSON
+func valueKey(v interface{}) string {
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,37 @@
+package orm
+
+import (
+	"git.kanosolution.net/kano/dbflex"
+)
+
+// WriteOption is optional configuration of orm write functions
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	result *dbflex.ExecResult
+}
+
+func newWriteOptions(opts []WriteOption) *writeOptions {
+	o := new(writeOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithResult set res to the result of the command that write the data, e.g. to check if update matched nothing.
+// Commands of FK actions are not included. Result is empty if the driver doesn't return dbflex.ExecResult
+func WithResult(res *dbflex.ExecResult) WriteOption {
+	return func(o *writeOptions) {
+		o.result = res
+	}
+}
+
+// setResult set the result option with result of Execute
+func (o *writeOptions) setResult(out interface{}) {
+	if o.result == nil {
+		return
+	}
+	res, _ := dbflex.ToExecResult(out)
+	*o.result = *res
+}
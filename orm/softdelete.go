@@ -0,0 +1,122 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/eaciit/toolkit"
+)
+
+// deletedMode decide which records of soft delete model are read
+type deletedMode int
+
+const (
+	excludeDeleted deletedMode = iota
+	includeDeleted
+	onlyDeleted
+)
+
+// WithDeleted read soft deleted records too
+func WithDeleted() ReadOption {
+	return func(o *readOptions) {
+		o.deleted = includeDeleted
+	}
+}
+
+// OnlyDeleted only read soft deleted records
+func OnlyDeleted() ReadOption {
+	return func(o *readOptions) {
+		o.deleted = onlyDeleted
+	}
+}
+
+// deletedFilter return condition of soft delete field according to the mode, it return nil if all records should be read.
+// Record that doesn't have the field is not deleted, driver should treat missing field as null
+func deletedFilter(conn dbflex.IConnection, model interface{}, mode deletedMode) *dbflex.Filter {
+	meta := GetMeta(conn, model)
+	if meta == nil || meta.SoftDelete == nil {
+		return nil
+	}
+
+	switch mode {
+	case excludeDeleted:
+		return dbflex.Eq(meta.SoftDelete.DbName, nil)
+	case onlyDeleted:
+		return dbflex.Ne(meta.SoftDelete.DbName, nil)
+	}
+	return nil
+}
+
+// andFilter join filters that are not nil or empty
+func andFilter(filters ...*dbflex.Filter) *dbflex.Filter {
+	items := []*dbflex.Filter{}
+	for _, f := range filters {
+		if f != nil && (f.Op != "" || f.Field != "") {
+			items = append(items, f)
+		}
+	}
+
+	switch len(items) {
+	case 0:
+		return nil
+	case 1:
+		return items[0]
+	}
+	return dbflex.And(items...)
+}
+
+// Restore undelete a soft deleted record by setting its soft delete field to null
+func Restore(conn dbflex.IConnection, dm DataModel, opts ...WriteOption) error {
+	dm.SetThis(dm)
+	tablename := dm.TableName()
+	meta := GetMeta(conn, dm)
+	if err := checkMeta(conn, dm); err != nil {
+		return err
+	}
+	if meta.SoftDelete == nil {
+		return fmt.Errorf("dbflex %s.Restore model has no soft delete field", tablename)
+	}
+
+	field := reflect.Indirect(reflect.ValueOf(dm)).FieldByIndex(meta.SoftDelete.Index)
+	field.Set(reflect.Zero(field.Type()))
+
+	cmd := dbflex.From(tablename).Where(generateFilterFromDataModel(conn, dm)).Update(meta.SoftDelete.DbName)
+	out, e := conn.Execute(cmd, toolkit.M{}.Set("data", dbflex.NewUpdate().Unset(meta.SoftDelete.DbName)))
+	if e != nil {
+		return fmt.Errorf("dbflex %s.Restore %w", tablename, e)
+	}
+	newWriteOptions(opts).setResult(out)
+	return nil
+}
+
+// HardDelete delete data permanently even if the model has soft delete field,
+// child records that are deleted by OnDelete action of the reverse FKs are deleted permanently too
+func HardDelete(conn dbflex.IConnection, dm DataModel, opts ...WriteOption) error {
+	dm.SetThis(dm)
+	visited := newFKVisit(context.Background())
+	visited.hardDelete = true
+	return inTx(conn, func() error {
+		out, err := deleteModel(conn, dm, visited)
+		if err == nil {
+			newWriteOptions(opts).setResult(out)
+		}
+		return err
+	})
+}
+
+// softDelete set soft delete field of dm to the current time, time of record that is already soft deleted is kept.
+// It is written using update document so the field is added to record that doesn't have it
+func softDelete(conn dbflex.IConnection, dm DataModel, meta *ModelMeta, filter *dbflex.Filter) (interface{}, error) {
+	field := reflect.Indirect(reflect.ValueOf(dm)).FieldByIndex(meta.SoftDelete.Index)
+	if field.IsZero() {
+		if e := setFieldValue(field, time.Now()); e != nil {
+			return nil, e
+		}
+	}
+
+	cmd := dbflex.From(dm.TableName()).Where(filter).Update(meta.SoftDelete.DbName)
+	return conn.Execute(cmd, toolkit.M{}.Set("data", dbflex.NewUpdate().Set(meta.SoftDelete.DbName, reflect.Indirect(field).Interface())))
+}
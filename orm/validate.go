@@ -0,0 +1,269 @@
+package orm
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"git.kanosolution.net/kano/dbflex"
+)
+
+// FieldError is a validation rule that fail on a field
+type FieldError struct {
+	// Field is db name of the field, it is empty if the error is returned by Validate method of the model for the whole model
+	Field string
+	// Rule is the failed rule, it is the tag name of the rule or "validate" for Validate method of the model
+	Rule string
+	Msg  string
+}
+
+// ValidationError is returned by Insert, Update and Save when the model is not valid, it list every failing field.
+// It can be checked using errors.Is against dbflex.ErrValidation and errors.As to get the fields
+type ValidationError struct {
+	Table  string
+	Fields []*FieldError
+}
+
+// Error return error message
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Msg
+		if f.Field != "" {
+			msgs[i] = f.Field + " " + f.Msg
+		}
+	}
+	return fmt.Sprintf("%s is not valid: %s", e.Table, strings.Join(msgs, "; "))
+}
+
+// Is return true if target is dbflex.ErrValidation
+func (e *ValidationError) Is(target error) bool {
+	return target == dbflex.ErrValidation
+}
+
+// fieldRule is validation rule of a field that is declared by tag
+type fieldRule struct {
+	name  string
+	arg   string
+	check func(v reflect.Value) string
+}
+
+// validationTags are tags of the validation rules, in order they are checked
+var validationTags = []string{"required", "min", "max", "length", "regex", "enum", "email"}
+
+// parseRules return validation rules of a field. Rule is declared using tag, e.g. required:"1", min:"0", max:"100", length:"2,50",
+// regex:"^[A-Z]+$", enum:"draft,posted" and email:"1". Length can be exact length or "min,max" where either of them can be empty
+func parseRules(sf reflect.StructField) []*fieldRule {
+	rules := []*fieldRule{}
+	for _, name := range validationTags {
+		arg, ok := sf.Tag.Lookup(name)
+		if !ok || arg == "" || arg == "-" {
+			continue
+		}
+		r := &fieldRule{name: name, arg: arg}
+		switch name {
+		case "required":
+			if !isTrue(arg) {
+				continue
+			}
+			r.check = checkRequired
+		case "min", "max":
+			r.check = checkRange(name, arg)
+		case "length":
+			r.check = checkLength(arg)
+		case "regex":
+			r.check = checkRegex(arg)
+		case "enum":
+			r.check = checkEnum(arg)
+		case "email":
+			if !isTrue(arg) {
+				continue
+			}
+			r.check = checkEmail
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// validate check validation rules of the fields of dm and its Validate method. If fields are given, only rules of them are checked
+// and Validate method is not called. It return *ValidationError if any of them fail
+func validate(conn dbflex.IConnection, dm DataModel, fields ...string) error {
+	meta := GetMeta(conn, dm)
+	v := reflect.Indirect(reflect.ValueOf(dm))
+	verr := &ValidationError{Table: dm.TableName()}
+
+	checked := meta.Fields
+	if len(fields) > 0 {
+		checked = []*MetaField{}
+		for _, name := range fields {
+			if f := meta.lookup(name); f != nil {
+				checked = append(checked, f)
+			}
+		}
+	}
+
+	for _, f := range checked {
+		fv := v.FieldByIndex(f.Index)
+		for _, r := range f.rules {
+			// other rules of empty field are only checked if it is required
+			if r.name != "required" && isEmptyValue(fv) {
+				continue
+			}
+			if msg := r.check(fv); msg != "" {
+				verr.Fields = append(verr.Fields, &FieldError{Field: f.DbName, Rule: r.name, Msg: msg})
+				// the next rules of missing field would only repeat it
+				if r.name == "required" {
+					break
+				}
+			}
+		}
+	}
+
+	if validator, ok := dm.(interface{ Validate() error }); ok && len(fields) == 0 {
+		if err := validator.Validate(); err != nil {
+			var modelErr *ValidationError
+			if errors.As(err, &modelErr) {
+				verr.Fields = append(verr.Fields, modelErr.Fields...)
+			} else {
+				verr.Fields = append(verr.Fields, &FieldError{Rule: "validate", Msg: err.Error()})
+			}
+		}
+	}
+
+	if len(verr.Fields) == 0 {
+		return nil
+	}
+	return verr
+}
+
+// isEmptyValue return true if the value is nil pointer, empty text or empty slice
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return false
+}
+
+func checkRequired(v reflect.Value) string {
+	if v.IsZero() || isEmptyValue(v) {
+		return "is required"
+	}
+	return ""
+}
+
+func checkRange(name, arg string) func(reflect.Value) string {
+	limit, err := strconv.ParseFloat(arg, 64)
+	return func(v reflect.Value) string {
+		if err != nil {
+			return fmt.Sprintf("has invalid %s rule %s", name, arg)
+		}
+		n, ok := numberOf(v)
+		if !ok {
+			return fmt.Sprintf("should be a number to check %s", name)
+		}
+		if name == "min" && n < limit {
+			return fmt.Sprintf("should be at least %s", arg)
+		}
+		if name == "max" && n > limit {
+			return fmt.Sprintf("should be at most %s", arg)
+		}
+		return ""
+	}
+}
+
+func checkLength(arg string) func(reflect.Value) string {
+	parts := strings.SplitN(arg, ",", 2)
+	bounds := make([]int, 2)
+	var err error
+	for i, p := range parts {
+		if p = strings.TrimSpace(p); p == "" {
+			bounds[i] = -1
+			continue
+		}
+		if bounds[i], err = strconv.Atoi(p); err != nil {
+			break
+		}
+	}
+	exact := len(parts) == 1
+	return func(v reflect.Value) string {
+		if err != nil {
+			return fmt.Sprintf("has invalid length rule %s", arg)
+		}
+		v = reflect.Indirect(v)
+		var length int
+		switch v.Kind() {
+		case reflect.String:
+			length = len([]rune(v.String()))
+		case reflect.Slice, reflect.Map, reflect.Array:
+			length = v.Len()
+		default:
+			return "should be text or array to check length"
+		}
+
+		switch {
+		case exact && length != bounds[0]:
+			return fmt.Sprintf("should have length %d", bounds[0])
+		case !exact && bounds[0] >= 0 && length < bounds[0]:
+			return fmt.Sprintf("should have length at least %d", bounds[0])
+		case !exact && bounds[1] >= 0 && length > bounds[1]:
+			return fmt.Sprintf("should have length at most %d", bounds[1])
+		}
+		return ""
+	}
+}
+
+func checkRegex(arg string) func(reflect.Value) string {
+	re, err := regexp.Compile(arg)
+	return func(v reflect.Value) string {
+		if err != nil {
+			return fmt.Sprintf("has invalid regex rule %s", arg)
+		}
+		if !re.MatchString(fmt.Sprint(reflect.Indirect(v).Interface())) {
+			return fmt.Sprintf("should match %s", arg)
+		}
+		return ""
+	}
+}
+
+func checkEnum(arg string) func(reflect.Value) string {
+	values := strings.Split(arg, ",")
+	return func(v reflect.Value) string {
+		txt := fmt.Sprint(reflect.Indirect(v).Interface())
+		for _, value := range values {
+			if strings.TrimSpace(value) == txt {
+				return ""
+			}
+		}
+		return fmt.Sprintf("should be one of %s", strings.Join(values, ", "))
+	}
+}
+
+func checkEmail(v reflect.Value) string {
+	txt := fmt.Sprint(reflect.Indirect(v).Interface())
+	if addr, err := mail.ParseAddress(txt); err != nil || addr.Address != txt {
+		return "should be a valid email"
+	}
+	return ""
+}
+
+// numberOf return number of integer or float value
+func numberOf(v reflect.Value) (float64, bool) {
+	v = reflect.Indirect(v)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
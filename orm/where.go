@@ -0,0 +1,277 @@
+package orm
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.kanosolution.net/kano/dbflex"
+	"github.com/eaciit/toolkit"
+)
+
+const (
+	// WhereContains is option of GetWhereFilter to filter string fields using Contains instead of Eq.
+	// Its value is true for all string fields, or list of struct field names or db names
+	WhereContains = "$contains"
+	// WhereFieldTag is option of GetWhereFilter to set field name tag of the filter, it should be FieldNameTag of the connection.
+	// If it is not set, field name tag of the connection is used by WhereFilter and json is used by GetWhereFilter
+	WhereFieldTag = "$fieldtag"
+
+	// defaultFieldTag and defaultKeyTag are the default field name tag and key name tag of dbflex connection
+	defaultFieldTag = "json"
+	defaultKeyTag   = "key"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// WhereFilter return And filter of the non zero fields of dm and the non zero values of parm like GetWhereFilter,
+// db name of the fields is using field name tag of the connection
+func WhereFilter(conn dbflex.IConnection, dm DataModel, parm toolkit.M) *dbflex.Filter {
+	return whereFilter(conn, dm, parm)
+}
+
+// whereFilter return And filter of the non zero fields of dm and the non zero values of parm. Key of parm is struct field name or
+// db name of the field and it override the field of dm, key that is not a field and key that start with $ are ignored.
+// Read only fields are included since they can be queried, computed and relation fields are not stored so they are ignored.
+// Value of time field can be pair of from and to time, e.g. []time.Time{from, to}, to filter it using Range, zero time of the pair
+// means the range has no limit on that side. Value that can't be converted to the field type is used as is
+func whereFilter(conn dbflex.IConnection, dm DataModel, parm toolkit.M) *dbflex.Filter {
+	fieldTag, keyTag := defaultFieldTag, defaultKeyTag
+	if conn != nil {
+		fieldTag, keyTag = conn.FieldNameTag(), conn.KeyNameTag()
+	}
+	if parm.Has(WhereFieldTag) {
+		fieldTag = parm.GetString(WhereFieldTag)
+	}
+	meta := GetMetaWithTag(dm, fieldTag, keyTag)
+	v := reflect.Indirect(reflect.ValueOf(dm))
+
+	values := map[*MetaField]interface{}{}
+	for _, f := range meta.Fields {
+		if f.Computed || f.Relation {
+			continue
+		}
+		if fv := v.FieldByIndex(f.Index); !fv.IsZero() {
+			values[f] = fv.Interface()
+		}
+	}
+	for k, value := range parm {
+		f := meta.lookup(k)
+		if strings.HasPrefix(k, "$") || f == nil || f.Computed || f.Relation {
+			continue
+		}
+		if value == nil || reflect.ValueOf(value).IsZero() {
+			delete(values, f)
+			continue
+		}
+		values[f] = value
+	}
+	contains := whereContains(meta, parm.Get(WhereContains))
+
+	filters := []*dbflex.Filter{}
+	for _, f := range meta.Fields {
+		value, ok := values[f]
+		if !ok {
+			continue
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft == timeType {
+			if from, to, isPair := timePair(value); isPair {
+				switch {
+				case !from.IsZero() && !to.IsZero():
+					filters = append(filters, dbflex.Range(f.DbName, from, to))
+				case !from.IsZero():
+					filters = append(filters, dbflex.Gte(f.DbName, from))
+				case !to.IsZero():
+					filters = append(filters, dbflex.Lte(f.DbName, to))
+				}
+				continue
+			}
+		}
+
+		// value of parm is usually decoded from request, convert it to the field type
+		if cv, err := convertValue(value, ft); err == nil {
+			value = cv.Interface()
+		}
+		if ft.Kind() == reflect.String && contains[f] {
+			filters = append(filters, dbflex.Contains(f.DbName, fmt.Sprint(value)))
+			continue
+		}
+		filters = append(filters, dbflex.Eq(f.DbName, value))
+	}
+	return dbflex.And(filters...)
+}
+
+// whereContains return string fields that use Contains of WhereContains option
+func whereContains(meta *ModelMeta, opt interface{}) map[*MetaField]bool {
+	res := map[*MetaField]bool{}
+	switch o := opt.(type) {
+	case bool:
+		if o {
+			for _, f := range meta.Fields {
+				res[f] = true
+			}
+		}
+	case string:
+		if f := meta.lookup(o); f != nil {
+			res[f] = true
+		}
+	case []string:
+		for _, name := range o {
+			if f := meta.lookup(name); f != nil {
+				res[f] = true
+			}
+		}
+	case []interface{}:
+		for _, name := range o {
+			if f := meta.lookup(fmt.Sprint(name)); f != nil {
+				res[f] = true
+			}
+		}
+	}
+	return res
+}
+
+// timePair return from and to time of a pair, it return false if value is not slice of 2 items that are time or text of time
+func timePair(value interface{}) (time.Time, time.Time, bool) {
+	rv := reflect.ValueOf(value)
+	if (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || rv.Len() != 2 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	pair := make([]time.Time, 2)
+	for i := range pair {
+		item := rv.Index(i).Interface()
+		if item == nil || reflect.ValueOf(item).IsZero() {
+			continue
+		}
+		tv, err := convertValue(item, timeType)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		pair[i] = tv.Interface().(time.Time)
+	}
+	return pair[0], pair[1], true
+}
+
+// SetID set the fields that has key tag of the connection in order with given values, value is converted to the field type.
+// It return error if the model has no key or a value can't be converted, the other values are still set
+func SetID(conn dbflex.IConnection, dm DataModel, keys ...interface{}) error {
+	dm.SetThis(dm)
+	return setKeys(GetMeta(conn, dm), dm, keys)
+}
+
+// setKeys set key fields of dm with given values in order, value is converted to the field type
+func setKeys(meta *ModelMeta, dm DataModel, keys []interface{}) error {
+	if len(meta.Keys) == 0 {
+		return fmt.Errorf("dbflex %s.SetID model has no key, please check your object definition", dm.TableName())
+	}
+
+	var res error
+	v := reflect.Indirect(reflect.ValueOf(dm))
+	for i, key := range keys {
+		if i == len(meta.Keys) {
+			break
+		}
+		f := meta.Keys[i]
+		if err := setConvertedValue(v.FieldByIndex(f.Index), key); err != nil && res == nil {
+			res = fmt.Errorf("dbflex %s.SetID field %s: %w", dm.TableName(), f.Name, err)
+		}
+	}
+	return res
+}
+
+// setConvertedValue set field with value that is converted to the field type, nil value set the field to its zero value
+func setConvertedValue(field reflect.Value, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	if field.Kind() == reflect.Ptr {
+		cv, err := convertValue(value, field.Type().Elem())
+		if err != nil {
+			return err
+		}
+		ptr := reflect.New(field.Type().Elem())
+		ptr.Elem().Set(cv)
+		field.Set(ptr)
+		return nil
+	}
+
+	cv, err := convertValue(value, field.Type())
+	if err != nil {
+		return err
+	}
+	field.Set(cv)
+	return nil
+}
+
+// convertValue convert value to given type. Beside Go conversion, value is converted to text using its default format,
+// text is parsed into number, bool or RFC3339 time, and number of decoded JSON is converted into integer.
+// Number that has fraction can't be converted into integer
+func convertValue(value interface{}, t reflect.Type) (reflect.Value, error) {
+	v := reflect.Indirect(reflect.ValueOf(value))
+	if !v.IsValid() {
+		return reflect.Zero(t), nil
+	}
+	if v.Type() == t {
+		return v, nil
+	}
+
+	txt := fmt.Sprint(v.Interface())
+	res := reflect.New(t).Elem()
+	var err error
+	switch {
+	case t.Kind() == reflect.String:
+		// conversion of integer to string return the rune
+		res.SetString(txt)
+
+	case t == timeType:
+		var tm time.Time
+		if tm, err = time.Parse(time.RFC3339, txt); err == nil {
+			res.Set(reflect.ValueOf(tm))
+		}
+
+	case v.Kind() == reflect.String && t.Kind() >= reflect.Int && t.Kind() <= reflect.Float64:
+		var n float64
+		if n, err = strconv.ParseFloat(strings.TrimSpace(txt), 64); err == nil {
+			if err = checkFraction(n, t); err == nil {
+				res = reflect.ValueOf(n).Convert(t)
+			}
+		}
+
+	case v.Kind() == reflect.String && t.Kind() == reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(txt); err == nil {
+			res.SetBool(b)
+		}
+
+	case v.Kind() >= reflect.Float32 && v.Kind() <= reflect.Float64 && t.Kind() >= reflect.Int && t.Kind() <= reflect.Uintptr:
+		if err = checkFraction(v.Float(), t); err == nil {
+			res = v.Convert(t)
+		}
+
+	case v.Type().ConvertibleTo(t):
+		res = v.Convert(t)
+
+	default:
+		err = fmt.Errorf("%v can't be assigned to %s", value, t)
+	}
+	return res, err
+}
+
+// checkFraction return error if t is integer and n has fraction, since converting it would truncate the number
+func checkFraction(n float64, t reflect.Type) error {
+	if t.Kind() >= reflect.Int && t.Kind() <= reflect.Uintptr && n != math.Trunc(n) {
+		return fmt.Errorf("%v has fraction and can't be assigned to %s", n, t)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package dbflex
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -84,6 +85,27 @@ func NewDbPooling(size int, fnNew func() (IConnection, error)) *DbPooling {
 // pool capacity, new connection will be spin off. If capabity has been max out. It will waiting for
 // any connection to be released before timeout reach
 func (p *DbPooling) Get() (*PoolItem, error) {
+	return p.GetContext(context.Background())
+}
+
+// GetContext get new connection like Get, the span of getting connection will follow given context
+func (p *DbPooling) GetContext(ctx context.Context) (*PoolItem, error) {
+	if t := GetTracer(); t != nil {
+		_, span := t.Start(ctx, "dbflex.pool.get")
+		defer span.End()
+
+		pi, err := p.get()
+		span.SetAttribute("db.pool.size", p.Size())
+		span.SetAttribute("db.pool.count", p.Count())
+		if err != nil {
+			span.RecordError(err)
+		}
+		return pi, err
+	}
+	return p.get()
+}
+
+func (p *DbPooling) get() (*PoolItem, error) {
 	timeoutDuration := p.Timeout
 	if int(p.AutoRelease) > 0 {
 		timeoutDuration += p.AutoRelease
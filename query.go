@@ -17,6 +17,10 @@ const (
 	ConfigKeyTableName = "tablenames"
 	// ConfigKeyFilter is key config for Filter
 	ConfigKeyFilter = "filter"
+	// ConfigKeyFields is key config for fields of Select, Insert and Update. It is also set on the cursor
+	ConfigKeyFields = "fields"
+	// ConfigKeyKeys is key config for key fields of Save
+	ConfigKeyKeys = "keys"
 )
 
 // IQuery is interface abstraction fo all query should be supported by each driver
@@ -133,7 +137,7 @@ func buildGroupedQueryItems(cmd ICommand, b IQuery) error {
 		b.This().SetConfig(ConfigKeyCommandType, QuerySelect)
 		fields := selectItem.Value.([]string)
 		if len(fields) > 0 {
-			b.This().SetConfig("fields", fields)
+			b.This().SetConfig(ConfigKeyFields, fields)
 		}
 	} else if _, ok := groupeditems[QueryAggr]; ok {
 		b.This().SetConfig(ConfigKeyCommandType, QuerySelect)
@@ -141,18 +145,21 @@ func buildGroupedQueryItems(cmd ICommand, b IQuery) error {
 		b.This().SetConfig(ConfigKeyCommandType, QueryInsert)
 		fields := insertItem.Value.([]string)
 		if len(fields) > 0 {
-			b.This().SetConfig("fields", fields)
+			b.This().SetConfig(ConfigKeyFields, fields)
 		}
 	} else if updateItem, ok := groupeditems[QueryUpdate]; ok {
 		b.This().SetConfig(ConfigKeyCommandType, QueryUpdate)
 		fields := updateItem.Value.([]string)
 		if len(fields) > 0 {
-			b.This().SetConfig("fields", fields)
+			b.This().SetConfig(ConfigKeyFields, fields)
 		}
 	} else if _, ok := groupeditems[QueryDelete]; ok {
 		b.This().SetConfig(ConfigKeyCommandType, QueryDelete)
-	} else if _, ok = groupeditems[QuerySave]; ok {
+	} else if saveItem, ok := groupeditems[QuerySave]; ok {
 		b.This().SetConfig(ConfigKeyCommandType, QuerySave)
+		if keys, ok := saveItem.Value.([]string); ok && len(keys) > 0 {
+			b.This().SetConfig(ConfigKeyKeys, keys)
+		}
 	} else if _, ok = groupeditems[QuerySQL]; ok {
 		b.This().SetConfig(ConfigKeyCommandType, QuerySQL)
 	} else {
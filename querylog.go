@@ -0,0 +1,267 @@
+package dbflex
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eaciit/toolkit"
+)
+
+// QueryLogEvent is structured log event of a command run through a connection
+type QueryLogEvent struct {
+	Driver      string        `json:"driver"`
+	Table       string        `json:"table"`
+	Action      string        `json:"action"`
+	CommandType string        `json:"commandType"`
+	Command     string        `json:"command"`
+	Data        interface{}   `json:"data,omitempty"`
+	Rows        int           `json:"rows"`
+	Duration    time.Duration `json:"duration"`
+	Slow        bool          `json:"slow"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// QueryLogOptions is configuration of query logging
+type QueryLogOptions struct {
+	// Enabled log every command at debug level
+	Enabled bool
+
+	// SlowThreshold log command that run longer than it at warning level, 0 = disabled
+	SlowThreshold time.Duration
+
+	// RedactTag is struct tag used to mark sensitive field of a model, default is "sensitive"
+	RedactTag string
+
+	// RedactFields is name of fields that always be redacted, also applied to map data
+	RedactFields []string
+
+	// Logger to write the event, default is dbflex.Logger()
+	Logger *toolkit.LogEngine
+
+	// Hook is called for every logged event
+	Hook func(*QueryLogEvent)
+}
+
+// RedactedValue is value written in place of sensitive field
+const RedactedValue = "***"
+
+var (
+	queryLogOpts *QueryLogOptions
+	queryLogMu   sync.RWMutex
+)
+
+// SetQueryLog set query log options, pass nil to disable query logging
+func SetQueryLog(opts *QueryLogOptions) {
+	queryLogMu.Lock()
+	defer queryLogMu.Unlock()
+	queryLogOpts = opts
+}
+
+// QueryLog return current query log options
+func QueryLog() *QueryLogOptions {
+	queryLogMu.RLock()
+	defer queryLogMu.RUnlock()
+	return queryLogOpts
+}
+
+func (o *QueryLogOptions) active() bool {
+	return o != nil && (o.Enabled || o.SlowThreshold > 0 || o.Hook != nil)
+}
+
+func (o *QueryLogOptions) logger() *toolkit.LogEngine {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return Logger()
+}
+
+func (o *QueryLogOptions) redactTag() string {
+	if o.RedactTag == "" {
+		return "sensitive"
+	}
+	return o.RedactTag
+}
+
+func (o *QueryLogOptions) isRedactedField(name string) bool {
+	for _, f := range o.RedactFields {
+		if strings.EqualFold(f, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// newQueryLogEvent build log event from intercept info
+func newQueryLogEvent(o *QueryLogOptions, info *InterceptInfo, rows int, duration time.Duration, err error) *QueryLogEvent {
+	ev := &QueryLogEvent{
+		Driver:   info.Driver,
+		Action:   string(info.Action),
+		Rows:     rows,
+		Duration: duration,
+	}
+
+	if info.Query != nil {
+		ev.Table = info.Query.Config(ConfigKeyTableName, "").(string)
+		ev.CommandType = info.Query.Config(ConfigKeyCommandType, "").(string)
+		if sql, ok := info.NativeCommand.(string); ok && sql != "" {
+			ev.Command = sql
+		} else if f := info.Query.Config(ConfigKeyFilter, nil); f != nil {
+			ev.Command = toolkit.JsonString(f)
+		}
+	}
+
+	if info.Parm != nil {
+		if data, ok := info.Parm["data"]; ok {
+			fieldTag := ""
+			if info.Connection != nil {
+				fieldTag = info.Connection.FieldNameTag()
+			}
+			ev.Data = o.redact(data, fieldTag)
+		}
+	}
+
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	ev.Slow = o.SlowThreshold > 0 && duration >= o.SlowThreshold
+	return ev
+}
+
+func (o *QueryLogOptions) write(ev *QueryLogEvent) {
+	switch {
+	case ev.Error != "":
+		o.logger().Errorf("dbflex query: %s", toolkit.JsonString(ev))
+
+	case ev.Slow:
+		o.logger().Warningf("dbflex slow query: %s", toolkit.JsonString(ev))
+
+	case o.Enabled:
+		o.logger().Debugf("dbflex query: %s", toolkit.JsonString(ev))
+	}
+
+	if o.Hook != nil {
+		o.Hook(ev)
+	}
+}
+
+func logQuery(info *InterceptInfo, rows int, duration time.Duration, err error) {
+	o := QueryLog()
+	if !o.active() {
+		return
+	}
+	o.write(newQueryLogEvent(o, info, rows, duration, err))
+}
+
+// redact return copy of data with sensitive fields replaced by RedactedValue
+func (o *QueryLogOptions) redact(data interface{}, fieldTag string) interface{} {
+	if toolkit.IsNil(data) {
+		return nil
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(data))
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		res := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			res[i] = o.redact(rv.Index(i).Interface(), fieldTag)
+		}
+		return res
+
+	case reflect.Map:
+		res := toolkit.M{}
+		for _, k := range rv.MapKeys() {
+			name := toolkit.Sprintf("%v", k.Interface())
+			if o.isRedactedField(name) {
+				res[name] = RedactedValue
+			} else {
+				res[name] = rv.MapIndex(k).Interface()
+			}
+		}
+		return res
+
+	case reflect.Struct:
+		if _, isTime := rv.Interface().(time.Time); isTime {
+			return data
+		}
+
+		res := toolkit.M{}
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			tf := rt.Field(i)
+			if tf.PkgPath != "" {
+				continue
+			}
+
+			name := tf.Name
+			if fieldTag != "" {
+				if tagName := strings.Split(tf.Tag.Get(fieldTag), ",")[0]; tagName == "-" {
+					continue
+				} else if tagName != "" {
+					name = tagName
+				}
+			}
+
+			if tf.Tag.Get(o.redactTag()) != "" || o.isRedactedField(tf.Name) || o.isRedactedField(name) {
+				res[name] = RedactedValue
+			} else {
+				res[name] = rv.Field(i).Interface()
+			}
+		}
+		return res
+	}
+
+	return data
+}
+
+// logCursor wraps a cursor to log the query once the first fetch is done, or once it is closed if it is never fetched.
+// So duration and slow check of the query are open and first fetch time, not the time the caller keep the cursor open,
+// and rows of the log are the rows read by the first fetch
+type logCursor struct {
+	ICursor
+
+	info   *InterceptInfo
+	logged bool
+}
+
+// log write the query log once
+func (c *logCursor) log(rows int, err error) {
+	if c.logged {
+		return
+	}
+	c.logged = true
+	if err == EOF {
+		err = nil
+	}
+	logQuery(c.info, rows, time.Since(c.info.StartTime), err)
+}
+
+// Fetch single data and log the query if it is the first fetch
+func (c *logCursor) Fetch(out interface{}) ICursor {
+	err := c.ICursor.Fetch(out).Error()
+	rows := 0
+	if err == nil {
+		rows = 1
+	}
+	c.log(rows, err)
+	return c
+}
+
+// Fetchs multiple data and log the query if it is the first fetch
+func (c *logCursor) Fetchs(out interface{}, n int) ICursor {
+	err := c.ICursor.Fetchs(out, n).Error()
+	rows := 0
+	if rv := reflect.Indirect(reflect.ValueOf(out)); err == nil && rv.Kind() == reflect.Slice {
+		rows = rv.Len()
+	}
+	c.log(rows, err)
+	return c
+}
+
+// Close the cursor and write the log if it is not written yet
+func (c *logCursor) Close() error {
+	err := c.ICursor.Close()
+	c.log(0, err)
+	return err
+}
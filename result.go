@@ -0,0 +1,26 @@
+package dbflex
+
+// ExecResult is result of Execute for Insert, Update, Delete and Save command. Drivers return it as pointer
+type ExecResult struct {
+	// RowsMatched is number of rows that match the filter of Update and Delete, or number of existing rows that are updated by Save
+	RowsMatched int64
+	// RowsAffected is number of rows that are inserted, deleted or actually changed by the command
+	RowsAffected int64
+	// InsertedIDs is key of the inserted rows in insert order. It is value of the key field, or slice of values if the table has
+	// compound key, and nil if the row has no key
+	InsertedIDs []interface{}
+}
+
+// ToExecResult return ExecResult of the result returned by Execute. It return empty ExecResult and false
+// if the result is not ExecResult, e.g. the driver doesn't support it yet
+func ToExecResult(res interface{}) (*ExecResult, bool) {
+	switch r := res.(type) {
+	case *ExecResult:
+		if r != nil {
+			return r, true
+		}
+	case ExecResult:
+		return &r, true
+	}
+	return new(ExecResult), false
+}
@@ -0,0 +1,207 @@
+package dbflex
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/eaciit/toolkit"
+)
+
+// RetryPolicy is configuration of RetryConnection
+type RetryPolicy struct {
+	// MaxAttempts is maximum number of attempts including the first one, default is 3
+	MaxAttempts int
+
+	// BaseDelay is delay before the second attempt, it is doubled for every next attempt. Default is 50ms
+	BaseDelay time.Duration
+
+	// MaxDelay is maximum delay between attempts. Default is 2s
+	MaxDelay time.Duration
+
+	// Retryable decide if given error is transient and command can be retried. Default is IsTransientError
+	Retryable func(error) bool
+
+	// RetryWrites also retry Insert, Update, Delete, Save and native command. By default only read is retried
+	RetryWrites bool
+}
+
+// RetryError is returned when command is still failed after retried
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+// Error return message of the last error including number of attempts
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s (after %d attempts)", e.Err.Error(), e.Attempts)
+}
+
+// Unwrap return the last error
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+var transientErrorTexts = []string{
+	"unable to lock file",
+	"lock timeout",
+	"lock wait timeout",
+	"deadlock",
+	"connection reset",
+	"broken pipe",
+	"too many connections",
+	"i/o timeout",
+}
+
+// IsTransientError is default classifier of RetryPolicy, return true for lock timeout and common transient connection errors
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, ErrNotFound) {
+		return false
+	}
+	if errors.Is(err, ErrLockTimeout) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, txt := range transientErrorTexts {
+		if strings.Contains(msg, txt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts <= 0 {
+		return 3
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransientError(err)
+}
+
+// delay return backoff duration before given attempt, with jitter between half and full of the exponential delay
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	base := p.BaseDelay
+	if base <= 0 {
+		base = 50 * time.Millisecond
+	}
+	max := p.MaxDelay
+	if max <= 0 {
+		max = 2 * time.Second
+	}
+
+	d := base << uint(attempt-2)
+	if d <= 0 || d > max {
+		d = max
+	}
+	half := int64(d) / 2
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Run call fn until it succeed, return non retryable error or reach max attempts.
+// Error of fn that has been retried is returned as *RetryError with the number of attempts
+func (p *RetryPolicy) Run(fn func() error) error {
+	var err error
+	max := p.maxAttempts()
+	for attempt := 1; attempt <= max; attempt++ {
+		if attempt > 1 {
+			time.Sleep(p.delay(attempt))
+		}
+
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if attempt > 1 && (attempt == max || !p.retryable(err)) {
+			return &RetryError{Attempts: attempt, Err: err}
+		}
+		if !p.retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// RetryConnection is an IConnection wrapper that retry Execute and opening of Cursor on transient error
+type RetryConnection struct {
+	IConnection
+
+	policy *RetryPolicy
+}
+
+// NewRetryConnection wrap given connection with retry policy
+func NewRetryConnection(conn IConnection, policy *RetryPolicy) *RetryConnection {
+	if policy == nil {
+		policy = new(RetryPolicy)
+	}
+
+	c := new(RetryConnection)
+	c.IConnection = conn
+	c.policy = policy
+	return c
+}
+
+// Connection return the wrapped connection
+func (c *RetryConnection) Connection() IConnection {
+	return c.IConnection
+}
+
+// Policy return retry policy of the connection
+func (c *RetryConnection) Policy() *RetryPolicy {
+	return c.policy
+}
+
+// Execute run the command and retry it on transient error. Write command only retried if RetryWrites is true
+func (c *RetryConnection) Execute(cmd ICommand, m toolkit.M) (interface{}, error) {
+	if !c.policy.RetryWrites && !isReadCommand(cmd) {
+		return c.IConnection.Execute(cmd, m)
+	}
+
+	var res interface{}
+	err := c.policy.Run(func() error {
+		var err error
+		res, err = c.IConnection.Execute(cmd, m)
+		return err
+	})
+	return res, err
+}
+
+// Cursor open the cursor and retry it if it failed to open because of transient error. Only error of opening the cursor is retried,
+// error returned by Fetch or Fetchs of the opened cursor is not retried because part of the data might already be read
+func (c *RetryConnection) Cursor(cmd ICommand, m toolkit.M) ICursor {
+	var cursor ICursor
+	err := c.policy.Run(func() error {
+		if cursor != nil {
+			cursor.Close()
+		}
+		cursor = c.IConnection.Cursor(cmd, m)
+		return cursor.Error()
+	})
+
+	if _, isRetryErr := err.(*RetryError); isRetryErr {
+		res := new(CursorBase)
+		res.SetError(err)
+		return res
+	}
+	return cursor
+}
+
+func isReadCommand(cmd ICommand) bool {
+	items := cmd.Items()
+	for _, op := range []string{QueryInsert, QueryUpdate, QueryDelete, QuerySave, QueryCommand, QuerySQL} {
+		if _, ok := items[op]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+var _ IConnection = (*RetryConnection)(nil)
@@ -0,0 +1,164 @@
+package dbflex
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+const (
+	// AttrContext is command attribute key that holds caller context
+	AttrContext = "dbfcontext"
+
+	// TraceAttrDriver is span attribute for driver name
+	TraceAttrDriver = "db.driver"
+	// TraceAttrTable is span attribute for table name
+	TraceAttrTable = "db.table"
+	// TraceAttrCommandType is span attribute for command type
+	TraceAttrCommandType = "db.command_type"
+	// TraceAttrRows is span attribute for number of rows returned
+	TraceAttrRows = "db.rows"
+)
+
+// ITracer is abstraction of tracing SDK used to create span around driver call
+type ITracer interface {
+	Start(ctx context.Context, name string) (context.Context, ISpan)
+}
+
+// ISpan is abstraction of a single traced operation
+type ISpan interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+var (
+	tracer   ITracer
+	tracerMu sync.RWMutex
+)
+
+// SetTracer set tracer used by all connection and pooling, pass nil to disable tracing
+func SetTracer(t ITracer) {
+	tracerMu.Lock()
+	defer tracerMu.Unlock()
+	tracer = t
+}
+
+// GetTracer return current tracer
+func GetTracer() ITracer {
+	tracerMu.RLock()
+	defer tracerMu.RUnlock()
+	return tracer
+}
+
+// WithContext attach caller context to the command, so spans created for it follow the caller trace
+func WithContext(cmd ICommand, ctx context.Context) ICommand {
+	return cmd.SetAttr(AttrContext, ctx)
+}
+
+// CommandContext return context attached to the command, or background context if none
+func CommandContext(cmd ICommand) context.Context {
+	if cmd != nil {
+		if has, v := cmd.HasAttr(AttrContext); has {
+			if ctx, ok := v.(context.Context); ok && ctx != nil {
+				return ctx
+			}
+		}
+	}
+	return context.Background()
+}
+
+// tracingInterceptor create span for each intercepted action
+func tracingInterceptor(t ITracer) Interceptor {
+	return func(info *InterceptInfo, next InterceptHandler) error {
+		ctx, span := t.Start(CommandContext(info.Command), "dbflex."+string(info.Action))
+		span.SetAttribute(TraceAttrDriver, info.Driver)
+
+		err := next(info)
+		if info.Query != nil {
+			span.SetAttribute(TraceAttrTable, info.Query.Config(ConfigKeyTableName, ""))
+			span.SetAttribute(TraceAttrCommandType, info.Query.Config(ConfigKeyCommandType, ""))
+		}
+		if err != nil {
+			span.RecordError(err)
+		}
+
+		if info.Action == InterceptCursor && err == nil && info.Cursor != nil {
+			// cursor span ends after the first fetch, so it cover opening and reading the first data, and fetch spans will be its children
+			info.Cursor = &traceCursor{ICursor: info.Cursor, tracer: t, ctx: ctx, span: span, driver: info.Driver}
+			return nil
+		}
+
+		span.End()
+		return err
+	}
+}
+
+// traceCursor wraps a cursor to create span for every fetch. Span of the cursor is ended after the first fetch,
+// or when it is closed if it is never fetched, so cursor that is not closed does not leak its span
+type traceCursor struct {
+	ICursor
+
+	tracer ITracer
+	ctx    context.Context
+	span   ISpan
+	driver string
+	ended  bool
+}
+
+// end the cursor span once with rows of the first fetch
+func (c *traceCursor) end(rows int, err error) {
+	if c.ended {
+		return
+	}
+	c.ended = true
+	if err != nil && err != EOF {
+		c.span.RecordError(err)
+	}
+	c.span.SetAttribute(TraceAttrRows, rows)
+	c.span.End()
+}
+
+func (c *traceCursor) fetchSpan(name string, fn func() (int, error)) {
+	_, span := c.tracer.Start(c.ctx, name)
+	span.SetAttribute(TraceAttrDriver, c.driver)
+	rows, err := fn()
+	if err != nil && err != EOF {
+		span.RecordError(err)
+	}
+	span.SetAttribute(TraceAttrRows, rows)
+	span.End()
+	c.end(rows, err)
+}
+
+// Fetch single data within a span
+func (c *traceCursor) Fetch(out interface{}) ICursor {
+	c.fetchSpan("dbflex.fetch", func() (int, error) {
+		if err := c.ICursor.Fetch(out).Error(); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	})
+	return c
+}
+
+// Fetchs multiple data within a span
+func (c *traceCursor) Fetchs(out interface{}, n int) ICursor {
+	c.fetchSpan("dbflex.fetchs", func() (int, error) {
+		if err := c.ICursor.Fetchs(out, n).Error(); err != nil {
+			return 0, err
+		}
+		if rv := reflect.Indirect(reflect.ValueOf(out)); rv.Kind() == reflect.Slice {
+			return rv.Len(), nil
+		}
+		return 0, nil
+	})
+	return c
+}
+
+// Close the cursor and end its span if it is not ended yet
+func (c *traceCursor) Close() error {
+	err := c.ICursor.Close()
+	c.end(0, err)
+	return err
+}
@@ -0,0 +1,288 @@
+package dbflex
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eaciit/toolkit"
+)
+
+// UpdateOp is string represent enumeration of supported update operators
+type UpdateOp string
+
+const (
+	// UpdateSet set the field with the value
+	UpdateSet UpdateOp = "$set"
+	// UpdateInc increase the field by the value, empty field is started from 0
+	UpdateInc = "$inc"
+	// UpdateMul multiply the field by the value, empty field is started from 0
+	UpdateMul = "$mul"
+	// UpdateUnset remove the field, or set it to null for table that has fixed columns
+	UpdateUnset = "$unset"
+	// UpdatePush append the value to array field
+	UpdatePush = "$push"
+	// UpdatePull remove all elements of array field that are equal to the value
+	UpdatePull = "$pull"
+	// UpdateMin set the field with the value if the value is less than the field or the field is empty
+	UpdateMin = "$min"
+	// UpdateMax set the field with the value if the value is greater than the field or the field is empty
+	UpdateMax = "$max"
+)
+
+// UpdateItem holding the operator, field and value of an update
+type UpdateItem struct {
+	Field string
+	Op    UpdateOp
+	Value interface{}
+}
+
+// UpdateDoc is typed update document. It is accepted by Execute as data of Update and Save command to update only its fields,
+// e.g. conn.Execute(dbflex.From("orders").Where(f).Update(), toolkit.M{}.Set("data", dbflex.NewUpdate().Inc("Qty", 1)))
+type UpdateDoc struct {
+	Items []*UpdateItem
+}
+
+// NewUpdate create new update document with given items
+func NewUpdate(items ...*UpdateItem) *UpdateDoc {
+	return &UpdateDoc{Items: items}
+}
+
+func (u *UpdateDoc) add(op UpdateOp, field string, v interface{}) *UpdateDoc {
+	u.Items = append(u.Items, &UpdateItem{Field: field, Op: op, Value: v})
+	return u
+}
+
+// Set add UpdateSet item
+func (u *UpdateDoc) Set(field string, v interface{}) *UpdateDoc {
+	return u.add(UpdateSet, field, v)
+}
+
+// Inc add UpdateInc item
+func (u *UpdateDoc) Inc(field string, v interface{}) *UpdateDoc {
+	return u.add(UpdateInc, field, v)
+}
+
+// Mul add UpdateMul item
+func (u *UpdateDoc) Mul(field string, v interface{}) *UpdateDoc {
+	return u.add(UpdateMul, field, v)
+}
+
+// Unset add UpdateUnset item for each field
+func (u *UpdateDoc) Unset(fields ...string) *UpdateDoc {
+	for _, f := range fields {
+		u.add(UpdateUnset, f, nil)
+	}
+	return u
+}
+
+// Push add UpdatePush item
+func (u *UpdateDoc) Push(field string, v interface{}) *UpdateDoc {
+	return u.add(UpdatePush, field, v)
+}
+
+// Pull add UpdatePull item
+func (u *UpdateDoc) Pull(field string, v interface{}) *UpdateDoc {
+	return u.add(UpdatePull, field, v)
+}
+
+// Min add UpdateMin item
+func (u *UpdateDoc) Min(field string, v interface{}) *UpdateDoc {
+	return u.add(UpdateMin, field, v)
+}
+
+// Max add UpdateMax item
+func (u *UpdateDoc) Max(field string, v interface{}) *UpdateDoc {
+	return u.add(UpdateMax, field, v)
+}
+
+// Fields return distinct fields of the update document in order
+func (u *UpdateDoc) Fields() []string {
+	fields := []string{}
+	seen := map[string]bool{}
+	for _, item := range u.Items {
+		if !seen[item.Field] {
+			seen[item.Field] = true
+			fields = append(fields, item.Field)
+		}
+	}
+	return fields
+}
+
+// ToUpdateDoc return update document of data of Execute, it return false if data is not UpdateDoc
+func ToUpdateDoc(data interface{}) (*UpdateDoc, bool) {
+	switch d := data.(type) {
+	case *UpdateDoc:
+		return d, d != nil
+	case UpdateDoc:
+		return &d, true
+	}
+	return nil, false
+}
+
+// Record return record that is inserted by Save command of the update document when no data match, it is the update applied to empty record
+func (u *UpdateDoc) Record() (toolkit.M, error) {
+	m := toolkit.M{}
+	_, err := u.Apply(m)
+	return m, err
+}
+
+// Apply apply the update to a record, it is used by driver that update the data in memory. Field of the record is matched
+// case insensitively and it is added if not exist. It return true if the record is changed
+func (u *UpdateDoc) Apply(m toolkit.M) (bool, error) {
+	changed := false
+	for _, item := range u.Items {
+		key, exist := item.Field, false
+		for k := range m {
+			if strings.EqualFold(k, item.Field) {
+				key, exist = k, true
+				break
+			}
+		}
+		current := m[key]
+
+		var (
+			value interface{}
+			err   error
+		)
+		switch item.Op {
+		case UpdateSet:
+			value = item.Value
+
+		case UpdateUnset:
+			if exist {
+				delete(m, key)
+				changed = true
+			}
+			continue
+
+		case UpdateInc, UpdateMul:
+			value, err = updateNumber(item.Op, current, item.Value)
+
+		case UpdatePush:
+			value, err = updateArray(current, item.Value, true)
+
+		case UpdatePull:
+			if !exist {
+				continue
+			}
+			value, err = updateArray(current, item.Value, false)
+
+		case UpdateMin, UpdateMax:
+			value = current
+			if current == nil || (item.Op == UpdateMin && compareValue(item.Value, current) < 0) ||
+				(item.Op == UpdateMax && compareValue(item.Value, current) > 0) {
+				value = item.Value
+			}
+
+		default:
+			err = fmt.Errorf("unknown update operator %s", item.Op)
+		}
+		if err != nil {
+			return changed, toolkit.Errorf("update %s fail. %w", item.Field, err)
+		}
+
+		if !exist || toolkit.JsonString(current) != toolkit.JsonString(value) {
+			changed = true
+		}
+		m[key] = value
+	}
+	return changed, nil
+}
+
+// updateNumber return result of UpdateInc or UpdateMul, it is integer if the value is integer and the result is whole number
+func updateNumber(op UpdateOp, current, v interface{}) (interface{}, error) {
+	cur, err := numberValue(current)
+	if err != nil {
+		return nil, err
+	}
+	n, err := numberValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	res := cur + n
+	if op == UpdateMul {
+		res = cur * n
+	}
+	if k := reflect.ValueOf(v).Kind(); k >= reflect.Int && k <= reflect.Uint64 && res == math.Trunc(res) {
+		return int64(res), nil
+	}
+	return res, nil
+}
+
+// numberValue return number of a value, nil and empty text are 0
+func numberValue(v interface{}) (float64, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return 0, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		if strings.TrimSpace(rv.String()) == "" {
+			return 0, nil
+		}
+		return strconv.ParseFloat(strings.TrimSpace(rv.String()), 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// updateArray return array field with the value appended, or with elements that equal to the value removed
+func updateArray(current, v interface{}, push bool) (interface{}, error) {
+	items := []interface{}{}
+	if current != nil {
+		rv := reflect.ValueOf(current)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return nil, fmt.Errorf("%v is not an array", current)
+		}
+		for i := 0; i < rv.Len(); i++ {
+			items = append(items, rv.Index(i).Interface())
+		}
+	}
+
+	if push {
+		return append(items, v), nil
+	}
+	res := []interface{}{}
+	for _, item := range items {
+		if compareValue(item, v) != 0 {
+			res = append(res, item)
+		}
+	}
+	return res, nil
+}
+
+// compareValue compare 2 values as number, time or text, it return -1 if a is less than b, 1 if a is greater than b and 0 if they are equal
+func compareValue(a, b interface{}) int {
+	if na, err := numberValue(a); err == nil {
+		if nb, err := numberValue(b); err == nil {
+			switch {
+			case na < nb:
+				return -1
+			case na > nb:
+				return 1
+			}
+			return 0
+		}
+	}
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			switch {
+			case ta.Before(tb):
+				return -1
+			case ta.After(tb):
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}